@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var deliveryFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "chat_delivery_failures_total",
+	Help: "Messages not acknowledged by a client after a retry.",
+})
+
+// newMsgID возвращает случайный UUID (версия 4) для сообщения.
+func newMsgID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// pendingDelivery — сообщение, отправленное клиенту и еще не подтвержденное им.
+type pendingDelivery struct {
+	msg     Message
+	sentAt  time.Time
+	retried bool
+}
+
+// ackTracker отслеживает неподтвержденные клиентом сообщения.
+type ackTracker struct {
+	pending map[string]pendingDelivery
+	mutex   sync.Mutex
+}
+
+// track запоминает отправленное сообщение до получения type:"ack".
+func (t *ackTracker) track(msg Message) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.pending == nil {
+		t.pending = make(map[string]pendingDelivery)
+	}
+	if _, ok := t.pending[msg.MsgID]; !ok {
+		t.pending[msg.MsgID] = pendingDelivery{msg: msg, sentAt: time.Now()}
+	}
+}
+
+// acknowledge снимает сообщение с отслеживания.
+func (t *ackTracker) acknowledge(msgID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.pending, msgID)
+}
+
+// expired возвращает сообщения, не подтвержденные за timeout: первые нужно
+// отправить повторно, вторые (уже повторенные) считаются недоставленными и
+// снимаются с отслеживания.
+func (t *ackTracker) expired(timeout time.Duration) (retry []Message, failed []Message) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	for id, p := range t.pending {
+		if now.Sub(p.sentAt) < timeout {
+			continue
+		}
+		if p.retried {
+			failed = append(failed, p.msg)
+			delete(t.pending, id)
+			continue
+		}
+		retry = append(retry, p.msg)
+		t.pending[id] = pendingDelivery{msg: p.msg, sentAt: now, retried: true}
+	}
+	return retry, failed
+}
+
+// checkAcks раз в timeout/2 повторяет отправку неподтвержденных сообщений через Send
+// и учитывает окончательно недоставленные. Завершается при отключении клиента.
+func (c *Client) checkAcks(timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		retry, failed := c.acks.expired(timeout)
+		for _, msg := range failed {
+			deliveryFailuresTotal.Inc()
+			c.logger().Warn("Клиент не подтвердил сообщение", "msg_id", msg.MsgID)
+		}
+		for _, msg := range retry {
+			err := c.Send(msg)
+			if err != nil {
+				c.logger().Error("Ошибка повторной отправки сообщения", "msg_id", msg.MsgID, "err", err)
+			}
+		}
+	}
+}
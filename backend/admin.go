@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// requireAdmin пропускает запрос, только если заголовок X-Admin-Token совпадает с adminToken.
+// Пустой adminToken (режим разработки) отключает проверку.
+func requireAdmin(adminToken string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.Method + " " + r.URL.Path
+		if adminToken != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(adminToken)) != 1 {
+			auditLog.Record(auditAdminDenied, "", target, r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		auditLog.Record(auditAdminRequest, auditAdminActor, target, r.RemoteAddr)
+		next(w, r)
+	}
+}
+
+// clientInfo — описание подключенного клиента для GET /clients.
+type clientInfo struct {
+	ID          string    `json:"id"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Username    string    `json:"username"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	// Degraded — отправка клиенту приостановлена (см. circuitBreaker).
+	Degraded bool `json:"degraded"`
+	clientStats
+}
+
+// handleClients возвращает список подключенных клиентов: с заголовком X-Tenant-ID —
+// одного арендатора, без него — всех.
+func (s *Server) handleClients(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenant(r)
+	s.mutex.RLock()
+	list := make([]clientInfo, 0, len(s.clients))
+	for client := range s.clients {
+		if tenant != "" && client.Tenant != tenant {
+			continue
+		}
+		list = append(list, clientInfo{
+			ID:          client.ID,
+			Tenant:      client.Tenant,
+			Username:    client.Username,
+			RemoteAddr:  client.RemoteAddr,
+			ConnectedAt: client.ConnectedAt,
+			Degraded:    client.Degraded(),
+			clientStats: client.Stats(),
+		})
+	}
+	s.mutex.RUnlock()
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleKickClient принудительно отключает клиента по ID: отправляет ему
+// type:"kicked", закрывает соединение и удаляет из списка клиентов.
+func (s *Server) handleKickClient(w http.ResponseWriter, r *http.Request) {
+	client := s.findClientByID(r.PathValue("id"))
+	if client == nil {
+		http.Error(w, "client not found", http.StatusNotFound)
+		return
+	}
+
+	auditLog.Record(auditKick, auditAdminActor, client.ID, r.RemoteAddr, "username", client.Username, "tenant", client.Tenant)
+	s.kickClient(client, "отключен администратором")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleClientStats возвращает статистику трафика одного клиента (GET /clients/{id}/stats).
+func (s *Server) handleClientStats(w http.ResponseWriter, r *http.Request) {
+	client := s.findClientByID(r.PathValue("id"))
+	if client == nil {
+		http.Error(w, "client not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.Stats())
+}
+
+// kickClient уведомляет клиента об отключении и закрывает его соединение.
+func (s *Server) kickClient(client *Client, reason string) {
+	slog.Info("Клиент отключен принудительно", "client_id", client.ID, "remote_addr", client.RemoteAddr, "reason", reason)
+	client.Send(Message{Type: "kicked", Text: reason})
+	client.Close()
+
+	s.mutex.Lock()
+	s.removeClientLocked(client)
+	s.mutex.Unlock()
+}
+
+// findClientByID возвращает подключенного клиента с указанным ID или nil.
+func (s *Server) findClientByID(id string) *Client {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for client := range s.clients {
+		if client.ID == id {
+			return client
+		}
+	}
+	return nil
+}
+
+// messagesPage — ответ GET /messages.
+type messagesPage struct {
+	Total  int       `json:"total"`
+	Offset int       `json:"offset"`
+	Limit  int       `json:"limit"`
+	Items  []Message `json:"items"`
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// handleMessagesList возвращает страницу сохраненных сообщений арендатора запроса в хронологическом
+// порядке. Если база настроена, страница общего чата (комнаты "") читается, как и страница
+// комнаты, из кэша комнат и базы; иначе источник — журнал сообщений, а если он не включен — история в памяти.
+// С параметром room возвращаются сообщения одной комнаты из кэша комнат и базы,
+// с параметром parent_id — прямые ответы на сообщение. Сообщения комнаты только по приглашениям
+// отдаются лишь ее участникам. Без параметра room отдаются только сообщения общего чата:
+// в журнале есть и личные сообщения, и сообщения комнат и тем, их видеть всем нельзя.
+func (s *Server) handleMessagesList(w http.ResponseWriter, r *http.Request) {
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultPageLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit = min(limit, maxPageLimit)
+	tenant := requestTenant(r)
+	parentID := r.URL.Query().Get("parent_id")
+
+	if r.URL.Query().Has("room") || (messageStore != nil && parentID == "") {
+		// Сообщения комнаты только по приглашениям видны лишь ее участникам
+		name := r.URL.Query().Get("room")
+		username, _ := authenticatedUser(r.Context())
+		if room := s.findRoom(tenant, name); room != nil && !isAdminRole(r.Context()) && !room.visibleTo(username) {
+			http.Error(w, "room messages are visible to members only", http.StatusForbidden)
+			return
+		}
+		items, total, err := roomCache.Page(tenant, name, offset, limit)
+		if err != nil {
+			slog.Error("Ошибка чтения сообщений комнаты", "err", err)
+			http.Error(w, "ошибка чтения сообщений", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, messagesPage{Total: total, Offset: offset, Limit: limit, Items: s.unexpired(items)})
+		return
+	}
+
+	var messages []Message
+	switch {
+	case parentID != "" && messageStore != nil:
+		messages, err = messageStore.Replies(tenant, parentID)
+		if err != nil {
+			slog.Error("Ошибка чтения ответов на сообщение", "msg_id", parentID, "err", err)
+			http.Error(w, "ошибка чтения сообщений", http.StatusInternalServerError)
+			return
+		}
+	case s.messageLog != nil:
+		messages, err = s.messageLog.ReadAll()
+		if err != nil {
+			slog.Error("Ошибка чтения журнала сообщений", "err", err)
+			http.Error(w, "ошибка чтения сообщений", http.StatusInternalServerError)
+			return
+		}
+	default:
+		messages = s.history.Messages()
+	}
+	if parentID != "" && messageStore == nil {
+		messages = slices.DeleteFunc(messages, func(msg Message) bool { return msg.ParentMsgID != parentID })
+	}
+	// Журнал сообщений по MESSAGE_TTL_HOURS не очищается, а из базы устаревшие сообщения удаляются раз в purgeInterval
+	messages = publicMessages(inTenant(s.unexpired(messages), tenant))
+
+	writeJSON(w, http.StatusOK, messagesPage{
+		Total:  len(messages),
+		Offset: offset,
+		Limit:  limit,
+		Items:  paginate(messages, offset, limit),
+	})
+}
+
+// publicMessages оставляет сообщения общего чата: без получателя, комнаты и темы.
+func publicMessages(messages []Message) []Message {
+	return slices.DeleteFunc(messages, func(msg Message) bool {
+		return msg.Recipient != "" || msg.Room != "" || msg.Topic != ""
+	})
+}
+
+// paginate возвращает срез [offset, offset+limit); за пределами данных — пустой срез.
+func paginate[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	end := min(offset+limit, len(items))
+	return items[offset:end]
+}
+
+// queryInt читает неотрицательный целочисленный параметр запроса.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("некорректный параметр %s: %q", name, value)
+	}
+	return n, nil
+}
+
+// writeJSON отправляет value в ответе в формате JSON.
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		slog.Error("Ошибка отправки JSON ответа", "err", err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// analyticsInterval — как часто GET /admin/analytics/stream отправляет сводку.
+const analyticsInterval = time.Minute
+
+// analyticsBuckets — число секундных ячеек скользящего окна: окно охватывает последнюю минуту.
+const analyticsBuckets = 60
+
+// rollingWindow — значения за последние analyticsBuckets секунд в кольце ячеек по секунде.
+// Ячейка помнит, к какой секунде относится, и обнуляется, когда кольцо доходит до нее снова.
+// Обновления идут без блокировок; значение, добавленное в момент обнуления ячейки, может потеряться.
+type rollingWindow struct {
+	values [analyticsBuckets]atomic.Int64
+	// seconds — Unix-секунда, к которой относится значение ячейки.
+	seconds [analyticsBuckets]atomic.Int64
+}
+
+// bucket возвращает ячейку текущей секунды now, обнуляя ее, если она осталась от прошлого круга.
+func (w *rollingWindow) bucket(now int64) *atomic.Int64 {
+	i := now % analyticsBuckets
+	if old := w.seconds[i].Load(); old != now && w.seconds[i].CompareAndSwap(old, now) {
+		w.values[i].Store(0)
+	}
+	return &w.values[i]
+}
+
+// Add прибавляет n к текущей секунде.
+func (w *rollingWindow) Add(n int64) {
+	w.bucket(time.Now().Unix()).Add(n)
+}
+
+// Observe запоминает v, если оно больше уже записанного в текущую секунду.
+func (w *rollingWindow) Observe(v int64) {
+	value := w.bucket(time.Now().Unix())
+	for {
+		old := value.Load()
+		if v <= old || value.CompareAndSwap(old, v) {
+			return
+		}
+	}
+}
+
+// Sum возвращает сумму значений за последнюю минуту.
+func (w *rollingWindow) Sum() int64 {
+	var sum int64
+	w.each(func(v int64) { sum += v })
+	return sum
+}
+
+// Max возвращает наибольшее значение за последнюю минуту.
+func (w *rollingWindow) Max() int64 {
+	var peak int64
+	w.each(func(v int64) { peak = max(peak, v) })
+	return peak
+}
+
+// each передает fn значения ячеек, относящихся к последней минуте.
+func (w *rollingWindow) each(fn func(int64)) {
+	now := time.Now().Unix()
+	for i := range w.values {
+		if now-w.seconds[i].Load() < analyticsBuckets {
+			fn(w.values[i].Load())
+		}
+	}
+}
+
+// Analytics считает события сервера в скользящем окне в одну минуту для живой панели оператора.
+type Analytics struct {
+	messages       rollingWindow
+	connections    rollingWindow
+	disconnections rollingWindow
+	// concurrency — наибольшее число подключенных клиентов по секундам.
+	concurrency rollingWindow
+}
+
+// analyticsSnapshot — сводка за последнюю минуту (событие GET /admin/analytics/stream).
+type analyticsSnapshot struct {
+	MessagesLastMinute        int64     `json:"messages_last_minute"`
+	NewConnectionsLastMinute  int64     `json:"new_connections_last_minute"`
+	DisconnectionsLastMinute  int64     `json:"disconnections_last_minute"`
+	ActiveRooms               int       `json:"active_rooms"`
+	PeakConcurrencyLastMinute int64     `json:"peak_concurrency_last_minute"`
+	At                        time.Time `json:"at"`
+}
+
+// setupAnalytics подписывает счетчики аналитики на события сервера.
+func (s *Server) setupAnalytics() {
+	s.events.On(EventMessageBroadcast, func(Event) {
+		s.analytics.messages.Add(1)
+	})
+	s.events.On(EventClientConnect, func(Event) {
+		s.analytics.connections.Add(1)
+		s.analytics.concurrency.Observe(int64(s.ConnectedClients()))
+	})
+	s.events.On(EventClientDisconnect, func(Event) {
+		s.analytics.disconnections.Add(1)
+	})
+}
+
+// analyticsSnapshot собирает сводку за последнюю минуту.
+func (s *Server) analyticsSnapshot() analyticsSnapshot {
+	connected := int64(s.ConnectedClients())
+	return analyticsSnapshot{
+		MessagesLastMinute:       s.analytics.messages.Sum(),
+		NewConnectionsLastMinute: s.analytics.connections.Sum(),
+		DisconnectionsLastMinute: s.analytics.disconnections.Sum(),
+		ActiveRooms:              s.activeRooms(),
+		// Подключения отмечаются при входе клиентов; если за минуту никто не входил, пик — текущее число
+		PeakConcurrencyLastMinute: max(s.analytics.concurrency.Max(), connected),
+		At:                        time.Now().UTC(),
+	}
+}
+
+// activeRooms возвращает число комнат, в которых есть хотя бы один участник.
+func (s *Server) activeRooms() int {
+	s.roomsMutex.Lock()
+	defer s.roomsMutex.Unlock()
+	active := 0
+	for _, room := range s.rooms {
+		room.mutex.Lock()
+		if len(room.members) > 0 {
+			active++
+		}
+		room.mutex.Unlock()
+	}
+	return active
+}
+
+// handleAnalyticsStream отдает по SSE сводку за последнюю минуту: сразу при подключении
+// и затем раз в analyticsInterval (GET /admin/analytics/stream). Каждое событие —
+// "event: analytics\ndata: <json>\n\n".
+func (s *Server) handleAnalyticsStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(analyticsInterval)
+	defer ticker.Stop()
+	for {
+		data, err := json.Marshal(s.analyticsSnapshot())
+		if err != nil {
+			slog.Error("Ошибка сериализации сводки аналитики", "err", err)
+			return
+		}
+		_, err = fmt.Fprintf(w, "event: analytics\ndata: %s\n\n", data)
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.stopping:
+			return
+		case <-ticker.C:
+		}
+	}
+}
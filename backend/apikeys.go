@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+// apiKeyPermissions — права, которые можно выдать API ключу.
+var apiKeyPermissions = []string{"send", "subscribe"}
+
+// APIKey — ключ для программных клиентов, которым неудобно получать JWT.
+type APIKey struct {
+	Key string `json:"key"`
+	// Owner — имя сервиса; становится именем клиента.
+	Owner string `json:"owner"`
+	// Permissions — права ключа: "send" (отправка сообщений) и "subscribe" (получение рассылки).
+	Permissions []string `json:"permissions"`
+	// Tenant — арендатор, к которому относится ключ; пустое значение — арендатор по умолчанию.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Allows сообщает, есть ли у ключа право permission.
+func (k APIKey) Allows(permission string) bool {
+	return slices.Contains(k.Permissions, permission)
+}
+
+// APIKeys — набор API ключей из файла. Файл можно перечитать без перезапуска (SIGHUP).
+type APIKeys struct {
+	path string
+	// keys — ключи по SHA-256 их значения, чтобы время поиска не зависело от совпадения префикса.
+	keys  map[[sha256.Size]byte]APIKey
+	mutex sync.RWMutex
+}
+
+// apiKeys — API ключи. Создается в main, если задан APIKeysFile.
+var apiKeys = &APIKeys{}
+
+// loadAPIKeys читает ключи из файла path (JSON массив APIKey).
+func loadAPIKeys(path string) (*APIKeys, error) {
+	k := &APIKeys{path: path}
+	return k, k.Reload()
+}
+
+// Reload перечитывает файл ключей. При ошибке прежние ключи остаются в силе.
+func (k *APIKeys) Reload() error {
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+	var list []APIKey
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return fmt.Errorf("разбор %s: %w", k.path, err)
+	}
+
+	keys := make(map[[sha256.Size]byte]APIKey, len(list))
+	for i, key := range list {
+		if key.Key == "" || key.Owner == "" {
+			return fmt.Errorf("%s: у ключа %d не задано key или owner", k.path, i)
+		}
+		for _, permission := range key.Permissions {
+			if !slices.Contains(apiKeyPermissions, permission) {
+				return fmt.Errorf("%s: неизвестное право %q у ключа %s", k.path, permission, key.Owner)
+			}
+		}
+		hash := sha256.Sum256([]byte(key.Key))
+		if _, ok := keys[hash]; ok {
+			return fmt.Errorf("%s: ключ %s повторяется", k.path, key.Owner)
+		}
+		keys[hash] = key
+	}
+
+	k.mutex.Lock()
+	k.keys = keys
+	k.mutex.Unlock()
+	return nil
+}
+
+// Lookup возвращает API ключ по его значению.
+func (k *APIKeys) Lookup(key string) (APIKey, bool) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	apiKey, ok := k.keys[sha256.Sum256([]byte(key))]
+	return apiKey, ok
+}
+
+// Len возвращает число загруженных ключей.
+func (k *APIKeys) Len() int {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return len(k.keys)
+}
+
+// reloadAPIKeysOnHangup перечитывает ключи по сигналу SIGHUP, пока не отменен ctx.
+func reloadAPIKeysOnHangup(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+		}
+
+		err := apiKeys.Reload()
+		if err != nil {
+			slog.Error("Ошибка перечитывания API ключей, остаются прежние", "file", apiKeys.path, "err", err)
+			continue
+		}
+		slog.Info("API ключи перечитаны", "file", apiKeys.path, "count", apiKeys.Len())
+	}
+}
+
+// errUnknownAPIKey — переданный X-API-Key не найден среди загруженных ключей.
+var errUnknownAPIKey = errors.New("неизвестный API ключ")
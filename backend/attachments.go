@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// maxAttachments — сколько вложений может быть в одном сообщении.
+const maxAttachments = 10
+
+// AttachmentMeta описывает файл, приложенный к сообщению. Сам файл сервер не хранит:
+// он лежит по URL (обычно в CDN), а сервер проверяет только описание (см. validateAttachments).
+type AttachmentMeta struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	MimeType string `json:"mime_type"`
+	URL      string `json:"url"`
+}
+
+// validateAttachments проверяет вложения сообщения по настройкам cfg: адрес — https на домене
+// из AllowedAttachmentDomains (или его поддомене), размер — не больше MaxAttachmentSizeBytes,
+// тип — из AllowedAttachmentMimeTypes. Возвращает ошибку с описанием первого неверного вложения.
+func validateAttachments(cfg Config, attachments []AttachmentMeta) error {
+	if len(attachments) > maxAttachments {
+		return fmt.Errorf("слишком много вложений: %d при лимите %d", len(attachments), maxAttachments)
+	}
+	for _, attachment := range attachments {
+		err := validateAttachment(cfg, attachment)
+		if err != nil {
+			return fmt.Errorf("вложение %q: %w", attachment.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateAttachment проверяет одно вложение (см. validateAttachments).
+func validateAttachment(cfg Config, attachment AttachmentMeta) error {
+	if strings.TrimSpace(attachment.Name) == "" {
+		return errors.New("не указано имя файла")
+	}
+	if attachment.Size <= 0 || attachment.Size > int64(cfg.MaxAttachmentSizeBytes) {
+		return fmt.Errorf("размер %d байт вне допустимого (до %d байт)", attachment.Size, cfg.MaxAttachmentSizeBytes)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(attachment.MimeType)
+	if err != nil {
+		return fmt.Errorf("некорректный тип %q", attachment.MimeType)
+	}
+	if !mimeTypeAllowed(cfg.AllowedAttachmentMimeTypes, mediaType) {
+		return fmt.Errorf("тип %s не разрешен", mediaType)
+	}
+
+	u, err := url.Parse(attachment.URL)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+		return fmt.Errorf("некорректный адрес %q: нужен https URL", attachment.URL)
+	}
+	if !domainAllowed(cfg.AllowedAttachmentDomains, u.Hostname()) {
+		return fmt.Errorf("домен %s не разрешен", u.Hostname())
+	}
+	return nil
+}
+
+// mimeTypeAllowed сообщает, есть ли mediaType в списке allowed. Элемент вида "image/*"
+// разрешает все подтипы.
+func mimeTypeAllowed(allowed []string, mediaType string) bool {
+	major, _, _ := strings.Cut(mediaType, "/")
+	return slices.ContainsFunc(allowed, func(pattern string) bool {
+		pattern = strings.ToLower(pattern)
+		return pattern == mediaType || pattern == major+"/*"
+	})
+}
+
+// domainAllowed сообщает, совпадает ли host с доменом из allowed или является его поддоменом.
+func domainAllowed(allowed []string, host string) bool {
+	host = strings.ToLower(host)
+	return slices.ContainsFunc(allowed, func(domain string) bool {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	})
+}
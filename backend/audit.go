@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Действия журнала аудита.
+const (
+	auditLogin         = "auth.login"
+	auditLoginFailed   = "auth.login_failed"
+	auditRefresh       = "auth.refresh"
+	auditRefreshFailed = "auth.refresh_failed"
+	auditKick          = "client.kick"
+	auditBlockAdd      = "blocklist.add"
+	auditUserMute      = "user.mute"
+	auditUserUnmute    = "user.unmute"
+	auditBlockRemove   = "blocklist.remove"
+	auditAdminRequest  = "admin.request"
+	auditAdminDenied   = "admin.denied"
+)
+
+// auditAdminActor — исполнитель вызовов административного API: X-Admin-Token не называет пользователя.
+const auditAdminActor = "admin_token"
+
+// AuditLog — журнал аудита (JSON, одна запись на строку), отдельный от журнала приложения:
+// входы и отказы во входе, обновления токенов, отключения клиентов, изменения списка
+// блокировки и вызовы административного API. Файл дописывается и при запуске не очищается;
+// по SIGUSR1 он открывается заново, чтобы внешняя ротация (logrotate) могла его переименовать.
+type AuditLog struct {
+	path   string
+	file   *os.File
+	logger *slog.Logger
+	// mutex защищает file: запись и переоткрытие идут из разных горутин.
+	mutex sync.Mutex
+}
+
+// auditLog — журнал аудита; nil, если AUDIT_LOG_FILE не задан.
+var auditLog *AuditLog
+
+// openAuditLog открывает файл журнала аудита на дозапись, создавая его при необходимости.
+func openAuditLog(path string) (*AuditLog, error) {
+	a := &AuditLog{path: path}
+	err := a.Reopen()
+	if err != nil {
+		return nil, err
+	}
+	a.logger = slog.New(slog.NewJSONHandler(a, nil))
+	return a, nil
+}
+
+// Reopen закрывает текущий файл и открывает файл по прежнему пути.
+func (a *AuditLog) Reopen() error {
+	file, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.file != nil {
+		a.file.Close()
+	}
+	a.file = file
+	return nil
+}
+
+// Write дописывает в файл одну запись, сформированную slog.
+func (a *AuditLog) Write(p []byte) (int, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.file.Write(p)
+}
+
+// Record записывает действие action: actor — кто его совершил (sub JWT, владелец API ключа,
+// имя администратора), target — над кем (ID клиента, диапазон адресов, путь запроса),
+// remoteAddr — откуда пришел запрос. Вызов на nil журнале ничего не делает.
+func (a *AuditLog) Record(action, actor, target, remoteAddr string, attrs ...any) {
+	if a == nil {
+		return
+	}
+	sourceIP := remoteAddr
+	if ip, ok := parseIP(remoteAddr); ok {
+		sourceIP = ip.String()
+	}
+	a.logger.Info(action, append([]any{"action", action, "actor", actor, "target", target, "source_ip", sourceIP}, attrs...)...)
+}
+
+// Close закрывает файл журнала.
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.file.Close()
+}
+
+// reopenAuditLogOnSignal открывает журнал аудита заново по сигналу SIGUSR1, пока не отменен ctx.
+func reopenAuditLogOnSignal(ctx context.Context) {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	defer signal.Stop(usr1)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-usr1:
+		}
+
+		err := auditLog.Reopen()
+		if err != nil {
+			slog.Error("Ошибка переоткрытия журнала аудита", "file", auditLog.path, "err", err)
+			continue
+		}
+		slog.Info("Журнал аудита открыт заново", "file", auditLog.path)
+	}
+}
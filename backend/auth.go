@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// authUserKey — ключ контекста запроса, под которым хранится имя аутентифицированного пользователя.
+type authUserKey struct{}
+
+// authEmailKey — ключ контекста запроса, под которым хранится адрес почты из поля email JWT.
+type authEmailKey struct{}
+
+// authPermissionsKey — ключ контекста запроса, под которым хранятся права клиента, вошедшего по API ключу.
+type authPermissionsKey struct{}
+
+// chatClaims — поля JWT чата: стандартные и арендатор с ролью пользователя.
+type chatClaims struct {
+	jwt.RegisteredClaims
+	// Tenant — арендатор пользователя; пустое значение — арендатор по умолчанию.
+	Tenant string `json:"tenant,omitempty"`
+	// Role — роль пользователя; "admin" открывает доступ к данным всех арендаторов.
+	Role string `json:"role,omitempty"`
+	// Email — адрес почты пользователя для писем о личных сообщениях.
+	Email string `json:"email,omitempty"`
+}
+
+// requireAuth проверяет запрос перед WebSocket upgrade или вызовом REST эндпоинта.
+// Программные клиенты передают заголовок X-API-Key: ключу нужно право permission,
+// а имя клиента — владелец ключа. Остальные передают JWT в заголовке
+// "Authorization: Bearer <token>" или, если его нет, в параметре запроса ?token=;
+// поле sub токена становится именем клиента, а поля tenant и role — его арендатором
+// и ролью (см. requestTenant). При пустом secret JWT не требуется.
+func requireAuth(secret, permission string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			apiKey, ok := apiKeys.Lookup(key)
+			if !ok {
+				slog.Warn("Отказ в подключении: ошибка аутентификации", "remote_addr", r.RemoteAddr, "err", errUnknownAPIKey)
+				auditLog.Record(auditLoginFailed, "", r.URL.Path, r.RemoteAddr, "method", "api_key", "err", errUnknownAPIKey)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !apiKey.Allows(permission) {
+				slog.Warn("Отказ в подключении: у API ключа нет права", "remote_addr", r.RemoteAddr, "owner", apiKey.Owner, "permission", permission)
+				auditLog.Record(auditLoginFailed, apiKey.Owner, r.URL.Path, r.RemoteAddr, "method", "api_key", "err", "нет права "+permission)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			auditLog.Record(auditLogin, apiKey.Owner, r.URL.Path, r.RemoteAddr, "method", "api_key")
+			ctx := context.WithValue(r.Context(), authUserKey{}, apiKey.Owner)
+			ctx = context.WithValue(ctx, authPermissionsKey{}, apiKey.Permissions)
+			ctx = context.WithValue(ctx, authTenantKey{}, apiKey.Tenant)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		if secret == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := parseJWTClaims(secret, tokenFromRequest(r))
+		if err != nil {
+			slog.Warn("Отказ в подключении: ошибка аутентификации", "remote_addr", r.RemoteAddr, "err", err)
+			auditLog.Record(auditLoginFailed, "", r.URL.Path, r.RemoteAddr, "method", "jwt", "err", err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		auditLog.Record(auditLogin, claims.Subject, r.URL.Path, r.RemoteAddr, "method", "jwt", "tenant", claims.Tenant)
+
+		ctx := context.WithValue(r.Context(), authUserKey{}, claims.Subject)
+		ctx = context.WithValue(ctx, authTenantKey{}, claims.Tenant)
+		ctx = context.WithValue(ctx, authRoleKey{}, claims.Role)
+		ctx = context.WithValue(ctx, authEmailKey{}, claims.Email)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// tokenFromRequest извлекает токен из заголовка Authorization или параметра token.
+func tokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, found := strings.CutPrefix(header, "Bearer ")
+		if found {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.URL.Query().Get("token")
+}
+
+// parseJWT проверяет подпись (HMAC) и срок действия токена и возвращает поле sub.
+func parseJWT(secret, tokenString string) (string, error) {
+	claims, err := parseJWTClaims(secret, tokenString)
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// parseJWTClaims проверяет токен как parseJWT и возвращает его поля.
+// Токены, обменянные на новые через /auth/refresh, не принимаются.
+func parseJWTClaims(secret, tokenString string) (*chatClaims, error) {
+	if tokenString == "" {
+		return nil, errors.New("токен не передан")
+	}
+
+	claims := &chatClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(*jwt.Token) (any, error) {
+		return []byte(secret), nil
+	}, jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}))
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("в токене нет поля sub")
+	}
+	if deniedTokens.Contains(tokenString) {
+		return nil, errors.New("токен отозван: он уже обменян на новый")
+	}
+	return claims, nil
+}
+
+// authenticatedUser возвращает имя пользователя, сохраненное requireAuth в контексте запроса.
+func authenticatedUser(ctx context.Context) (string, bool) {
+	username, ok := ctx.Value(authUserKey{}).(string)
+	return username, ok
+}
+
+// authAllows сообщает, есть ли у клиента право permission. Ограничены права только
+// у клиентов, вошедших по API ключу; JWT и отключенная аутентификация разрешают все.
+func authAllows(ctx context.Context, permission string) bool {
+	permissions, ok := ctx.Value(authPermissionsKey{}).([]string)
+	return !ok || slices.Contains(permissions, permission)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+// throttleLogThreshold — сколько задержек отправки за минуту допустимо, прежде чем клиент попадет в журнал.
+const throttleLogThreshold = 10
+
+var bandwidthThrottledTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "chat_bandwidth_throttled_total",
+	Help: "Sends to clients delayed by the per-client outbound bandwidth limit.",
+})
+
+// bandwidthLimiter ограничивает исходящий трафик клиента токен-бакетом: в бакете помещается
+// секунда трафика, так что короткий всплеск уходит сразу, а дальше отправка идет со
+// скоростью MaxBytesPerSecPerClient. Размер кадра известен только после кодирования,
+// поэтому отправленные байты списываются после записи, а ждет следующая отправка.
+// Используется только горутиной writeLoop клиента.
+type bandwidthLimiter struct {
+	limiter *rate.Limiter
+	// readyAt — когда бакет снова покроет уже отправленные байты.
+	readyAt time.Time
+	// throttled — сколько раз отправка ждала с начала минуты windowStart.
+	throttled   int
+	windowStart time.Time
+}
+
+// newBandwidthLimiter создает ограничение bytesPerSec байт в секунду; 0 — без ограничения (nil).
+func newBandwidthLimiter(bytesPerSec int) *bandwidthLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{limiter: rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec)}
+}
+
+// wait ждет, пока бакет покроет прежние отправки, или закрытия клиента (тогда возвращает false).
+func (b *bandwidthLimiter) wait(c *Client) bool {
+	delay := time.Until(b.readyAt)
+	if delay <= 0 {
+		return true
+	}
+
+	bandwidthThrottledTotal.Inc()
+	now := time.Now()
+	if now.Sub(b.windowStart) >= time.Minute {
+		b.windowStart = now
+		b.throttled = 0
+	}
+	b.throttled++
+	if b.throttled == throttleLogThreshold+1 {
+		c.logger().Warn("Отправка клиенту часто упирается в ограничение трафика", "throttled_per_minute", b.throttled, "bytes_per_sec", int(b.limiter.Limit()))
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// spend списывает n отправленных байт. Кадр крупнее бакета списывается по размеру бакета.
+func (b *bandwidthLimiter) spend(n uint64) {
+	if n == 0 {
+		return
+	}
+	now := time.Now()
+	reservation := b.limiter.ReserveN(now, min(int(n), b.limiter.Burst()))
+	b.readyAt = now.Add(reservation.DelayFrom(now))
+}
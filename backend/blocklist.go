@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Blocklist — список запрещенных диапазонов IP адресов (CIDR), сохраняемый в файл.
+type Blocklist struct {
+	prefixes []netip.Prefix
+	// path — файл, в который сохраняется список. Пустой путь отключает сохранение.
+	path  string
+	mutex sync.RWMutex
+}
+
+// blocklist — запрещенные диапазоны адресов. Создается в main.
+var blocklist = &Blocklist{}
+
+// loadBlocklist читает список из файла path (JSON массив строк CIDR), если файл существует.
+func loadBlocklist(path string) (*Blocklist, error) {
+	b := &Blocklist{path: path}
+	if path == "" {
+		return b, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cidrs []string
+	err = json.Unmarshal(data, &cidrs)
+	if err != nil {
+		return nil, fmt.Errorf("разбор %s: %w", path, err)
+	}
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("разбор %s: %w", path, err)
+		}
+		b.prefixes = append(b.prefixes, prefix.Masked())
+	}
+	return b, nil
+}
+
+// Add добавляет диапазон и сохраняет список. Повторное добавление ничего не меняет.
+func (b *Blocklist) Add(prefix netip.Prefix) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	prefix = prefix.Masked()
+	if slices.Contains(b.prefixes, prefix) {
+		return nil
+	}
+	b.prefixes = append(b.prefixes, prefix)
+	return b.saveLocked()
+}
+
+// Remove удаляет диапазон и сохраняет список. Возвращает false, если диапазона не было.
+func (b *Blocklist) Remove(prefix netip.Prefix) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	i := slices.Index(b.prefixes, prefix.Masked())
+	if i < 0 {
+		return false, nil
+	}
+	b.prefixes = slices.Delete(b.prefixes, i, i+1)
+	return true, b.saveLocked()
+}
+
+// Blocked сообщает, попадает ли адрес вида "host:port" или "host" в запрещенный диапазон.
+func (b *Blocklist) Blocked(addr string) bool {
+	ip, ok := parseIP(addr)
+	if !ok {
+		return false
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for _, prefix := range b.prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// List возвращает копию списка диапазонов.
+func (b *Blocklist) List() []string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	list := make([]string, len(b.prefixes))
+	for i, prefix := range b.prefixes {
+		list[i] = prefix.String()
+	}
+	return list
+}
+
+// saveLocked записывает список в файл. Вызывающий должен удерживать mutex.
+func (b *Blocklist) saveLocked() error {
+	if b.path == "" {
+		return nil
+	}
+	list := make([]string, len(b.prefixes))
+	for i, prefix := range b.prefixes {
+		list[i] = prefix.String()
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(b.path, data, 0o644)
+}
+
+// parseIP извлекает IP адрес из "host:port" или "host".
+func parseIP(addr string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+// rejectBlocked отклоняет HTTP запросы (в том числе WebSocket upgrade) с запрещенных адресов.
+func rejectBlocked(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if blocklist.Blocked(r.RemoteAddr) {
+			slog.Warn("Отказ в подключении: адрес в списке блокировки", "remote_addr", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// blocklistRequest — тело POST /admin/blocklist.
+type blocklistRequest struct {
+	CIDR string `json:"cidr"`
+}
+
+// handleBlocklistAdd добавляет диапазон в список блокировки и отключает уже подключенных из него клиентов.
+func (s *Server) handleBlocklistAdd(w http.ResponseWriter, r *http.Request) {
+	var req blocklistRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "некорректный JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	prefix, err := netip.ParsePrefix(req.CIDR)
+	if err != nil {
+		http.Error(w, "некорректный CIDR: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = blocklist.Add(prefix)
+	if err != nil {
+		slog.Error("Ошибка сохранения списка блокировки", "err", err)
+		http.Error(w, "ошибка сохранения списка блокировки", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Диапазон добавлен в список блокировки", "cidr", prefix.Masked())
+	auditLog.Record(auditBlockAdd, auditAdminActor, prefix.Masked().String(), r.RemoteAddr)
+
+	// Отключаем клиентов, которые уже подключены из этого диапазона
+	s.mutex.RLock()
+	var blocked []*Client
+	for client := range s.clients {
+		if ip, ok := parseIP(client.RemoteAddr); ok && prefix.Masked().Contains(ip) {
+			blocked = append(blocked, client)
+		}
+	}
+	s.mutex.RUnlock()
+	for _, client := range blocked {
+		s.kickClient(client, "адрес заблокирован")
+	}
+
+	writeJSON(w, http.StatusCreated, blocklistRequest{CIDR: prefix.Masked().String()})
+}
+
+// handleBlocklistRemove удаляет диапазон из списка блокировки.
+func (s *Server) handleBlocklistRemove(w http.ResponseWriter, r *http.Request) {
+	prefix, err := netip.ParsePrefix(r.PathValue("cidr"))
+	if err != nil {
+		http.Error(w, "некорректный CIDR: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	removed, err := blocklist.Remove(prefix)
+	if err != nil {
+		slog.Error("Ошибка сохранения списка блокировки", "err", err)
+		http.Error(w, "ошибка сохранения списка блокировки", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "cidr not found", http.StatusNotFound)
+		return
+	}
+	slog.Info("Диапазон удален из списка блокировки", "cidr", prefix.Masked())
+	auditLog.Record(auditBlockRemove, auditAdminActor, prefix.Masked().String(), r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleBlocklistList возвращает текущий список блокировки.
+func (s *Server) handleBlocklistList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, blocklist.List())
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// breakerWindow — окно, в котором считаются ошибки отправки клиенту.
+	breakerWindow = time.Minute
+	// breakerProbeInterval — как часто разомкнутая цепь пропускает одну пробную отправку.
+	breakerProbeInterval = 30 * time.Second
+)
+
+// Состояния circuitBreaker.
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+var clientCircuitOpenTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "chat_client_circuit_open_total",
+	Help: "Times a client's send circuit breaker opened.",
+})
+
+// circuitBreaker перестает отправлять сообщения клиенту, отправка которому постоянно
+// завершается сетевыми ошибками. Больше threshold ошибок за breakerWindow размыкают
+// цепь; раз в breakerProbeInterval цепь пропускает одну пробную отправку, и ее успех
+// снова замыкает цепь.
+type circuitBreaker struct {
+	threshold int
+	state     string
+	// failures — время ошибок отправки в пределах breakerWindow.
+	failures []time.Time
+	// openedAt — когда цепь разомкнулась или провалилась последняя пробная отправка.
+	openedAt time.Time
+	mutex    sync.Mutex
+}
+
+func newCircuitBreaker(threshold int) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, state: circuitClosed}
+}
+
+// allow сообщает, можно ли сейчас отправлять. В разомкнутой цепи по истечении
+// breakerProbeInterval разрешает одну пробную отправку и возвращает новое состояние.
+func (b *circuitBreaker) allow(now time.Time) (bool, string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch {
+	case b.state == circuitClosed:
+		return true, ""
+	case b.state == circuitOpen && now.Sub(b.openedAt) >= breakerProbeInterval:
+		b.state = circuitHalfOpen
+		return true, circuitHalfOpen
+	default:
+		return false, ""
+	}
+}
+
+// success учитывает успешную отправку и возвращает новое состояние, если оно изменилось.
+func (b *circuitBreaker) success() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state == circuitClosed {
+		return ""
+	}
+	b.state = circuitClosed
+	b.failures = nil
+	return circuitClosed
+}
+
+// failure учитывает ошибку отправки и возвращает новое состояние, если оно изменилось.
+func (b *circuitBreaker) failure(now time.Time) string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state == circuitHalfOpen {
+		b.state = circuitOpen
+		b.openedAt = now
+		return circuitOpen
+	}
+
+	b.failures = append(b.failures, now)
+	for len(b.failures) > 0 && now.Sub(b.failures[0]) > breakerWindow {
+		b.failures = b.failures[1:]
+	}
+	if b.state == circuitClosed && len(b.failures) > b.threshold {
+		b.state = circuitOpen
+		b.openedAt = now
+		clientCircuitOpenTotal.Inc()
+		return circuitOpen
+	}
+	return ""
+}
+
+// current возвращает текущее состояние цепи.
+func (b *circuitBreaker) current() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
@@ -0,0 +1,49 @@
+package main
+
+import "slices"
+
+// Bridge решает, переходят ли сообщения между клиентами WebSocket и TCP.
+//
+// Все протоколы разбирают одну очередь рассылки (Server.broadcast), поэтому мост ничего
+// не переиздает: он только пропускает при рассылке сообщения с Message.Source "ws"
+// к клиентам TCP и обратно. Сообщение рассылается один раз и никогда не возвращается
+// в очередь, так что петли не возникает; Source сохраняется и в сообщениях из Redis,
+// и другие экземпляры применяют к ним то же правило.
+//
+// Без моста (ENABLE_BRIDGE не задан) WebSocket и TCP клиенты не видят сообщений
+// друг друга в общем чате, комнатах и темах. Личные сообщения, сообщения клиентов
+// gRPC, MQTT и long-polling (без Source) и сообщения самого сервера доставляются всем.
+type Bridge struct {
+	enabled bool
+}
+
+// newBridge создает мост; enabled — Config.EnableBridge.
+func newBridge(enabled bool) *Bridge {
+	return &Bridge{enabled: enabled}
+}
+
+// Forward сообщает, доставлять ли сообщение msg клиенту client.
+func (b *Bridge) Forward(msg Message, client *Client) bool {
+	if b.enabled || msg.Source == "" || !bridgedProtocol(client.Protocol) {
+		return true
+	}
+	return client.Protocol == msg.Source
+}
+
+// bridgedProtocol сообщает, соединяет ли мост клиентов протокола protocol.
+func bridgedProtocol(protocol string) bool {
+	return protocol == "ws" || protocol == "tcp"
+}
+
+// messageSource возвращает Message.Source для сообщения клиента протокола protocol.
+func messageSource(protocol string) string {
+	if bridgedProtocol(protocol) {
+		return protocol
+	}
+	return ""
+}
+
+// Visible оставляет в messages сообщения, которые Forward доставил бы клиенту client.
+func (b *Bridge) Visible(messages []Message, client *Client) []Message {
+	return slices.DeleteFunc(messages, func(msg Message) bool { return !b.Forward(msg, client) })
+}
@@ -0,0 +1,178 @@
+//go:build chaos
+
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+)
+
+// Тесты внесения ошибок собираются только с тегом chaos:
+//
+//	go test -tags chaos -race .
+//
+// Они подменяют общие для процесса FaultInjector, redisClient и messageStore,
+// поэтому не запускаются параллельно.
+
+// injectFaults подменяет FaultInjector отправок клиентам на f до конца теста.
+func injectFaults(t *testing.T, f *FaultInjector) {
+	t.Helper()
+	prev := faults
+	faults = func() *FaultInjector { return f }
+	t.Cleanup(func() { faults = prev })
+}
+
+func TestFaultInjectorSend(t *testing.T) {
+	tests := []struct {
+		name          string
+		injector      *FaultInjector
+		wantSent      bool
+		wantConnected bool
+		// wantAttempts — сколько отправок дошло до соединения: ошибка вносится до него
+		wantAttempts int
+		wantRetries  float64
+		wantFailures float64
+	}{
+		{name: "без ошибок", injector: &FaultInjector{Probability: 0, ErrorType: "timeout"}, wantSent: true, wantConnected: true, wantAttempts: 1},
+		{name: "таймаут повторяется", injector: &FaultInjector{Probability: 1, ErrorType: "timeout"}, wantConnected: true, wantRetries: 2, wantFailures: 1},
+		{name: "сброс соединения отключает клиента", injector: &FaultInjector{Probability: 1, ErrorType: "reset"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injectFaults(t, tt.injector)
+			cfg := testConfig()
+			cfg.MaxSendRetries = 2
+			s := NewServer(cfg)
+			conn := newFakeClient(nil)
+			client := s.newClient("ws", "fake", conn)
+			retries := testutil.ToFloat64(sendRetriesTotal)
+			failures := testutil.ToFloat64(sendFinalFailuresTotal)
+
+			sent, connected := client.write(Message{Type: "message", Text: "привет"})
+			if sent != tt.wantSent || connected != tt.wantConnected {
+				t.Errorf("write = (%v, %v), ожидалось (%v, %v)", sent, connected, tt.wantSent, tt.wantConnected)
+			}
+			if got := conn.attempts(); got != tt.wantAttempts {
+				t.Errorf("отправок через соединение %d, ожидалось %d", got, tt.wantAttempts)
+			}
+			if got := testutil.ToFloat64(sendRetriesTotal) - retries; got != tt.wantRetries {
+				t.Errorf("повторов отправки %v, ожидалось %v", got, tt.wantRetries)
+			}
+			if got := testutil.ToFloat64(sendFinalFailuresTotal) - failures; got != tt.wantFailures {
+				t.Errorf("исчерпанных повторов %v, ожидалось %v", got, tt.wantFailures)
+			}
+			if got := closed(conn.closed); got == tt.wantConnected {
+				t.Errorf("соединение закрыто: %v, ожидалось %v", got, !tt.wantConnected)
+			}
+		})
+	}
+}
+
+func TestFaultInjectorOpensCircuit(t *testing.T) {
+	injectFaults(t, &FaultInjector{Probability: 1, ErrorType: "timeout"})
+	cfg := testConfig()
+	cfg.MaxSendRetries = 0
+	cfg.CircuitBreakerThreshold = 2
+	s := NewServer(cfg)
+	conn := newFakeClient(nil)
+	client := s.newClient("ws", "fake", conn)
+	opened := testutil.ToFloat64(clientCircuitOpenTotal)
+
+	// Цепь размыкается, когда ошибок становится больше порога
+	for i := range cfg.CircuitBreakerThreshold + 1 {
+		if client.Degraded() {
+			t.Fatalf("цепь разомкнулась после %d ошибок, порог %d", i, cfg.CircuitBreakerThreshold)
+		}
+		sent, connected := client.write(Message{Type: "message", Text: "привет"})
+		if sent || !connected {
+			t.Fatalf("write = (%v, %v), ожидалось (false, true)", sent, connected)
+		}
+	}
+	if !client.Degraded() {
+		t.Fatal("цепь не разомкнулась")
+	}
+	if got := testutil.ToFloat64(clientCircuitOpenTotal) - opened; got != 1 {
+		t.Errorf("цепь размыкалась %v раз, ожидалось 1", got)
+	}
+
+	// Разомкнутая цепь не пропускает отправку, даже когда ошибки прекратились
+	injectFaults(t, &FaultInjector{Probability: 0, ErrorType: "timeout"})
+	sent, connected := client.write(Message{Type: "message", Text: "еще раз"})
+	if sent || !connected {
+		t.Errorf("write = (%v, %v), ожидалось (false, true)", sent, connected)
+	}
+	if got := conn.attempts(); got != 0 {
+		t.Errorf("отправок через разомкнутую цепь %d, ожидалось 0", got)
+	}
+}
+
+func TestRedisUnavailable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	// Без повторов go-redis ошибка публикации не задерживает рассылку
+	redisClient = redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() {
+		redisClient.Close()
+		redisClient = nil
+	})
+	s := newTestServer(t, testConfig())
+	_, alice := connectFake(t, s, "alice", nil)
+
+	mr.Close()
+	err := s.Broadcast(Message{Type: "message", Username: "bob", Text: "привет"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Сообщение, которое не удалось опубликовать в Redis, все равно доходит до локальных клиентов
+	alice.texts(t, "привет")
+}
+
+// failingStore — база сообщений, сохранение в которую всегда завершается ошибкой.
+// Остальные методы MessageStore в тесте не вызываются.
+type failingStore struct {
+	MessageStore
+	inserts chan Message
+}
+
+var errStoreUnavailable = errors.New("база недоступна")
+
+func (f failingStore) Insert(msg Message) error {
+	select {
+	case f.inserts <- msg:
+	default:
+	}
+	return errStoreUnavailable
+}
+
+func TestStoreUnavailable(t *testing.T) {
+	store := failingStore{inserts: make(chan Message, 16)}
+	messageStore = store
+	t.Cleanup(func() { messageStore = nil })
+	s := newTestServer(t, testConfig())
+	_, alice := connectFake(t, s, "alice", nil)
+
+	err := s.Broadcast(Message{Type: "message", Username: "bob", Text: "привет"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice.texts(t, "привет")
+	deadline := time.After(testTimeout)
+	for saved := false; !saved; {
+		select {
+		case msg := <-store.inserts:
+			saved = msg.Text == "привет"
+		case <-deadline:
+			t.Fatal("сообщение не сохранялось в базу")
+		}
+	}
+	// Несохраненное сообщение остается в истории общего чата
+	if !slices.ContainsFunc(s.history.Messages(), func(msg Message) bool { return msg.Text == "привет" }) {
+		t.Error("несохраненного сообщения нет в истории")
+	}
+}
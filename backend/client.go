@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+// Sender — общий интерфейс отправки сообщений клиенту независимо от протокола.
+type Sender interface {
+	Send(msg Message) error
+}
+
+// transport — соединение клиента конкретного протокола (WebSocket или TCP).
+type transport interface {
+	Sender
+	Close() error
+}
+
+// Client представляет подключенного клиента (WebSocket, TCP или gRPC).
+type Client struct {
+	// ID — уникальный идентификатор соединения, присваивается при подключении.
+	ID string
+	// Username — отображаемое имя, задается первым сообщением type:"register".
+	Username string
+	// Tenant — арендатор клиента (см. requestTenant); пустое значение — арендатор по умолчанию.
+	// Имя клиента уникально только внутри арендатора.
+	Tenant string
+	// Protocol — протокол подключения: "ws", "tcp" или "grpc".
+	Protocol string
+	// Encoding — кодировка сообщений клиента WebSocket, выбранная подпротоколом (JSON или MessagePack).
+	// Пустая у клиентов других протоколов.
+	Encoding Encoding
+	// RemoteAddr — адрес клиента.
+	RemoteAddr string
+	// ConnectedAt — время подключения.
+	ConnectedAt time.Time
+	// BytesSent и BytesReceived — объем отправленных клиенту и принятых от него кадров
+	// (WebSocket и TCP), MessagesSent и MessagesReceived — число сообщений протокола.
+	BytesSent        atomic.Uint64
+	BytesReceived    atomic.Uint64
+	MessagesSent     atomic.Uint64
+	MessagesReceived atomic.Uint64
+	// resumeToken — токен, под которым хранится сессия клиента (см. restoreSession).
+	resumeToken string
+	// server — сервер, к которому подключен клиент.
+	server *Server
+	conn   transport
+	// rooms — комнаты, в которых состоит клиент. Используется только горутиной клиента.
+	rooms map[string]*Room
+	// topics — фильтры тем, на которые подписан клиент. Используется только горутиной клиента.
+	topics map[string]bool
+	// joinTokens — токены входа в комнаты с паролем по имени комнаты (см. Room.checkPassword).
+	// Используется только горутиной клиента.
+	joinTokens map[string]string
+	// limiter ограничивает частоту сообщений клиента.
+	limiter *rate.Limiter
+	// lastSeenAt — время последнего сообщения от клиента (UnixNano).
+	lastSeenAt atomic.Int64
+	// lastActiveAt — время последнего сообщения протокола от клиента (UnixNano), для статуса "away".
+	lastActiveAt atomic.Int64
+	// done закрывается, когда serveClient завершил обработку клиента.
+	done chan struct{}
+	// acks отслеживает сообщения, доставку которых клиент еще не подтвердил.
+	acks ackTracker
+	// send — очередь исходящих сообщений рассылки, ее разбирает writeLoop.
+	send chan Message
+	// breaker приостанавливает отправку клиенту при постоянных сетевых ошибках.
+	breaker *circuitBreaker
+	// bandwidth ограничивает исходящий трафик клиента; nil — без ограничения.
+	bandwidth *bandwidthLimiter
+	// reorder восстанавливает порядок сообщений отправителей по SeqNum; nil — без восстановления.
+	reorder *reorderBuffer
+	// seqNums — номер последнего сообщения клиента в каждое место назначения (см. seqDestination).
+	// Используется только горутиной клиента.
+	seqNums map[string]uint64
+	// readOnly — клиенту нельзя отправлять сообщения (API ключ без права "send").
+	readOnly bool
+	// admin — у клиента роль admin в JWT; ему можно отправлять сообщения с priorityHigh.
+	admin bool
+	// authenticated — имя клиента подтверждено JWT или API ключом. Роли в комнатах привязаны
+	// к имени, поэтому действуют только у таких клиентов (см. Room.clientRole).
+	authenticated bool
+	// lastTypingAt — время последнего разосланного уведомления type:"typing". Используется только горутиной клиента.
+	lastTypingAt time.Time
+}
+
+// sendQueueSize — емкость очереди исходящих сообщений клиента.
+const sendQueueSize = 64
+
+// rateLimitWait — сколько клиент может ждать своей очереди на отправку, прежде чем будет отключен.
+const rateLimitWait = 2 * time.Second
+
+// lastClientID — счетчик для выдачи ID клиентам.
+var lastClientID atomic.Uint64
+
+// nextClientID возвращает очередной уникальный ID клиента.
+// С Redis счетчик общий для всех экземпляров и переживает перезапуск,
+// поэтому ID сохраненных сессий не достаются новым клиентам.
+func nextClientID() string {
+	if redisClient != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+		id, err := redisClient.Incr(ctx, "chat:client_id").Result()
+		if err == nil {
+			return strconv.FormatInt(id, 10)
+		}
+		slog.Warn("Ошибка получения ID клиента из Redis", "err", err)
+	}
+	return strconv.FormatUint(lastClientID.Add(1), 10)
+}
+
+// newClient создает клиента поверх соединения указанного протокола.
+func (s *Server) newClient(protocol, remoteAddr string, conn transport) *Client {
+	client := &Client{
+		ID:          nextClientID(),
+		Protocol:    protocol,
+		RemoteAddr:  remoteAddr,
+		ConnectedAt: time.Now().UTC(),
+		resumeToken: newResumeToken(),
+		server:      s,
+		conn:        conn,
+		rooms:       make(map[string]*Room),
+		joinTokens:  make(map[string]string),
+		topics:      make(map[string]bool),
+		limiter:     rate.NewLimiter(rate.Limit(s.config.RateLimit), s.config.RateLimit),
+		done:        make(chan struct{}),
+		send:        make(chan Message, sendQueueSize),
+		breaker:     newCircuitBreaker(s.config.CircuitBreakerThreshold),
+		bandwidth:   newBandwidthLimiter(s.config.MaxBytesPerSecPerClient),
+	}
+	client.reorder = newReorderBuffer(client, s.config.ReorderBuffer())
+	client.touch()
+	client.active()
+	return client
+}
+
+// Send отправляет сообщение клиенту по его протоколу.
+// Сообщения с MsgID ждут подтверждения type:"ack" (если подтверждения включены).
+func (c *Client) Send(msg Message) error {
+	// Клиент gRPC не может ответить в поток Subscribe, а доставку гарантирует HTTP/2
+	if msg.MsgID != "" && c.server.config.AckTimeout.Duration > 0 && c.Protocol != "grpc" {
+		c.acks.track(msg)
+	}
+	if err := injectFault(); err != nil {
+		return err
+	}
+	err := c.conn.Send(msg)
+	if err == nil {
+		c.MessagesSent.Add(1)
+	}
+	return err
+}
+
+// clientStats — статистика трафика клиента (GET /clients, GET /clients/{id}/stats).
+type clientStats struct {
+	BytesSent        uint64 `json:"bytesSent"`
+	BytesReceived    uint64 `json:"bytesReceived"`
+	MessagesSent     uint64 `json:"messagesSent"`
+	MessagesReceived uint64 `json:"messagesReceived"`
+	// ConnectedSeconds — сколько секунд клиент подключен.
+	ConnectedSeconds float64 `json:"connectedSeconds"`
+}
+
+// Stats возвращает текущую статистику трафика клиента.
+func (c *Client) Stats() clientStats {
+	return clientStats{
+		BytesSent:        c.BytesSent.Load(),
+		BytesReceived:    c.BytesReceived.Load(),
+		MessagesSent:     c.MessagesSent.Load(),
+		MessagesReceived: c.MessagesReceived.Load(),
+		ConnectedSeconds: time.Since(c.ConnectedAt).Seconds(),
+	}
+}
+
+// deliver ставит сообщение рассылки в очередь клиента, не блокируясь.
+// Если очередь заполнена, сообщение отбрасывается; при включенном
+// DisconnectSlowClients соединение медленного клиента закрывается и deliver
+// возвращает false — вызывающий должен убрать клиента из своих списков.
+// Сообщения с SeqNum проходят через буфер порядка, если он включен (REORDER_BUFFER_MS).
+func (c *Client) deliver(msg Message) bool {
+	if c.reorder != nil && msg.SeqNum != 0 {
+		return c.reorder.push(msg)
+	}
+	return c.enqueue(msg)
+}
+
+// nextSeqNum возвращает Message.SeqNum для следующего сообщения клиента в место назначения msg.
+func (c *Client) nextSeqNum(msg Message) uint64 {
+	if c.seqNums == nil {
+		c.seqNums = make(map[string]uint64)
+	}
+	destination := seqDestination(msg)
+	c.seqNums[destination]++
+	return c.seqNums[destination]
+}
+
+// enqueue ставит сообщение в очередь send без учета порядка (см. deliver).
+func (c *Client) enqueue(msg Message) bool {
+	select {
+	case c.send <- msg:
+		return true
+	default:
+	}
+	msg.fanout.done(true)
+
+	slowClientDropsTotal.Inc()
+	if !c.server.config.DisconnectSlowClients {
+		c.logger().Warn("Очередь клиента заполнена, сообщение отброшено", "msg_id", msg.MsgID)
+		return true
+	}
+	c.logger().Warn("Очередь клиента заполнена, медленный клиент отключен", "msg_id", msg.MsgID)
+	c.Close()
+	return false
+}
+
+// writeLoop отправляет клиенту сообщения из очереди send, пока клиент подключен.
+// Медленный клиент задерживает только собственную очередь, а не всю рассылку.
+func (c *Client) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			sent, connected := c.write(msg)
+			msg.fanout.done(!sent)
+			if !connected {
+				return
+			}
+		}
+	}
+}
+
+// write отправляет одно сообщение из очереди. Возвращает, отправлено ли оно и подключен ли еще клиент.
+func (c *Client) write(msg Message) (sent, connected bool) {
+	allowed, state := c.breaker.allow(time.Now())
+	c.logCircuit(state)
+	if !allowed {
+		// Цепь разомкнута: сообщение клиенту не отправляется
+		return false, true
+	}
+
+	if c.bandwidth != nil && !c.bandwidth.wait(c) {
+		return false, false
+	}
+	before := c.BytesSent.Load()
+	err := c.sendWithRetry(msg)
+	if c.bandwidth != nil {
+		c.bandwidth.spend(c.BytesSent.Load() - before)
+	}
+	if err == nil {
+		c.logCircuit(c.breaker.success())
+		return true, true
+	}
+	sendErrorsTotal.Inc()
+	c.logger().Error("Ошибка отправки сообщения", "msg_id", msg.MsgID, "err", err)
+	// Сетевые ошибки обрабатывает circuitBreaker, остальные означают, что клиент отключился
+	if retryableSendError(err) {
+		c.logCircuit(c.breaker.failure(time.Now()))
+		return false, true
+	}
+	// Закрываем соединение; удалит клиента serveClient, когда чтение завершится ошибкой
+	c.Close()
+	return false, false
+}
+
+// logCircuit записывает в журнал смену состояния circuitBreaker клиента (если state не пусто).
+func (c *Client) logCircuit(state string) {
+	if state == "" {
+		return
+	}
+	c.logger().Warn("Состояние отправки клиенту изменилось", "circuit", state)
+}
+
+// Degraded сообщает, что отправка клиенту приостановлена из-за постоянных сетевых ошибок.
+func (c *Client) Degraded() bool {
+	return c.breaker.current() != circuitClosed
+}
+
+// sendRetryBackoff — пауза перед первым повтором отправки, каждая следующая вдвое длиннее.
+const sendRetryBackoff = 100 * time.Millisecond
+
+// sendWithRetry отправляет сообщение, повторяя попытку до Config.MaxSendRetries раз,
+// если ошибка сетевая и временная (см. retryableSendError).
+func (c *Client) sendWithRetry(msg Message) error {
+	backoff := sendRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.Send(msg)
+		if err == nil || !retryableSendError(err) {
+			return err
+		}
+		if attempt == c.server.config.MaxSendRetries {
+			sendFinalFailuresTotal.Inc()
+			return err
+		}
+
+		sendRetriesTotal.Inc()
+		c.logger().Warn("Ошибка отправки сообщения, повтор", "msg_id", msg.MsgID, "attempt", attempt+1, "retry_in", backoff, "err", err)
+		select {
+		case <-c.done:
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryableSendError сообщает, стоит ли повторять отправку: повторяются только таймауты
+// сети, а закрытое соединение и отключение клиента (close frame, сброс, EOF) — нет.
+func retryableSendError(err error) bool {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) || errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Close закрывает соединение клиента.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// WaitClosed ждет, пока serveClient завершит обработку клиента (закроется done).
+// Возвращает ошибку, если за timeout этого не произошло.
+func (c *Client) WaitClosed(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c.done:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("клиент %s не отключился за %s", c.ID, timeout)
+	}
+}
+
+// waitRateLimit ждет, пока клиенту снова можно отправлять сообщения.
+// Возвращает false, если ждать пришлось бы дольше rateLimitWait.
+func (c *Client) waitRateLimit() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitWait)
+	defer cancel()
+	return c.limiter.Wait(ctx) == nil
+}
+
+// wsTransport отправляет сообщения клиенту WebSocket в выбранной им кодировке (JSON или MessagePack).
+type wsTransport struct {
+	conn     *websocket.Conn
+	encoding Encoding
+	// bytesSent — счетчик отправленных байт клиента (Client.BytesSent).
+	bytesSent *atomic.Uint64
+	// mutex сериализует запись: gorilla/websocket допускает только одного писателя.
+	mutex sync.Mutex
+}
+
+func (t *wsTransport) Send(msg Message) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	data, err := serialize(msg, t.encoding)
+	if err != nil {
+		return err
+	}
+	t.bytesSent.Add(uint64(len(data)))
+	return t.conn.WriteMessage(t.encoding.frameType(), data)
+}
+
+func (t *wsTransport) Close() error {
+	return t.conn.Close()
+}
+
+// Ping отправляет управляющий кадр ping. Управляющие кадры gorilla/websocket
+// можно писать параллельно с обычными, поэтому mutex не нужен.
+func (t *wsTransport) Ping(timeout time.Duration) error {
+	return t.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout))
+}
@@ -0,0 +1,209 @@
+// Команда loadtest имитирует N одновременных клиентов WebSocket и измеряет
+// задержку рассылки: каждый клиент отправляет сообщения в общий чат и ждет,
+// пока сервер разошлет их обратно ему же.
+//
+// Пример:
+//
+//	go run ./cmd/loadtest --url ws://localhost:8080/ws --clients 100 --messages-per-client 50 --rate 5
+//
+// Сервер ограничивает частоту сообщений одного клиента (MAX_MSG_RATE, по умолчанию 10 в секунду),
+// поэтому --rate должен быть не больше этого значения.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// message — поля сообщения протокола чата, нужные нагрузочному тесту.
+type message struct {
+	Type     string `json:"type,omitempty"`
+	Text     string `json:"text"`
+	Username string `json:"username,omitempty"`
+}
+
+// textPrefix начинает текст каждого тестового сообщения: "loadtest <номер> <время отправки в UnixNano>".
+const textPrefix = "loadtest "
+
+// stats собирает результаты всех клиентов.
+type stats struct {
+	sent     atomic.Int64
+	received atomic.Int64
+	errors   atomic.Int64
+
+	mutex     sync.Mutex
+	latencies []time.Duration
+}
+
+// record учитывает полученное обратно сообщение с задержкой latency.
+func (s *stats) record(latency time.Duration) {
+	s.received.Add(1)
+	s.mutex.Lock()
+	s.latencies = append(s.latencies, latency)
+	s.mutex.Unlock()
+}
+
+func main() {
+	url := flag.String("url", "ws://localhost:8080/ws", "адрес WebSocket сервера чата")
+	clients := flag.Int("clients", 10, "число одновременных клиентов")
+	messages := flag.Int("messages-per-client", 100, "сколько сообщений отправляет каждый клиент")
+	rate := flag.Float64("rate", 5, "частота отправки одного клиента, сообщений в секунду")
+	wait := flag.Duration("wait", 5*time.Second, "сколько ждать оставшиеся ответы после отправки последнего сообщения")
+	flag.Parse()
+
+	if *clients <= 0 || *messages <= 0 || *rate <= 0 {
+		fmt.Fprintln(os.Stderr, "--clients, --messages-per-client и --rate должны быть положительными")
+		os.Exit(2)
+	}
+
+	var st stats
+	var wg sync.WaitGroup
+	prefix := "load-" + strconv.Itoa(os.Getpid())
+	start := time.Now()
+	for i := range *clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := runClient(*url, fmt.Sprintf("%s-%d", prefix, i), *messages, *rate, *wait, &st)
+			if err != nil {
+				st.errors.Add(1)
+				log.Printf("клиент %d: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+	report(&st, time.Since(start))
+}
+
+// runClient подключает одного клиента, регистрирует его и отправляет messages сообщений
+// с частотой rate, одновременно читая рассылку и отмечая задержку своих сообщений.
+func runClient(url, username string, messages int, rate float64, wait time.Duration, st *stats) error {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return fmt.Errorf("подключение: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteJSON(message{Type: "register", Username: username}); err != nil {
+		return fmt.Errorf("регистрация: %w", err)
+	}
+	if err := waitRegistered(conn); err != nil {
+		return err
+	}
+
+	var received atomic.Int64
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		readLoop(conn, username, &received, st)
+	}()
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	sent := int64(0)
+	for seq := range messages {
+		<-ticker.C
+		text := textPrefix + strconv.Itoa(seq) + " " + strconv.FormatInt(time.Now().UnixNano(), 10)
+		if err := conn.WriteJSON(message{Text: text}); err != nil {
+			return fmt.Errorf("отправка: %w", err)
+		}
+		sent++
+		st.sent.Add(1)
+	}
+
+	// Ждем, пока вернутся все отправленные сообщения, но не дольше wait
+	deadline := time.Now().Add(wait)
+	for received.Load() < sent && time.Now().Before(deadline) {
+		select {
+		case <-readDone:
+			return nil
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.Close()
+	<-readDone
+	return nil
+}
+
+// waitRegistered читает сообщения до подтверждения type:"registered".
+func waitRegistered(conn *websocket.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		var msg message
+		if err := conn.ReadJSON(&msg); err != nil {
+			return fmt.Errorf("ожидание регистрации: %w", err)
+		}
+		switch msg.Type {
+		case "registered":
+			return nil
+		case "error":
+			return fmt.Errorf("регистрация отклонена: %s", msg.Text)
+		}
+	}
+}
+
+// readLoop читает рассылку, пока соединение открыто, и отмечает задержку
+// сообщений, отправленных этим клиентом. Ошибки сервера type:"error" считаются.
+func readLoop(conn *websocket.Conn, username string, received *atomic.Int64, st *stats) {
+	for {
+		var msg message
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		if msg.Type == "error" {
+			st.errors.Add(1)
+			continue
+		}
+		if msg.Type != "message" || msg.Username != username || !strings.HasPrefix(msg.Text, textPrefix) {
+			continue
+		}
+		fields := strings.Fields(msg.Text)
+		if len(fields) != 3 {
+			continue
+		}
+		sentAt, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		received.Add(1)
+		st.record(time.Since(time.Unix(0, sentAt)))
+	}
+}
+
+// report печатает итоги теста.
+func report(st *stats, elapsed time.Duration) {
+	sent, received := st.sent.Load(), st.received.Load()
+	fmt.Printf("длительность:  %s\n", elapsed.Round(time.Millisecond))
+	fmt.Printf("отправлено:    %d\n", sent)
+	fmt.Printf("получено:      %d\n", received)
+	fmt.Printf("ошибок:        %d\n", st.errors.Load())
+	if sent > 0 {
+		fmt.Printf("потеряно:      %.2f%%\n", 100*float64(sent-received)/float64(sent))
+	}
+
+	slices.Sort(st.latencies)
+	if len(st.latencies) == 0 {
+		return
+	}
+	fmt.Printf("задержка p50:  %s\n", percentile(st.latencies, 50))
+	fmt.Printf("задержка p95:  %s\n", percentile(st.latencies, 95))
+	fmt.Printf("задержка p99:  %s\n", percentile(st.latencies, 99))
+}
+
+// percentile возвращает p-й процентиль отсортированного набора задержек.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+	return sorted[max(i, 0)].Round(time.Microsecond)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommandFunc обрабатывает команду чата. args — аргументы команды без ее имени.
+// Возвращенная ошибка отправляется вызвавшему клиенту как type:"error".
+type CommandFunc func(client *Client, args []string) error
+
+var (
+	// commands — зарегистрированные команды по имени без "/".
+	commands = make(map[string]CommandFunc)
+	// commandsMutex для безопасного доступа к commands.
+	commandsMutex = &sync.RWMutex{}
+)
+
+// RegisterCommand добавляет команду чата (или заменяет одноименную). Имя указывается без "/".
+func RegisterCommand(name string, handler CommandFunc) {
+	commandsMutex.Lock()
+	defer commandsMutex.Unlock()
+	commands[strings.TrimPrefix(name, "/")] = handler
+}
+
+func init() {
+	RegisterCommand("help", commandHelp)
+	RegisterCommand("list", commandList)
+	RegisterCommand("nick", commandNick)
+	RegisterCommand("kick", adminOnly(commandKick))
+	RegisterCommand("mute", commandMute)
+	RegisterCommand("op", requireRoomRole(roleOwner, commandOp))
+	RegisterCommand("deop", requireRoomRole(roleOwner, commandDeop))
+	RegisterCommand("deleteroom", requireRoomRole(roleOwner, commandDeleteRoom))
+	RegisterCommand("roomkick", requireRoomRole(roleModerator, commandRoomKick))
+	RegisterCommand("invite", commandInvite)
+	RegisterCommand("inviteonly", requireRoomRole(roleOwner, commandInviteOnly))
+	RegisterCommand("setpass", requireRoomRole(roleOwner, commandSetPass))
+	RegisterCommand("settopic", requireRoomRole(roleModerator, commandSetTopic))
+	RegisterCommand("setdesc", requireRoomRole(roleModerator, commandSetDesc))
+}
+
+// runCommand выполняет команду из текста сообщения вида "/имя аргументы...".
+func runCommand(client *Client, text string) {
+	fields := strings.Fields(strings.TrimPrefix(text, "/"))
+	if len(fields) == 0 {
+		sendError(client, "не указана команда, список команд: /help")
+		return
+	}
+
+	commandsMutex.RLock()
+	handler, found := commands[fields[0]]
+	commandsMutex.RUnlock()
+	if !found {
+		sendError(client, "неизвестная команда: /"+fields[0])
+		return
+	}
+
+	client.logger().Info("Выполнение команды", "command", fields[0])
+	err := handler(client, fields[1:])
+	if err != nil {
+		sendError(client, err.Error())
+	}
+}
+
+// reply отправляет ответ команды только вызвавшему ее клиенту.
+func reply(client *Client, text string) error {
+	return client.Send(Message{Type: "system", Text: text})
+}
+
+// adminOnly разрешает команду только пользователям из Config.AdminUsers.
+func adminOnly(handler CommandFunc) CommandFunc {
+	return func(client *Client, args []string) error {
+		if !slices.Contains(client.server.config.AdminUsers, client.Username) {
+			return errors.New("команда доступна только администраторам")
+		}
+		return handler(client, args)
+	}
+}
+
+// commandHelp выводит список доступных команд.
+func commandHelp(client *Client, _ []string) error {
+	commandsMutex.RLock()
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, "/"+name)
+	}
+	commandsMutex.RUnlock()
+
+	slices.Sort(names)
+	return reply(client, "команды: "+strings.Join(names, ", "))
+}
+
+// commandList выводит имена подключенных пользователей арендатора клиента.
+func commandList(client *Client, _ []string) error {
+	s := client.server
+	s.mutex.RLock()
+	names := make([]string, 0, len(s.clientsByName))
+	for key := range s.clientsByName {
+		if key.tenant == client.Tenant {
+			names = append(names, key.name)
+		}
+	}
+	s.mutex.RUnlock()
+
+	slices.Sort(names)
+	return reply(client, fmt.Sprintf("в чате %d: %s", len(names), strings.Join(names, ", ")))
+}
+
+// commandNick меняет имя клиента: /nick <имя>.
+func commandNick(client *Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("использование: /nick <имя>")
+	}
+	// С JWT имя задает токен, сменить его нельзя
+	if client.server.config.JWTSecret != "" {
+		return errors.New("имя задается токеном и не может быть изменено")
+	}
+
+	oldName := client.Username
+	err := client.server.renameClient(client, args[0])
+	if err != nil {
+		return err
+	}
+	saveSession(client)
+	client.logger().Info("Клиент сменил имя", "old_username", oldName, "username", client.Username)
+
+	client.server.presence.Forget(client.Tenant, oldName)
+	setPresence(client, "online")
+	client.server.announce(client.Tenant, fmt.Sprintf("пользователь %s теперь %s", oldName, client.Username))
+	return client.Send(registeredMessage(client))
+}
+
+// renameClient закрепляет за зарегистрированным клиентом новое свободное у его арендатора имя.
+func (s *Server) renameClient(client *Client, username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := tenantName{client.Tenant, username}
+	if _, taken := s.clientsByName[key]; taken {
+		return errors.New("имя уже занято: " + username)
+	}
+	delete(s.clientsByName, tenantName{client.Tenant, client.Username})
+	client.Username = username
+	s.clientsByName[key] = client
+	return nil
+}
+
+// commandKick отключает пользователя своего арендатора: /kick <имя>.
+func commandKick(client *Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("использование: /kick <имя>")
+	}
+
+	s := client.server
+	s.mutex.RLock()
+	target := s.clientsByName[tenantName{client.Tenant, args[0]}]
+	s.mutex.RUnlock()
+	if target == nil {
+		return errors.New("пользователь не найден: " + args[0])
+	}
+
+	auditLog.Record(auditKick, client.Username, target.ID, client.RemoteAddr, "username", target.Username, "tenant", target.Tenant)
+	s.kickClient(target, "отключен администратором "+client.Username)
+	return reply(client, "пользователь "+args[0]+" отключен")
+}
+
+// commandMute запрещает пользователю писать: /mute <имя> — во всем чате (только администраторы),
+// /mute <имя> <комната> [длительность] — в комнате (владелец и модераторы, см. commandRoomMute).
+func commandMute(client *Client, args []string) error {
+	if len(args) >= 2 {
+		return commandRoomMute(client, args)
+	}
+	return adminOnly(commandMuteGlobal)(client, args)
+}
+
+// commandMuteGlobal запрещает пользователю своего арендатора писать в чат: /mute <имя>.
+func commandMuteGlobal(client *Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("использование: /mute <имя>")
+	}
+
+	muteGlobally(client.Tenant, args[0], client.Username, client.RemoteAddr, time.Time{})
+	return reply(client, "пользователь "+args[0]+" не может писать в чат")
+}
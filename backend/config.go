@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config содержит все настройки сервера.
+type Config struct {
+	// Mode — режим работы: "dev" (по умолчанию) или "production".
+	Mode string `json:"mode"`
+	// WSPort — порт HTTP/WebSocket сервера.
+	WSPort int `json:"ws_port"`
+	// TCPPort — порт TCP сервера.
+	TCPPort int `json:"tcp_port"`
+	// GRPCPort — порт gRPC сервера.
+	GRPCPort int `json:"grpc_port"`
+	// MQTTPort — порт встроенного MQTT брокера.
+	MQTTPort int `json:"mqtt_port"`
+	// MaxMessageBytes — максимальная длина текста сообщения в байтах.
+	MaxMessageBytes int `json:"max_message_bytes"`
+	// AllowedAttachmentDomains — домены, с которых можно прикладывать файлы (вместе с поддоменами).
+	// Пустой список запрещает вложения.
+	AllowedAttachmentDomains []string `json:"allowed_attachment_domains"`
+	// MaxAttachmentSizeBytes — наибольший размер приложенного файла в байтах.
+	MaxAttachmentSizeBytes int `json:"max_attachment_size_bytes"`
+	// AllowedAttachmentMimeTypes — допустимые типы вложений; "image/*" разрешает все подтипы.
+	AllowedAttachmentMimeTypes []string `json:"allowed_attachment_mime_types"`
+	// BroadcastBuffer — емкость очереди broadcast.
+	BroadcastBuffer int `json:"broadcast_buffer"`
+	// HistorySize — сколько последних сообщений хранить для новых клиентов.
+	HistorySize int `json:"history_size"`
+	// TLSCertFile и TLSKeyFile включают TLS для WebSocket сервера.
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+	// TCPTLSCertFile и TCPTLSKeyFile включают TLS для TCP сервера.
+	TCPTLSCertFile string `json:"tcp_tls_cert_file"`
+	TCPTLSKeyFile  string `json:"tcp_tls_key_file"`
+	// TCPClientCAFile включает взаимный TLS для TCP сервера.
+	TCPClientCAFile string `json:"tcp_client_ca_file"`
+	// JWTSecret — секрет для проверки JWT. Пустое значение отключает аутентификацию.
+	JWTSecret string `json:"jwt_secret"`
+	// RefreshGracePeriod — за сколько до истечения JWT его уже нельзя обменять на новый через /auth/refresh.
+	RefreshGracePeriod Duration `json:"refresh_grace_period"`
+	// APIKeysFile — файл API ключей программных клиентов (JSON массив {key, owner, permissions}).
+	// Пустое значение отключает вход по заголовку X-API-Key.
+	APIKeysFile string `json:"api_keys_file"`
+	// AuthProvider — провайдер входа OAuth2: "github", "google" или пустое значение (вход отключен).
+	// Выданный после входа JWT подписывается JWTSecret.
+	AuthProvider string `json:"auth_provider"`
+	// OAuthClientID и OAuthClientSecret — учетные данные приложения у провайдера OAuth2.
+	OAuthClientID     string `json:"oauth_client_id"`
+	OAuthClientSecret string `json:"oauth_client_secret"`
+	// OAuthRedirectURL — полный адрес /auth/callback, зарегистрированный у провайдера.
+	OAuthRedirectURL string `json:"oauth_redirect_url"`
+	// OAuthSuccessURL — куда перенаправить пользователя после входа; токен добавляется параметром token.
+	OAuthSuccessURL string `json:"oauth_success_url"`
+	// OAuthTokenTTL — срок действия JWT, выданного после входа через OAuth2.
+	OAuthTokenTTL Duration `json:"oauth_token_ttl"`
+	// AdminToken — токен административных эндпоинтов. Пустое значение — режим разработки.
+	AdminToken string `json:"admin_token"`
+	// AdminUsers — имена пользователей, которым доступны команды администратора (/kick, /mute).
+	// Без JWT имена не подтверждаются, поэтому в production список имеет смысл только вместе с JWTSecret.
+	AdminUsers []string `json:"admin_users"`
+	// RateLimit — допустимое число сообщений в секунду от одного клиента.
+	RateLimit int `json:"rate_limit"`
+	// MaxBytesPerSecPerClient — сколько байт в секунду сервер отправляет одному клиенту
+	// (токен-бакет, допускающий всплеск в пределах секунды). 0 — без ограничения.
+	MaxBytesPerSecPerClient int `json:"max_bytes_per_sec_per_client"`
+	// ReorderBufferMS — сколько миллисекунд сообщение отправителя, пришедшее раньше предыдущих,
+	// ждет их перед отправкой получателю (см. reorderBuffer). 0 — сообщения отправляются сразу.
+	ReorderBufferMS int `json:"reorder_buffer_ms"`
+	// MessageLogFile — файл журнала сообщений (NDJSON). Пустое значение отключает журнал.
+	MessageLogFile string `json:"message_log_file"`
+	// AuditLogFile — файл журнала аудита (входы, обновления токенов, действия администраторов).
+	// Пустое значение отключает журнал аудита.
+	AuditLogFile string `json:"audit_log_file"`
+	// DeadLetterFile — файл очереди недоставленных сообщений (NDJSON), которые не получил
+	// ни один клиент. Пустое значение отключает очередь: такие сообщения только учитываются в метрике.
+	DeadLetterFile string `json:"dead_letter_file"`
+	// FCMServiceAccountJSON — ключ сервисного аккаунта Google (JSON или путь к файлу с ним) для push
+	// уведомлений FCM о личных сообщениях пользователям не в сети. Токены устройств хранятся в базе.
+	// Пустое значение отключает уведомления.
+	FCMServiceAccountJSON string `json:"fcm_service_account_json"`
+	// SMTPHost, SMTPPort, SMTPUser и SMTPPassword — SMTP сервер для писем о личных сообщениях
+	// пользователям не в сети без push уведомлений. Пустой SMTPHost отключает письма.
+	SMTPHost     string `json:"smtp_host"`
+	SMTPPort     int    `json:"smtp_port"`
+	SMTPUser     string `json:"smtp_user"`
+	SMTPPassword string `json:"smtp_password"`
+	// SMTPFrom — адрес отправителя писем; по умолчанию SMTPUser.
+	SMTPFrom string `json:"smtp_from"`
+	// EmailNotifyDelayMinutes — сколько минут пользователь должен быть не в сети, чтобы получить письмо.
+	EmailNotifyDelayMinutes int `json:"email_notify_delay_minutes"`
+	// ShutdownTimeout — сколько ждать отключения клиентов при остановке.
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	// PingInterval — как часто проверять живость WebSocket клиентов.
+	PingInterval Duration `json:"ping_interval"`
+	// PongTimeout — сколько ждать ответа на ping, прежде чем закрыть соединение.
+	PongTimeout Duration `json:"pong_timeout"`
+	// TCPIdleTimeout — через сколько отключать TCP клиента, от которого нет данных.
+	TCPIdleTimeout Duration `json:"tcp_idle_timeout"`
+	// AckTimeout — сколько ждать подтверждения доставки сообщения. 0 (по умолчанию) отключает подтверждения:
+	// иначе клиентам, которые не отвечают type:"ack", сообщения приходили бы дважды.
+	AckTimeout Duration `json:"ack_timeout"`
+	// DisconnectSlowClients — отключать клиентов, чья очередь исходящих сообщений переполнена.
+	DisconnectSlowClients bool `json:"disconnect_slow_clients"`
+	// EnableBridge — доставлять сообщения WebSocket клиентов клиентам TCP и обратно (см. Bridge).
+	// По умолчанию протоколы разделены.
+	EnableBridge bool `json:"enable_bridge"`
+	// AllowedOrigins — источники (Origin), с которых разрешены WebSocket подключения.
+	AllowedOrigins []string `json:"allowed_origins"`
+	// BannedWordsFile — файл запрещенных слов (по одному на строку). Пустое значение отключает фильтр.
+	BannedWordsFile string `json:"banned_words_file"`
+	// BlocklistFile — файл, в котором сохраняется список заблокированных диапазонов IP.
+	BlocklistFile string `json:"blocklist_file"`
+	// MaxConnections — максимальное число одновременных подключений (WebSocket и TCP).
+	MaxConnections int `json:"max_connections"`
+	// RedisURL — адрес Redis (redis://host:port/db) для общей рассылки между экземплярами сервера.
+	RedisURL string `json:"redis_url"`
+	// PostgresDSN — строка подключения к PostgreSQL для хранения сообщений. Пустое значение отключает хранение.
+	PostgresDSN string `json:"postgres_dsn"`
+	// SQLiteFile — файл базы SQLite для хранения сообщений, если PostgresDSN не задан.
+	SQLiteFile string `json:"sqlite_file"`
+	// RoomCacheSize — сколько последних сообщений каждой комнаты держать в памяти для GET /messages.
+	RoomCacheSize int `json:"room_cache_size"`
+	// MessageTTLHours — через сколько часов сообщения удаляются из базы и не показываются в истории. 0 — хранить бессрочно.
+	MessageTTLHours int `json:"message_ttl_hours"`
+	// AllowedReactions — эмодзи, которыми можно реагировать на сообщения.
+	AllowedReactions []string `json:"allowed_reactions"`
+	// WebhookURLs — адреса, на которые POST запросом отправляется каждое разосланное сообщение.
+	WebhookURLs []string `json:"webhook_urls"`
+	// WebhookTimeout — таймаут одного запроса к вебхуку.
+	WebhookTimeout Duration `json:"webhook_timeout"`
+	// MaxSendRetries — сколько раз повторять отправку клиенту после временной сетевой ошибки.
+	MaxSendRetries int `json:"max_send_retries"`
+	// CircuitBreakerThreshold — сколько сетевых ошибок отправки клиенту за минуту приостанавливают отправку ему.
+	CircuitBreakerThreshold int `json:"circuit_breaker_threshold"`
+	// PluginDir — каталог плагинов (.so). Пустое значение отключает загрузку плагинов.
+	PluginDir string `json:"plugin_dir"`
+}
+
+// Production сообщает, работает ли сервер в рабочем режиме.
+func (c *Config) Production() bool {
+	return c.Mode == "production"
+}
+
+// MessageTTL возвращает срок хранения сообщений (0 — бессрочно).
+func (c *Config) MessageTTL() time.Duration {
+	return time.Duration(c.MessageTTLHours) * time.Hour
+}
+
+// ReorderBuffer возвращает, сколько сообщения ждут восстановления порядка (0 — не ждут).
+func (c *Config) ReorderBuffer() time.Duration {
+	return time.Duration(c.ReorderBufferMS) * time.Millisecond
+}
+
+// Duration — time.Duration, которая в JSON записывается строкой вида "10s".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// defaultConfig возвращает настройки по умолчанию.
+func defaultConfig() Config {
+	return Config{
+		Mode:                    "dev",
+		WSPort:                  8080,
+		TCPPort:                 8081,
+		GRPCPort:                8082,
+		MQTTPort:                1883,
+		MaxMessageBytes:         4096,
+		MaxAttachmentSizeBytes:  25 << 20,
+		SMTPPort:                587,
+		EmailNotifyDelayMinutes: 5,
+		AllowedAttachmentMimeTypes: []string{
+			"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "text/plain",
+		},
+		BroadcastBuffer:         256,
+		HistorySize:             50,
+		RoomCacheSize:           50,
+		RateLimit:               10,
+		MaxConnections:          1000,
+		BlocklistFile:           "blocklist.json",
+		ShutdownTimeout:         Duration{10 * time.Second},
+		PingInterval:            Duration{30 * time.Second},
+		PongTimeout:             Duration{10 * time.Second},
+		TCPIdleTimeout:          Duration{5 * time.Minute},
+		WebhookTimeout:          Duration{5 * time.Second},
+		OAuthSuccessURL:         "/",
+		OAuthTokenTTL:           Duration{15 * time.Minute},
+		RefreshGracePeriod:      Duration{5 * time.Minute},
+		MaxSendRetries:          3,
+		CircuitBreakerThreshold: 5,
+		AllowedReactions:        []string{"👍", "👎", "❤️", "😂", "😮", "😢", "🎉"},
+	}
+}
+
+// LoadConfig читает настройки из JSON файла path (если он существует)
+// и затем применяет переопределения из переменных окружения.
+func LoadConfig(path string) (Config, error) {
+	config := defaultConfig()
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// Файла нет — работаем на значениях по умолчанию и переменных окружения
+	case err != nil:
+		return Config{}, err
+	default:
+		err = json.Unmarshal(data, &config)
+		if err != nil {
+			return Config{}, fmt.Errorf("разбор %s: %w", path, err)
+		}
+	}
+
+	err = config.applyEnv()
+	if err != nil {
+		return Config{}, err
+	}
+	return config, config.validate()
+}
+
+// applyEnv переопределяет настройки значениями переменных окружения.
+func (c *Config) applyEnv() error {
+	return errors.Join(
+		envString(&c.Mode, "MODE"),
+		envInt(&c.WSPort, "WS_PORT"),
+		envInt(&c.TCPPort, "TCP_PORT"),
+		envInt(&c.GRPCPort, "GRPC_PORT"),
+		envInt(&c.MQTTPort, "MQTT_PORT"),
+		envInt(&c.MaxMessageBytes, "MAX_MESSAGE_BYTES"),
+		envInt(&c.BroadcastBuffer, "BROADCAST_BUFFER"),
+		envInt(&c.HistorySize, "HISTORY_SIZE"),
+		envString(&c.TLSCertFile, "WS_TLS_CERT"),
+		envString(&c.TLSKeyFile, "WS_TLS_KEY"),
+		envString(&c.TCPTLSCertFile, "TCP_TLS_CERT"),
+		envString(&c.TCPTLSKeyFile, "TCP_TLS_KEY"),
+		envString(&c.TCPClientCAFile, "TCP_CLIENT_CA"),
+		envString(&c.JWTSecret, "JWT_SECRET"),
+		envDuration(&c.RefreshGracePeriod.Duration, "REFRESH_GRACE_PERIOD"),
+		envString(&c.APIKeysFile, "API_KEYS_FILE"),
+		envString(&c.AuthProvider, "AUTH_PROVIDER"),
+		envString(&c.OAuthClientID, "OAUTH_CLIENT_ID"),
+		envString(&c.OAuthClientSecret, "OAUTH_CLIENT_SECRET"),
+		envString(&c.OAuthRedirectURL, "OAUTH_REDIRECT_URL"),
+		envString(&c.OAuthSuccessURL, "OAUTH_SUCCESS_URL"),
+		envDuration(&c.OAuthTokenTTL.Duration, "OAUTH_TOKEN_TTL"),
+		envString(&c.AdminToken, "ADMIN_TOKEN"),
+		envList(&c.AdminUsers, "ADMIN_USERS"),
+		envInt(&c.RateLimit, "MAX_MSG_RATE"),
+		envInt(&c.MaxBytesPerSecPerClient, "MAX_BYTES_PER_SEC_PER_CLIENT"),
+		envInt(&c.ReorderBufferMS, "REORDER_BUFFER_MS"),
+		envString(&c.MessageLogFile, "MESSAGE_LOG_FILE"),
+		envString(&c.AuditLogFile, "AUDIT_LOG_FILE"),
+		envString(&c.DeadLetterFile, "DEAD_LETTER_FILE"),
+		envString(&c.FCMServiceAccountJSON, "FCM_SERVICE_ACCOUNT_JSON"),
+		envString(&c.SMTPHost, "SMTP_HOST"),
+		envInt(&c.SMTPPort, "SMTP_PORT"),
+		envString(&c.SMTPUser, "SMTP_USER"),
+		envString(&c.SMTPPassword, "SMTP_PASSWORD"),
+		envString(&c.SMTPFrom, "SMTP_FROM"),
+		envInt(&c.EmailNotifyDelayMinutes, "EMAIL_NOTIFY_DELAY_MINUTES"),
+		envDuration(&c.ShutdownTimeout.Duration, "SHUTDOWN_TIMEOUT"),
+		envDuration(&c.PingInterval.Duration, "PING_INTERVAL"),
+		envDuration(&c.PongTimeout.Duration, "PONG_TIMEOUT"),
+		envDuration(&c.TCPIdleTimeout.Duration, "TCP_IDLE_TIMEOUT"),
+		envDuration(&c.AckTimeout.Duration, "ACK_TIMEOUT"),
+		envBool(&c.DisconnectSlowClients, "DISCONNECT_SLOW_CLIENTS"),
+		envBool(&c.EnableBridge, "ENABLE_BRIDGE"),
+		envList(&c.AllowedOrigins, "ALLOWED_ORIGINS"),
+		envList(&c.AllowedAttachmentDomains, "ALLOWED_ATTACHMENT_DOMAINS"),
+		envInt(&c.MaxAttachmentSizeBytes, "MAX_ATTACHMENT_SIZE_BYTES"),
+		envList(&c.AllowedAttachmentMimeTypes, "ALLOWED_ATTACHMENT_MIME_TYPES"),
+		envString(&c.BannedWordsFile, "BANNED_WORDS_FILE"),
+		envString(&c.BlocklistFile, "BLOCKLIST_FILE"),
+		envInt(&c.MaxConnections, "MAX_CONNECTIONS"),
+		envString(&c.RedisURL, "REDIS_URL"),
+		envString(&c.PostgresDSN, "POSTGRES_DSN"),
+		envString(&c.SQLiteFile, "SQLITE_FILE"),
+		envInt(&c.RoomCacheSize, "ROOM_CACHE_SIZE"),
+		envInt(&c.MessageTTLHours, "MESSAGE_TTL_HOURS"),
+		envList(&c.AllowedReactions, "ALLOWED_REACTIONS"),
+		envList(&c.WebhookURLs, "WEBHOOK_URLS"),
+		envDuration(&c.WebhookTimeout.Duration, "WEBHOOK_TIMEOUT"),
+		envString(&c.PluginDir, "PLUGIN_DIR"),
+		envInt(&c.MaxSendRetries, "MAX_SEND_RETRIES"),
+		envInt(&c.CircuitBreakerThreshold, "CIRCUIT_BREAKER_THRESHOLD"),
+	)
+}
+
+// validate проверяет согласованность настроек.
+func (c *Config) validate() error {
+	var errs []error
+	for name, value := range map[string]int{
+		"ws_port":                   c.WSPort,
+		"tcp_port":                  c.TCPPort,
+		"grpc_port":                 c.GRPCPort,
+		"mqtt_port":                 c.MQTTPort,
+		"max_message_bytes":         c.MaxMessageBytes,
+		"max_attachment_size_bytes": c.MaxAttachmentSizeBytes,
+		"broadcast_buffer":          c.BroadcastBuffer,
+		"history_size":              c.HistorySize,
+		"rate_limit":                c.RateLimit,
+		"max_connections":           c.MaxConnections,
+		"room_cache_size":           c.RoomCacheSize,
+		"circuit_breaker_threshold": c.CircuitBreakerThreshold,
+		"smtp_port":                 c.SMTPPort,
+	} {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s должен быть положительным, получено %d", name, value))
+		}
+	}
+	for name, value := range map[string]time.Duration{
+		"shutdown_timeout": c.ShutdownTimeout.Duration,
+		"ping_interval":    c.PingInterval.Duration,
+		"pong_timeout":     c.PongTimeout.Duration,
+		"tcp_idle_timeout": c.TCPIdleTimeout.Duration,
+		"webhook_timeout":  c.WebhookTimeout.Duration,
+		"oauth_token_ttl":  c.OAuthTokenTTL.Duration,
+	} {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s должен быть положительным, получено %v", name, value))
+		}
+	}
+	if c.Mode != "dev" && c.Mode != "production" {
+		errs = append(errs, fmt.Errorf("mode должен быть \"dev\" или \"production\", получено %q", c.Mode))
+	}
+	if c.MessageTTLHours < 0 {
+		errs = append(errs, errors.New("message_ttl_hours не может быть отрицательным"))
+	}
+	if c.MaxBytesPerSecPerClient < 0 {
+		errs = append(errs, errors.New("max_bytes_per_sec_per_client не может быть отрицательным"))
+	}
+	if c.EmailNotifyDelayMinutes < 0 {
+		errs = append(errs, errors.New("email_notify_delay_minutes не может быть отрицательным"))
+	}
+	if c.SMTPHost != "" && c.SMTPUser == "" && c.SMTPFrom == "" {
+		errs = append(errs, errors.New("для писем нужен smtp_from или smtp_user"))
+	}
+	if c.ReorderBufferMS < 0 {
+		errs = append(errs, errors.New("reorder_buffer_ms не может быть отрицательным"))
+	}
+	if c.MaxSendRetries < 0 {
+		errs = append(errs, errors.New("max_send_retries не может быть отрицательным"))
+	}
+	if c.RefreshGracePeriod.Duration < 0 {
+		errs = append(errs, errors.New("refresh_grace_period не может быть отрицательным"))
+	}
+	if c.AckTimeout.Duration < 0 {
+		errs = append(errs, errors.New("ack_timeout не может быть отрицательным"))
+	}
+	if c.AuthProvider != "" {
+		if _, ok := oauthProviders[c.AuthProvider]; !ok {
+			errs = append(errs, fmt.Errorf("auth_provider должен быть \"github\" или \"google\", получено %q", c.AuthProvider))
+		}
+		if c.JWTSecret == "" {
+			errs = append(errs, errors.New("для входа через OAuth2 нужен jwt_secret"))
+		}
+		if c.OAuthClientID == "" || c.OAuthClientSecret == "" || c.OAuthRedirectURL == "" {
+			errs = append(errs, errors.New("для входа через OAuth2 нужно задать oauth_client_id, oauth_client_secret и oauth_redirect_url"))
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("для TLS WebSocket нужно задать и сертификат, и ключ"))
+	}
+	if (c.TCPTLSCertFile == "") != (c.TCPTLSKeyFile == "") {
+		errs = append(errs, errors.New("для TLS TCP нужно задать и сертификат, и ключ"))
+	}
+	return errors.Join(errs...)
+}
+
+// envString переопределяет *dst значением переменной окружения, если она задана.
+func envString(dst *string, name string) error {
+	if value, ok := os.LookupEnv(name); ok {
+		*dst = value
+	}
+	return nil
+}
+
+// envInt переопределяет *dst целым числом из переменной окружения, если она задана.
+func envInt(dst *int, name string) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("некорректное значение %s=%q", name, value)
+	}
+	*dst = n
+	return nil
+}
+
+// envDuration переопределяет *dst длительностью (например, "10s") из переменной окружения, если она задана.
+func envDuration(dst *time.Duration, name string) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("некорректное значение %s=%q", name, value)
+	}
+	*dst = d
+	return nil
+}
+
+// envBool переопределяет *dst логическим значением из переменной окружения, если она задана.
+func envBool(dst *bool, name string) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("некорректное значение %s=%q", name, value)
+	}
+	*dst = b
+	return nil
+}
+
+// envList переопределяет *dst списком значений через запятую из переменной окружения, если она задана.
+func envList(dst *[]string, name string) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	*dst = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*dst = append(*dst, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// connSlots — семафор одновременных подключений (WebSocket и TCP) емкостью Config.MaxConnections.
+// Создается в main.
+var connSlots chan struct{}
+
+// acquireConnSlot занимает место под новое подключение, не блокируясь.
+// Возвращает false, если сервер уже обслуживает максимум подключений.
+func acquireConnSlot() bool {
+	select {
+	case connSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseConnSlot освобождает место, занятое acquireConnSlot.
+func releaseConnSlot() {
+	<-connSlots
+}
+
+// limitConnections отвечает 503 на WebSocket подключения сверх Config.MaxConnections.
+// Место удерживается, пока обрабатывается соединение.
+func limitConnections(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acquireConnSlot() {
+			slog.Warn("Отказ в подключении: достигнут лимит подключений", "remote_addr", r.RemoteAddr, "max_connections", cap(connSlots))
+			http.Error(w, "too many connections", http.StatusServiceUnavailable)
+			return
+		}
+		defer releaseConnSlot()
+		next.ServeHTTP(w, r)
+	})
+}
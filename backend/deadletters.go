@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var deadLettersTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "chat_dead_letters_total",
+	Help: "Broadcast messages that no targeted client received.",
+})
+
+// DeadLetter — сообщение рассылки, которое не удалось отправить ни одному получателю.
+type DeadLetter struct {
+	ID      string  `json:"id"`
+	Message Message `json:"message"`
+	// FailedAt — когда не удалась последняя попытка.
+	FailedAt time.Time `json:"failed_at"`
+	// Attempts — число неудачных попыток доставки по всем получателям, включая прежние повторы.
+	Attempts int `json:"attempts"`
+	// Targets — ID клиентов, которым сообщение предназначалось.
+	Targets []string `json:"targets"`
+}
+
+// DeadLetterQueue дописывает недоставленные сообщения в файл NDJSON (DEAD_LETTER_FILE).
+// Файл только растет: повтор доставки не удаляет запись, а при новой неудаче добавляет следующую.
+type DeadLetterQueue struct {
+	file *os.File
+	// mutex сериализует запись: недоставленные сообщения фиксируют горутины отправки клиентов.
+	mutex sync.Mutex
+}
+
+// deadLetters — очередь недоставленных сообщений; nil, если DEAD_LETTER_FILE не задан.
+var deadLetters *DeadLetterQueue
+
+// openDeadLetterQueue открывает файл очереди на дозапись, создавая его при необходимости.
+func openDeadLetterQueue(path string) (*DeadLetterQueue, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetterQueue{file: file}, nil
+}
+
+// Add записывает недоставленное сообщение. Ошибки записи только логируются.
+// Вызов на nil очереди лишь учитывает сообщение в метрике.
+func (q *DeadLetterQueue) Add(msg Message, attempts int, targets []string) {
+	deadLettersTotal.Inc()
+	slog.Warn("Сообщение не доставлено ни одному получателю", "msg_id", msg.MsgID, "attempts", attempts, "targets", len(targets))
+	if q == nil {
+		return
+	}
+
+	data, err := json.Marshal(DeadLetter{
+		ID:       newMsgID(),
+		Message:  msg,
+		FailedAt: time.Now().UTC(),
+		Attempts: attempts,
+		Targets:  targets,
+	})
+	if err != nil {
+		slog.Error("Ошибка сериализации недоставленного сообщения", "msg_id", msg.MsgID, "err", err)
+		return
+	}
+	data = append(data, '\n')
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	_, err = q.file.Write(data)
+	if err != nil {
+		slog.Error("Ошибка записи недоставленного сообщения", "msg_id", msg.MsgID, "err", err)
+	}
+}
+
+// ReadAll читает все записи очереди в порядке записи. Поврежденные строки пропускаются.
+func (q *DeadLetterQueue) ReadAll() ([]DeadLetter, error) {
+	file, err := os.Open(q.file.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxTCPFrameSize)
+	for scanner.Scan() {
+		var letter DeadLetter
+		if json.Unmarshal(scanner.Bytes(), &letter) != nil {
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	return letters, scanner.Err()
+}
+
+// RemoveUser переписывает файл очереди без сообщений пользователя username арендатора tenant:
+// отправленных им и личных сообщений ему. Вызов на nil очереди ничего не делает.
+func (q *DeadLetterQueue) RemoveUser(tenant, username string) error {
+	if q == nil {
+		return nil
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	path := q.file.Name()
+	err := rewriteLines(path, 0o600, func(line []byte) bool {
+		var letter DeadLetter
+		return json.Unmarshal(line, &letter) == nil && userMessage(letter.Message, tenant, username)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Прежний дескриптор указывает на замененный файл
+	reopened, err := openDeadLetterQueue(path)
+	if err != nil {
+		return err
+	}
+	q.file.Close()
+	q.file = reopened.file
+	return nil
+}
+
+// Close закрывает файл очереди.
+func (q *DeadLetterQueue) Close() error {
+	if q == nil {
+		return nil
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.file.Close()
+}
+
+// tenantDeadLetters возвращает записи очереди арендатора из X-Tenant-ID, начиная с последних.
+// Если очередь не настроена или не читается, отвечает ошибкой и возвращает false.
+func tenantDeadLetters(w http.ResponseWriter, r *http.Request) ([]DeadLetter, bool) {
+	if deadLetters == nil {
+		http.Error(w, "очередь недоставленных сообщений не настроена", http.StatusNotFound)
+		return nil, false
+	}
+	letters, err := deadLetters.ReadAll()
+	if err != nil {
+		slog.Error("Ошибка чтения очереди недоставленных сообщений", "err", err)
+		http.Error(w, "ошибка чтения очереди недоставленных сообщений", http.StatusInternalServerError)
+		return nil, false
+	}
+	tenant := requestTenant(r)
+	letters = slices.DeleteFunc(letters, func(letter DeadLetter) bool { return letter.Message.Tenant != tenant })
+	slices.Reverse(letters)
+	return letters, true
+}
+
+// handleDeadLetters возвращает последние недоставленные сообщения арендатора
+// (GET /admin/dead-letters?limit=50).
+func (s *Server) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultPageLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	letters, ok := tenantDeadLetters(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, paginate(letters, 0, min(limit, maxPageLimit)))
+}
+
+// handleDeadLetterRetry заново ставит недоставленное сообщение в очереди его получателей,
+// которые сейчас подключены (POST /admin/dead-letters/{id}/retry). Если и эта попытка
+// не удастся, в очередь недоставленных попадет новая запись.
+func (s *Server) handleDeadLetterRetry(w http.ResponseWriter, r *http.Request) {
+	letters, ok := tenantDeadLetters(w, r)
+	if !ok {
+		return
+	}
+	i := slices.IndexFunc(letters, func(letter DeadLetter) bool { return letter.ID == r.PathValue("id") })
+	if i < 0 {
+		http.Error(w, "dead letter not found", http.StatusNotFound)
+		return
+	}
+	letter := letters[i]
+
+	queued := s.redeliver(letter)
+	if queued == 0 {
+		http.Error(w, "ни один получатель сообщения не подключен", http.StatusConflict)
+		return
+	}
+	slog.Info("Повторная доставка сообщения", "msg_id", letter.Message.MsgID, "dead_letter_id", letter.ID, "clients", queued)
+	writeJSON(w, http.StatusAccepted, map[string]int{"queued": queued})
+}
+
+// redeliver ставит сообщение записи в очереди подключенных получателей из Targets
+// и возвращает их число.
+func (s *Server) redeliver(letter DeadLetter) int {
+	msg := letter.Message
+	tracker := newFanoutTracker(msg, time.Now())
+	tracker.attempts = letter.Attempts
+	defer tracker.done(false)
+	msg.fanout = tracker
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for client := range s.clients {
+		if client.Tenant == msg.Tenant && slices.Contains(letter.Targets, client.ID) {
+			s.sendLocked(client, msg)
+		}
+	}
+	return len(tracker.targets)
+}
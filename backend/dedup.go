@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// dedupWindow — сколько последних MsgID помнит recentMessages.
+const dedupWindow = 10000
+
+// maxClientMsgIDBytes — наибольшая длина MsgID, присвоенного клиентом.
+const maxClientMsgIDBytes = 64
+
+var messagesDeduplicatedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "chat_messages_deduplicated_total",
+	Help: "Client messages dropped because their msg_id was already seen.",
+})
+
+// recentIDs — MsgID последних dedupWindow сообщений по арендаторам. Повтор сообщения,
+// отправленного клиентом заново после сетевой ошибки, узнается по его MsgID.
+// Самый старый ID вытесняется, когда окно заполнено.
+type recentIDs struct {
+	mutex sync.Mutex
+	seen  map[tenantName]struct{}
+	// ring хранит ID в порядке поступления; next — позиция самого старого.
+	ring []tenantName
+	next int
+}
+
+// recentMessages — MsgID недавних сообщений клиентов.
+var recentMessages = newRecentIDs(dedupWindow)
+
+// newRecentIDs создает окно из size последних ID.
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{seen: make(map[tenantName]struct{}, size), ring: make([]tenantName, 0, size)}
+}
+
+// Seen сообщает, был ли уже ID msgID у арендатора tenant, и запоминает его, если не был.
+func (r *recentIDs) Seen(tenant, msgID string) bool {
+	key := tenantName{tenant, msgID}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.seen[key]; ok {
+		return true
+	}
+	if len(r.ring) < cap(r.ring) {
+		r.ring = append(r.ring, key)
+	} else {
+		delete(r.seen, r.ring[r.next])
+		r.ring[r.next] = key
+		r.next = (r.next + 1) % len(r.ring)
+	}
+	r.seen[key] = struct{}{}
+	return false
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// emailInterval — не чаще какого интервала пользователь получает письмо о личных сообщениях.
+const emailInterval = time.Hour
+
+var emailsSentTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "chat_emails_sent_total",
+	Help: "Email notifications about direct messages sent to offline users.",
+})
+
+// emailTemplate — тело письма о личном сообщении.
+var emailTemplate = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html>
+<body>
+<p>Пока вас не было в чате, <b>{{.From}}</b> написал(а) вам:</p>
+<blockquote>{{.Text}}</blockquote>
+<p style="color: #888">{{.SentAt.Format "02.01.2006 15:04 MST"}}</p>
+</body>
+</html>
+`))
+
+// Mailer отправляет по SMTP письма о личных сообщениях пользователям, которые не в сети
+// дольше EmailNotifyDelayMinutes и не зарегистрировали устройство для push уведомлений.
+// Пользователь получает не больше одного письма в emailInterval.
+type Mailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+	// delay — сколько пользователь должен быть не в сети, прежде чем ему напишут.
+	delay time.Duration
+
+	mutex sync.Mutex
+	// lastSent — когда пользователю отправлено последнее письмо.
+	lastSent map[tenantName]time.Time
+	// waiting — пользователи, письмо которым ждет истечения delay.
+	waiting map[tenantName]bool
+}
+
+// mailer — отправка писем; nil, если SMTP_HOST не задан.
+var mailer *Mailer
+
+// newMailer создает Mailer по настройкам SMTP из cfg.
+func newMailer(cfg Config) *Mailer {
+	m := &Mailer{
+		addr:     net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+		from:     cfg.SMTPFrom,
+		delay:    time.Duration(cfg.EmailNotifyDelayMinutes) * time.Minute,
+		lastSent: make(map[tenantName]time.Time),
+		waiting:  make(map[tenantName]bool),
+	}
+	if m.from == "" {
+		m.from = cfg.SMTPUser
+	}
+	if cfg.SMTPUser != "" {
+		m.auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	return m
+}
+
+// Notify планирует письмо получателю личного сообщения msg: сразу, если он не в сети дольше delay,
+// иначе — когда delay истечет, если он так и не подключится. Письмо, которое уже ждет отправки,
+// и письма чаще emailInterval не планируются.
+func (m *Mailer) Notify(s *Server, msg Message) {
+	key := tenantName{msg.Tenant, msg.Recipient}
+	m.mutex.Lock()
+	if m.waiting[key] || time.Since(m.lastSent[key]) < emailInterval {
+		m.mutex.Unlock()
+		return
+	}
+	m.waiting[key] = true
+	m.mutex.Unlock()
+
+	// LastSeen — с какого времени получатель не в сети; у того, кто ни разу не подключался, — нулевое время
+	entry, _ := s.presence.Get(msg.Tenant, msg.Recipient)
+	wait := m.delay - time.Since(entry.LastSeen)
+	time.AfterFunc(max(wait, 0), func() { m.send(s, msg) })
+}
+
+// send отправляет письмо о msg, если получатель все еще не в сети.
+func (m *Mailer) send(s *Server, msg Message) {
+	key := tenantName{msg.Tenant, msg.Recipient}
+	defer func() {
+		m.mutex.Lock()
+		delete(m.waiting, key)
+		m.mutex.Unlock()
+	}()
+	logger := slog.With("msg_id", msg.MsgID, "recipient", msg.Recipient)
+
+	s.mutex.RLock()
+	_, online := s.clientsByName[key]
+	s.mutex.RUnlock()
+	if online {
+		return
+	}
+	user, ok, err := messageStore.User(msg.Tenant, msg.Recipient)
+	if err != nil {
+		logger.Error("Ошибка чтения профиля пользователя", "err", err)
+		return
+	}
+	if !ok || user.Email == "" {
+		return
+	}
+
+	body, err := m.compose(user.Email, msg)
+	if err != nil {
+		logger.Error("Ошибка подготовки письма", "err", err)
+		return
+	}
+	err = smtp.SendMail(m.addr, m.auth, m.from, []string{user.Email}, body)
+	if err != nil {
+		logger.Warn("Ошибка отправки письма", "err", err)
+		return
+	}
+
+	m.mutex.Lock()
+	m.lastSent[key] = time.Now()
+	m.mutex.Unlock()
+	emailsSentTotal.Inc()
+	logger.Info("Письмо о личном сообщении отправлено")
+}
+
+// compose формирует письмо о msg для адреса to: заголовки и HTML тело из emailTemplate.
+func (m *Mailer) compose(to string, msg Message) ([]byte, error) {
+	var body bytes.Buffer
+	err := emailTemplate.Execute(&body, map[string]any{"From": msg.Username, "Text": msg.Text, "SentAt": msg.SentAt})
+	if err != nil {
+		return nil, err
+	}
+
+	var email bytes.Buffer
+	fmt.Fprintf(&email, "From: %s\r\n", m.from)
+	fmt.Fprintf(&email, "To: %s\r\n", to)
+	fmt.Fprintf(&email, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "Новое личное сообщение от "+msg.Username))
+	fmt.Fprintf(&email, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	email.WriteString("MIME-Version: 1.0\r\n")
+	email.WriteString("Content-Type: text/html; charset=utf-8\r\n\r\n")
+	email.Write(body.Bytes())
+	return email.Bytes(), nil
+}
+
+// rememberEmail сохраняет адрес пользователя из поля email JWT, если он есть.
+func rememberEmail(client *Client, r *http.Request) {
+	email, _ := r.Context().Value(authEmailKey{}).(string)
+	if email == "" || messageStore == nil {
+		return
+	}
+	err := messageStore.SetUserEmail(client.Tenant, client.Username, email)
+	if err != nil {
+		client.logger().Error("Ошибка сохранения адреса почты", "err", err)
+	}
+}
+
+// profileRequest — тело PUT /profile.
+type profileRequest struct {
+	Email string `json:"email"`
+	// Username — имя пользователя, если аутентификация отключена; с JWT или API ключом берется из них.
+	Username string `json:"username"`
+}
+
+// handleUpdateProfile сохраняет адрес почты пользователя для уведомлений (PUT /profile).
+// Пустой адрес отключает письма.
+func (s *Server) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	if messageStore == nil {
+		http.Error(w, "хранилище сообщений не настроено", http.StatusNotFound)
+		return
+	}
+	var req profileRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req)
+	if err != nil {
+		http.Error(w, "некорректное тело запроса", http.StatusBadRequest)
+		return
+	}
+	if username, ok := authenticatedUser(r.Context()); ok {
+		req.Username = username
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		http.Error(w, "не указано имя пользователя", http.StatusBadRequest)
+		return
+	}
+	if req.Email != "" {
+		address, err := mail.ParseAddress(req.Email)
+		if err != nil {
+			http.Error(w, "некорректный адрес почты", http.StatusBadRequest)
+			return
+		}
+		req.Email = address.Address
+	}
+
+	err = messageStore.SetUserEmail(requestTenant(r), req.Username, req.Email)
+	if err != nil {
+		slog.Error("Ошибка сохранения профиля", "username", req.Username, "err", err)
+		http.Error(w, "ошибка сохранения профиля", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, User{Tenant: requestTenant(r), Username: req.Username, Email: req.Email})
+}
+
+// errNoStoreForNotifications — уведомления включены без базы, где хранятся устройства и адреса.
+var errNoStoreForNotifications = errors.New("для уведомлений нужна база (POSTGRES_DSN или SQLITE_FILE)")
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Типы событий EventBus.
+const (
+	EventClientConnect    = "client.connect"
+	EventClientDisconnect = "client.disconnect"
+	EventMessageReceived  = "message.received"
+	EventMessageBroadcast = "message.broadcast"
+	EventRoomJoin         = "room.join"
+	EventRoomLeave        = "room.leave"
+)
+
+// Event — событие жизненного цикла сервера.
+type Event struct {
+	// Type — тип события (см. константы Event*).
+	Type string
+	// Client — клиент, к которому относится событие. Для message.broadcast — nil.
+	Client *Client
+	// Message — сообщение для событий message.*.
+	Message Message
+	// Room — комната для событий room.* и сообщений комнат.
+	Room string
+	// At — время события.
+	At time.Time
+}
+
+// eventHandler — обработчик события, async — запускать ли его в отдельной горутине.
+type eventHandler struct {
+	fn    func(Event)
+	async bool
+}
+
+// EventBus рассылает события зарегистрированным обработчикам (плагинам, проверкам в тестах).
+type EventBus struct {
+	handlers map[string][]eventHandler
+	// mutex для безопасного доступа к handlers.
+	mutex sync.RWMutex
+}
+
+func newEventBus() *EventBus {
+	return &EventBus{handlers: make(map[string][]eventHandler)}
+}
+
+// On регистрирует обработчик события. Обработчик выполняется синхронно в горутине,
+// вызвавшей Emit, поэтому не должен блокироваться надолго.
+func (b *EventBus) On(eventType string, handler func(Event)) {
+	b.add(eventType, eventHandler{fn: handler})
+}
+
+// OnAsync регистрирует обработчик, который выполняется в отдельной горутине и не задерживает Emit.
+func (b *EventBus) OnAsync(eventType string, handler func(Event)) {
+	b.add(eventType, eventHandler{fn: handler, async: true})
+}
+
+func (b *EventBus) add(eventType string, handler eventHandler) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// OffAll удаляет все обработчики события.
+func (b *EventBus) OffAll(eventType string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.handlers, eventType)
+}
+
+// Emit передает событие обработчикам в порядке регистрации. Пустое At заполняется текущим временем.
+func (b *EventBus) Emit(event Event) {
+	b.mutex.RLock()
+	handlers := b.handlers[event.Type]
+	b.mutex.RUnlock()
+	if len(handlers) == 0 {
+		return
+	}
+
+	if event.At.IsZero() {
+		event.At = time.Now().UTC()
+	}
+	for _, handler := range handlers {
+		if handler.async {
+			go handler.fn(event)
+		} else {
+			handler.fn(event)
+		}
+	}
+}
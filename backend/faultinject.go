@@ -0,0 +1,87 @@
+//go:build chaos
+
+package main
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// FaultInjector подменяет часть отправок клиентам искусственными сетевыми ошибками,
+// чтобы проверить повторы отправки и circuitBreaker. Собирается только с тегом chaos:
+//
+//	go build -tags chaos .
+//
+// и включается переменной FAULT_INJECT=true. Вероятность задает FAULT_PROBABILITY
+// (по умолчанию 0.1), вид ошибки — FAULT_ERROR_TYPE:
+//   - "timeout" (по умолчанию) — временная сетевая ошибка, отправка повторяется;
+//   - "reset" — сброс соединения, клиент отключается.
+type FaultInjector struct {
+	// Probability — вероятность ошибки при каждой отправке, от 0 до 1.
+	Probability float64
+	// ErrorType — вид ошибки: "timeout" или "reset".
+	ErrorType string
+}
+
+// faults возвращает включенный FaultInjector или nil. Переменные окружения
+// читаются при первой отправке, когда журнал уже настроен.
+var faults = sync.OnceValue(faultInjectorFromEnv)
+
+// faultInjectorFromEnv создает FaultInjector по переменным окружения, если FAULT_INJECT=true.
+func faultInjectorFromEnv() *FaultInjector {
+	if enabled, _ := strconv.ParseBool(os.Getenv("FAULT_INJECT")); !enabled {
+		return nil
+	}
+	f := &FaultInjector{Probability: 0.1, ErrorType: "timeout"}
+	if value := os.Getenv("FAULT_PROBABILITY"); value != "" {
+		p, err := strconv.ParseFloat(value, 64)
+		if err != nil || p < 0 || p > 1 {
+			slog.Error("Некорректная переменная окружения", "name", "FAULT_PROBABILITY", "value", value)
+			os.Exit(1)
+		}
+		f.Probability = p
+	}
+	if value := os.Getenv("FAULT_ERROR_TYPE"); value != "" {
+		if value != "timeout" && value != "reset" {
+			slog.Error("Некорректная переменная окружения", "name", "FAULT_ERROR_TYPE", "value", value)
+			os.Exit(1)
+		}
+		f.ErrorType = value
+	}
+	slog.Warn("Включено внесение ошибок в отправку клиентам", "probability", f.Probability, "error_type", f.ErrorType)
+	return f
+}
+
+// Fault с вероятностью Probability возвращает искусственную ошибку, иначе nil.
+func (f *FaultInjector) Fault() error {
+	if rand.Float64() >= f.Probability {
+		return nil
+	}
+	if f.ErrorType == "reset" {
+		return syscall.ECONNRESET
+	}
+	return errInjectedTimeout
+}
+
+// injectedTimeout — искусственный таймаут, неотличимый для retryableSendError от настоящего.
+type injectedTimeout struct{}
+
+func (injectedTimeout) Error() string {
+	return "искусственная ошибка: таймаут отправки"
+}
+func (injectedTimeout) Timeout() bool   { return true }
+func (injectedTimeout) Temporary() bool { return true }
+
+var errInjectedTimeout error = injectedTimeout{}
+
+// injectFault возвращает искусственную ошибку отправки, если FaultInjector включен.
+func injectFault() error {
+	if f := faults(); f != nil {
+		return f.Fault()
+	}
+	return nil
+}
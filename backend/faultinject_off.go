@@ -0,0 +1,8 @@
+//go:build !chaos
+
+package main
+
+// injectFault без тега chaos ничего не делает (см. faultinject.go).
+func injectFault() error {
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// leetSubstitutions — типичные замены букв в обход фильтра (l33tspeak).
+// '*' часто заменяет любую букву, поэтому он допускается вместо любого символа слова.
+var leetSubstitutions = map[rune]string{
+	'a': "a4@",
+	'b': "b8",
+	'e': "e3",
+	'g': "g9",
+	'i': "i1!|",
+	'l': "l1|",
+	'o': "o0",
+	's': "s5$",
+	't': "t7+",
+	'u': "uv",
+	'z': "z2",
+}
+
+// WordFilter находит в тексте запрещенные слова без учета регистра и с учетом l33tspeak.
+type WordFilter struct {
+	words    []string
+	patterns []*regexp.Regexp
+}
+
+// wordFilter — фильтр сообщений; nil, если BANNED_WORDS_FILE не задан.
+var wordFilter *WordFilter
+
+// loadWordFilter читает файл запрещенных слов (по одному на строку, пустые строки
+// и строки, начинающиеся с '#', пропускаются).
+func loadWordFilter(path string) (*WordFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	filter := &WordFilter{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		filter.words = append(filter.words, word)
+		filter.patterns = append(filter.patterns, wordPattern(word))
+	}
+	return filter, scanner.Err()
+}
+
+// wordPattern строит регулярное выражение для слова: каждая буква может быть
+// заменена своим l33tspeak вариантом или '*', а слово должно стоять отдельно.
+func wordPattern(word string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString(`(?i)(?:^|[^\p{L}\p{N}])`)
+	for _, r := range word {
+		b.WriteString("[")
+		b.WriteString(regexp.QuoteMeta(string(r) + leetSubstitutions[r]))
+		b.WriteString(`*]`)
+	}
+	b.WriteString(`(?:$|[^\p{L}\p{N}])`)
+	return regexp.MustCompile(b.String())
+}
+
+// Match возвращает первое найденное в тексте запрещенное слово.
+// На nil фильтре всегда возвращает false.
+func (f *WordFilter) Match(text string) (string, bool) {
+	if f == nil {
+		return "", false
+	}
+	for i, pattern := range f.patterns {
+		if pattern.MatchString(text) {
+			return f.words[i], true
+		}
+	}
+	return "", false
+}
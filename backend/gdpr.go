@@ -0,0 +1,414 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Действия журнала аудита: выгрузка и удаление данных пользователя.
+const (
+	auditUserExport = "user.export"
+	auditUserErase  = "user.erase"
+)
+
+// deletionBatchSize — сколько сообщений удаляется из базы за один запрос,
+// чтобы удаление большой переписки не блокировало таблицу надолго.
+const deletionBatchSize = 1000
+
+var messagesExportedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "chat_messages_exported_total",
+	Help: "Messages included in user data exports.",
+})
+
+// userProfile — данные пользователя, которые сервер держит в памяти (profile.json выгрузки).
+type userProfile struct {
+	Username string         `json:"username"`
+	Presence *PresenceEntry `json:"presence,omitempty"`
+	Admin    bool           `json:"admin"`
+	Muted    bool           `json:"muted"`
+	// Reactions — реакции пользователя: MsgID → эмодзи.
+	Reactions map[string][]string `json:"reactions"`
+}
+
+// userConnection — активное подключение пользователя (connections.json выгрузки).
+type userConnection struct {
+	ID          string    `json:"id"`
+	Protocol    string    `json:"protocol"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// handleUserExport отдает ZIP архив со всеми данными пользователя (GET /admin/users/{username}/export):
+//   - messages.ndjson — его сообщения из базы, по одному JSON объекту на строку;
+//   - profile.json — присутствие, права и реакции;
+//   - connections.json — активные подключения;
+//   - audit.json — записи журнала аудита, где он исполнитель или цель.
+//
+// Пользователь ищется у арендатора из заголовка X-Tenant-ID (без него — у арендатора по умолчанию).
+// Архив пишется в io.Pipe и отдается по мере формирования, не собираясь в памяти целиком.
+func (s *Server) handleUserExport(w http.ResponseWriter, r *http.Request) {
+	username, tenant := r.PathValue("username"), requestTenant(r)
+	auditLog.Record(auditUserExport, auditAdminActor, username, r.RemoteAddr, "tenant", tenant)
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(s.writeUserExport(writer, tenant, username))
+	}()
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+username+`.zip"`)
+	_, err := io.Copy(w, reader)
+	if err != nil {
+		// Заголовки уже отправлены, сообщить клиенту об ошибке можно только обрывом ответа
+		slog.Error("Ошибка выгрузки данных пользователя", "username", username, "err", err)
+	}
+}
+
+// writeUserExport пишет ZIP архив с данными пользователя username арендатора tenant в out.
+func (s *Server) writeUserExport(out io.Writer, tenant, username string) error {
+	archive := zip.NewWriter(out)
+
+	file, err := archive.Create("messages.ndjson")
+	if err != nil {
+		return err
+	}
+	if messageStore != nil {
+		buffered := bufio.NewWriter(file)
+		encoder := json.NewEncoder(buffered)
+		err = messageStore.EachByUsername(tenant, username, func(msg Message) error {
+			messagesExportedTotal.Inc()
+			return encoder.Encode(msg)
+		})
+		if err != nil {
+			return err
+		}
+		err = buffered.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeZipJSON(archive, "profile.json", s.userProfile(tenant, username))
+	if err != nil {
+		return err
+	}
+	err = writeZipJSON(archive, "connections.json", s.userConnections(tenant, username))
+	if err != nil {
+		return err
+	}
+	entries, err := auditEntries(tenant, username)
+	if err != nil {
+		return err
+	}
+	err = writeZipJSON(archive, "audit.json", entries)
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// writeZipJSON добавляет в архив файл name с value в JSON.
+func writeZipJSON(archive *zip.Writer, name string, value any) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// userProfile собирает данные пользователя, которые хранятся в памяти.
+func (s *Server) userProfile(tenant, username string) userProfile {
+	profile := userProfile{
+		Username:  username,
+		Admin:     slices.Contains(s.config.AdminUsers, username),
+		Muted:     isMuted(tenant, username),
+		Reactions: make(map[string][]string),
+	}
+
+	if entry, ok := s.presence.Get(tenant, username); ok {
+		profile.Presence = &entry
+	}
+
+	reactionsMutex.Lock()
+	for key, byEmoji := range reactions {
+		if key.tenant != tenant {
+			continue
+		}
+		for emoji, users := range byEmoji {
+			if slices.Contains(users, username) {
+				profile.Reactions[key.name] = append(profile.Reactions[key.name], emoji)
+			}
+		}
+	}
+	reactionsMutex.Unlock()
+	return profile
+}
+
+// userConnections возвращает активные подключения пользователя username арендатора tenant.
+func (s *Server) userConnections(tenant, username string) []userConnection {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	connections := []userConnection{}
+	for client := range s.clients {
+		if client.Tenant == tenant && client.Username == username {
+			connections = append(connections, userConnection{
+				ID:          client.ID,
+				Protocol:    client.Protocol,
+				RemoteAddr:  client.RemoteAddr,
+				ConnectedAt: client.ConnectedAt,
+			})
+		}
+	}
+	return connections
+}
+
+// auditEntries возвращает записи текущего файла журнала аудита арендатора tenant, где пользователь —
+// исполнитель (actor), цель (target) или отключенный клиент (username). Записи без поля tenant
+// относятся к арендатору по умолчанию. Файлы, уже переименованные ротацией, не читаются.
+func auditEntries(tenant, username string) ([]map[string]any, error) {
+	entries := []map[string]any{}
+	if auditLog == nil {
+		return entries, nil
+	}
+
+	file, err := os.Open(auditLog.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry map[string]any
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if entryTenant, _ := entry["tenant"].(string); entryTenant != tenant {
+			continue
+		}
+		if entry["actor"] == username || entry["target"] == username || entry["username"] == username {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// handleUserErase удаляет все данные пользователя арендатора из X-Tenant-ID (DELETE /admin/users/{username}):
+// отключает его активные сессии и убирает их из комнат, забывает присутствие, запрет
+// писать, роли и приглашения в комнатах, реакции и отметки о прочтении, отменяет его отложенные
+// сообщения, убирает его сообщения из истории, журнала сообщений и очереди недоставленных
+// сообщений. Сообщения в базе удаляются в фоне
+// задачей deletion_jobs; ее ход показывает GET /admin/users/{username}/deletion-status.
+func (s *Server) handleUserErase(w http.ResponseWriter, r *http.Request) {
+	username, tenant := r.PathValue("username"), requestTenant(r)
+	auditLog.Record(auditUserErase, auditAdminActor, username, r.RemoteAddr, "tenant", tenant)
+	slog.Info("Удаление данных пользователя", "username", username, "tenant", tenant)
+
+	s.disconnectUser(tenant, username)
+
+	s.presence.Delete(tenant, username)
+
+	key := tenantName{tenant, username}
+	mutedMutex.Lock()
+	delete(mutedGlobal, key)
+	mutedMutex.Unlock()
+
+	reactionsMutex.Lock()
+	for msgKey, byEmoji := range reactions {
+		if msgKey.tenant != tenant {
+			continue
+		}
+		for emoji, users := range byEmoji {
+			users = slices.DeleteFunc(users, func(u string) bool { return u == username })
+			if len(users) == 0 {
+				delete(byEmoji, emoji)
+			} else {
+				byEmoji[emoji] = users
+			}
+		}
+		if len(byEmoji) == 0 {
+			delete(reactions, msgKey)
+		}
+	}
+	reactionsMutex.Unlock()
+
+	s.receipts.RemoveUser(tenant, username)
+	cancelUserScheduled(tenant, username)
+
+	s.forgetRoomUser(tenant, username)
+	s.history.RemoveUser(tenant, username)
+	roomCache.RemoveUser(tenant, username)
+	err := s.messageLog.RemoveUser(tenant, username)
+	if err != nil {
+		slog.Error("Ошибка удаления сообщений пользователя из журнала", "username", username, "err", err)
+		http.Error(w, "ошибка удаления сообщений из журнала", http.StatusInternalServerError)
+		return
+	}
+	err = deadLetters.RemoveUser(tenant, username)
+	if err != nil {
+		slog.Error("Ошибка удаления сообщений пользователя из очереди недоставленных", "username", username, "err", err)
+		http.Error(w, "ошибка удаления сообщений из очереди недоставленных", http.StatusInternalServerError)
+		return
+	}
+
+	if messageStore == nil {
+		writeJSON(w, http.StatusAccepted, DeletionJob{Tenant: tenant, Username: username, Status: "done"})
+		return
+	}
+	job, err := messageStore.CreateDeletionJob(tenant, username)
+	if err != nil {
+		slog.Error("Ошибка создания задачи удаления", "username", username, "err", err)
+		http.Error(w, "ошибка создания задачи удаления", http.StatusInternalServerError)
+		return
+	}
+	go runDeletionJob(job)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// userMessage сообщает, отправлено ли сообщение msg пользователем username арендатора tenant
+// или адресовано ему лично.
+func userMessage(msg Message, tenant, username string) bool {
+	return msg.Tenant == tenant && (msg.Username == username || msg.Recipient == username)
+}
+
+// forgetRoomUser убирает пользователя username из ролей, приглашений, запретов писать и токенов
+// входа комнат арендатора tenant: иначе тот, кто позже займет его имя, получил бы его права в комнатах.
+func (s *Server) forgetRoomUser(tenant, username string) {
+	s.roomsMutex.Lock()
+	defer s.roomsMutex.Unlock()
+	for key, room := range s.rooms {
+		if key.tenant != tenant {
+			continue
+		}
+		room.mutex.Lock()
+		delete(room.Roles, username)
+		delete(room.invited, username)
+		delete(room.MutedUsers, username)
+		maps.DeleteFunc(room.joinTokens, func(_, owner string) bool { return owner == username })
+		room.mutex.Unlock()
+	}
+}
+
+// disconnectUser отключает все подключения пользователя username арендатора tenant и убирает их из комнат.
+func (s *Server) disconnectUser(tenant, username string) {
+	s.mutex.RLock()
+	var sessions []*Client
+	for client := range s.clients {
+		if client.Tenant == tenant && client.Username == username {
+			sessions = append(sessions, client)
+		}
+	}
+	s.mutex.RUnlock()
+
+	s.roomsMutex.Lock()
+	for _, room := range s.rooms {
+		for _, client := range sessions {
+			room.leave(client)
+		}
+	}
+	s.roomsMutex.Unlock()
+
+	// Ждем, пока serveClient завершит клиентов: уходя, они еще обновляют присутствие
+	for _, client := range sessions {
+		s.kickClient(client, "данные пользователя удалены")
+	}
+	for _, client := range sessions {
+		err := client.WaitClosed(s.config.ShutdownTimeout.Duration)
+		if err != nil {
+			slog.Warn("Ошибка отключения клиента при удалении данных", "username", username, "err", err)
+		}
+	}
+}
+
+// runDeletionJob удаляет сообщения и реакции пользователя из базы порциями, сохраняя прогресс в job.
+func runDeletionJob(job DeletionJob) {
+	update := func() {
+		job.UpdatedAt = time.Now().UTC()
+		err := messageStore.UpdateDeletionJob(job)
+		if err != nil {
+			slog.Error("Ошибка сохранения задачи удаления", "job_id", job.ID, "err", err)
+		}
+	}
+	fail := func(err error) {
+		slog.Error("Ошибка удаления данных пользователя", "job_id", job.ID, "username", job.Username, "err", err)
+		job.Status = "failed"
+		job.Error = err.Error()
+		update()
+	}
+
+	job.Status = "running"
+	update()
+	// Реакции удаляются первыми: они находятся по сообщениям арендатора, в том числе собственным
+	err := messageStore.RemoveUserReactions(job.Tenant, job.Username)
+	if err != nil {
+		fail(err)
+		return
+	}
+	err = messageStore.RemoveUserDeviceTokens(job.Tenant, job.Username)
+	if err != nil {
+		fail(err)
+		return
+	}
+	err = messageStore.DeleteUser(job.Tenant, job.Username)
+	if err != nil {
+		fail(err)
+		return
+	}
+	err = messageStore.RemoveUserRoomInvites(job.Tenant, job.Username)
+	if err != nil {
+		fail(err)
+		return
+	}
+	for {
+		n, err := messageStore.DeleteByUsername(job.Tenant, job.Username, deletionBatchSize)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if n == 0 {
+			break
+		}
+		job.DeletedMessages += n
+		update()
+	}
+
+	// Кэш комнат могли заполнить из базы еще не удаленными сообщениями
+	roomCache.Reset()
+	job.Status = "done"
+	update()
+	slog.Info("Данные пользователя удалены", "job_id", job.ID, "username", job.Username, "messages", job.DeletedMessages)
+}
+
+// handleDeletionStatus возвращает последнюю задачу удаления данных пользователя
+// (GET /admin/users/{username}/deletion-status).
+func (s *Server) handleDeletionStatus(w http.ResponseWriter, r *http.Request) {
+	if messageStore == nil {
+		http.Error(w, "хранилище сообщений не настроено", http.StatusNotFound)
+		return
+	}
+	job, ok, err := messageStore.LastDeletionJob(requestTenant(r), r.PathValue("username"))
+	if err != nil {
+		slog.Error("Ошибка чтения задачи удаления", "err", err)
+		http.Error(w, "ошибка чтения задачи удаления", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "deletion job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
@@ -0,0 +1,179 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative chatpb/chat.proto
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"server-7/chatpb"
+)
+
+// chatService реализует сервис Chat (см. chatpb/chat.proto).
+type chatService struct {
+	chatpb.UnimplementedChatServer
+	server *Server
+}
+
+// grpcTransport отправляет сообщения gRPC клиенту в поток Subscribe.
+type grpcTransport struct {
+	stream chatpb.Chat_SubscribeServer
+	// cancel завершает Subscribe при закрытии клиента.
+	cancel context.CancelFunc
+	// mutex сериализует запись: в поток gRPC может писать только одна горутина.
+	mutex sync.Mutex
+}
+
+func (t *grpcTransport) Send(msg Message) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.stream.Send(toProto(msg))
+}
+
+func (t *grpcTransport) Close() error {
+	t.cancel()
+	return nil
+}
+
+// toProto преобразует сообщение в его protobuf представление.
+func toProto(msg Message) *chatpb.Message {
+	pb := &chatpb.Message{
+		Type:        msg.Type,
+		Text:        msg.Text,
+		Username:    msg.Username,
+		MsgId:       msg.MsgID,
+		Sender:      msg.Sender,
+		Room:        msg.Room,
+		Recipient:   msg.Recipient,
+		ParentMsgId: msg.ParentMsgID,
+	}
+	if !msg.SentAt.IsZero() {
+		pb.SentAt = timestamppb.New(msg.SentAt)
+	}
+	return pb
+}
+
+// Send отправляет сообщение в общий чат, как POST /poll/send.
+func (c *chatService) Send(ctx context.Context, req *chatpb.SendRequest) (*chatpb.SendResponse, error) {
+	username, tenant, err := grpcUser(ctx, c.server.config.JWTSecret, req.Username)
+	if err != nil {
+		return nil, err
+	}
+	if username == "" {
+		return nil, status.Error(codes.InvalidArgument, "не указано имя пользователя")
+	}
+	if _, muted := mutedUntil(tenant, username); muted {
+		return nil, status.Error(codes.PermissionDenied, "администратор запретил вам писать в чат")
+	}
+	if !c.server.senderLimits.wait(tenant, username) {
+		return nil, status.Error(codes.ResourceExhausted, "превышен лимит сообщений")
+	}
+	if len(req.Text) > c.server.config.MaxMessageBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "сообщение слишком длинное: %d байт при лимите %d", len(req.Text), c.server.config.MaxMessageBytes)
+	}
+	if _, found := wordFilter.Match(req.Text); found {
+		return nil, status.Error(codes.InvalidArgument, "сообщение содержит запрещенные слова и не отправлено")
+	}
+	if req.ParentMsgId != "" && !c.server.messageExists(tenant, req.ParentMsgId) {
+		return nil, status.Error(codes.NotFound, "сообщение не найдено: "+req.ParentMsgId)
+	}
+
+	msg := Message{
+		Type:        "message",
+		Text:        req.Text,
+		Username:    username,
+		MsgID:       newMsgID(),
+		Sender:      "grpc:" + peerAddr(ctx),
+		ParentMsgID: req.ParentMsgId,
+		Tenant:      tenant,
+		protocol:    "grpc",
+	}
+	err = c.server.Broadcast(msg)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &chatpb.SendResponse{MsgId: msg.MsgID}, nil
+}
+
+// Subscribe передает клиенту общую рассылку. Подписчик — обычный клиент в карте clients
+// с протоколом "grpc", поэтому получает те же сообщения, что и клиенты WebSocket и TCP.
+func (c *chatService) Subscribe(req *chatpb.SubscribeRequest, stream chatpb.Chat_SubscribeServer) error {
+	remoteAddr := peerAddr(stream.Context())
+	if blocklist.Blocked(remoteAddr) {
+		slog.Warn("Отказ в gRPC подключении: адрес в списке блокировки", "remote_addr", remoteAddr)
+		return status.Error(codes.PermissionDenied, "адрес заблокирован")
+	}
+	username, tenant, err := grpcUser(stream.Context(), c.server.config.JWTSecret, req.Username)
+	if err != nil {
+		return err
+	}
+	if !acquireConnSlot() {
+		slog.Warn("Отказ в gRPC подключении: достигнут лимит подключений", "remote_addr", remoteAddr, "max_connections", cap(connSlots))
+		return status.Error(codes.ResourceExhausted, "сервер перегружен, попробуйте позже")
+	}
+	defer releaseConnSlot()
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	client := c.server.newClient("grpc", remoteAddr, &grpcTransport{stream: stream, cancel: cancel})
+	client.Tenant = tenant
+	if username != "" {
+		err = c.server.claimUsername(client, username)
+		if err != nil {
+			return status.Error(codes.AlreadyExists, err.Error())
+		}
+	}
+
+	// Поток односторонний: клиент ничего не присылает, а отключается закрытием потока
+	c.server.serveClient(client, func() (Message, error) {
+		select {
+		case <-ctx.Done():
+		case <-c.server.stopping:
+		}
+		return Message{}, io.EOF
+	})
+	return nil
+}
+
+// grpcUser возвращает имя и арендатора клиента: из JWT в метаданных authorization, если
+// аутентификация включена (secret не пуст), иначе — переданное клиентом имя и арендатора
+// из метаданных x-tenant-id.
+func grpcUser(ctx context.Context, secret, username string) (string, string, error) {
+	if secret == "" {
+		var tenant string
+		if values := metadata.ValueFromIncomingContext(ctx, strings.ToLower(tenantHeader)); len(values) > 0 {
+			tenant = strings.TrimSpace(values[0])
+		}
+		return strings.TrimSpace(username), tenant, nil
+	}
+
+	var token string
+	if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+		token, _ = strings.CutPrefix(values[0], "Bearer ")
+	}
+	claims, err := parseJWTClaims(secret, strings.TrimSpace(token))
+	if err != nil {
+		slog.Warn("Отказ в gRPC запросе: ошибка аутентификации", "remote_addr", peerAddr(ctx), "err", err)
+		auditLog.Record(auditLoginFailed, "", "", peerAddr(ctx), "method", "grpc", "err", err)
+		return "", "", status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	auditLog.Record(auditLogin, claims.Subject, "", peerAddr(ctx), "method", "grpc", "tenant", claims.Tenant)
+	return claims.Subject, claims.Tenant, nil
+}
+
+// peerAddr возвращает адрес gRPC клиента.
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"time"
+)
+
+// pinger — транспорт, умеющий проверять живость соединения на своем уровне
+// (для WebSocket — управляющими кадрами ping/pong).
+type pinger interface {
+	Ping(timeout time.Duration) error
+}
+
+// heartbeat периодически отправляет клиенту ping и ждет от него ответа
+// (pong или любого другого сообщения) в течение timeout. Если клиент молчит,
+// соединение закрывается, и serveClient удаляет клиента.
+//
+// WebSocket клиенты получают управляющий кадр ping, на который браузер отвечает сам.
+// Остальным отправляется сообщение type:"ping", на которое клиент отвечает type:"pong".
+func (c *Client) heartbeat(interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		pingAt := time.Now()
+		var err error
+		if p, ok := c.conn.(pinger); ok {
+			err = p.Ping(timeout)
+		} else {
+			err = c.Send(Message{Type: "ping"})
+		}
+		if err != nil {
+			c.logger().Warn("Ошибка отправки ping", "err", err)
+			c.Close()
+			return
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(timeout):
+		}
+
+		if c.lastSeen().Before(pingAt) {
+			c.logger().Warn("Клиент не ответил на ping, соединение закрыто", "timeout", timeout)
+			c.Close()
+			return
+		}
+	}
+}
+
+// touch отмечает, что от клиента пришло сообщение.
+func (c *Client) touch() {
+	c.lastSeenAt.Store(time.Now().UnixNano())
+}
+
+// active отмечает, что клиент прислал сообщение протокола (в отличие от touch,
+// управляющие кадры pong активностью не считаются).
+func (c *Client) active() {
+	c.lastActiveAt.Store(time.Now().UnixNano())
+}
+
+// lastActive возвращает время последнего сообщения протокола от клиента.
+func (c *Client) lastActive() time.Time {
+	return time.Unix(0, c.lastActiveAt.Load())
+}
+
+// lastSeen возвращает время последнего сообщения от клиента.
+func (c *Client) lastSeen() time.Time {
+	return time.Unix(0, c.lastSeenAt.Load())
+}
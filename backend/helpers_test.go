@@ -0,0 +1,271 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testTimeout — сколько тесты ждут сообщения или отключения клиента.
+const testTimeout = 2 * time.Second
+
+// testConfig возвращает настройки по умолчанию. Подтверждения доставки в них выключены:
+// иначе каждому клиенту понадобился бы ответ type:"ack".
+func testConfig() Config {
+	return defaultConfig()
+}
+
+// newTestServer создает сервер с запущенной рассылкой, которая останавливается в конце теста.
+// Соединения протоколов не открываются. В конце теста ждет горутины клиентов: иначе их writeLoop
+// мог бы прочитать общие для процесса переменные, которые подменяет следующий тест.
+func newTestServer(t testing.TB, cfg Config) *Server {
+	t.Helper()
+	s := NewServer(cfg)
+	go s.handleMessages()
+	t.Cleanup(func() {
+		s.closeBroadcast()
+		<-s.messagesDone
+		s.clientsWG.Wait()
+	})
+	return s
+}
+
+// newWSTestServer запускает сервер с настройками cfg на httptest.Server и возвращает его
+// вместе с адресом WebSocket. В конце теста ждет, пока serveClient завершит обработку
+// каждого клиента (Client.WaitClosed): соединения к этому моменту должны быть закрыты (см. connectAndCleanup).
+func newWSTestServer(t *testing.T, cfg Config) (*Server, string) {
+	t.Helper()
+	s := newTestServer(t, cfg)
+	ts := httptest.NewServer(s.routes())
+	t.Cleanup(func() {
+		s.mutex.RLock()
+		clients := make([]*Client, 0, len(s.clients))
+		for client := range s.clients {
+			clients = append(clients, client)
+		}
+		s.mutex.RUnlock()
+		for _, client := range clients {
+			err := client.WaitClosed(testTimeout)
+			if err != nil {
+				t.Error(err)
+				client.Close()
+			}
+		}
+		s.clientsWG.Wait()
+		ts.Close()
+	})
+	return s, "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+}
+
+// TestClient — WebSocket клиент теста. Методы завершают тест при ошибке, поэтому
+// вызывать их можно только из горутины теста.
+type TestClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+	once sync.Once
+}
+
+// connectAndCleanup подключает WebSocket клиента к url и закрывает соединение в конце теста.
+// Так каждый тест сам закрывает свои соединения: сервер замечает закрытие и завершает
+// горутины клиента, newWSTestServer дожидается этого, а goleak.VerifyTestMain в TestMain
+// находит горутины, которые все-таки остались.
+func connectAndCleanup(t *testing.T, url string) *TestClient {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	client := &TestClient{t: t, conn: conn}
+	t.Cleanup(client.Close)
+	return client
+}
+
+// Send отправляет сообщение серверу.
+func (c *TestClient) Send(msg Message) {
+	c.t.Helper()
+	err := c.conn.WriteJSON(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// Receive возвращает следующее сообщение от сервера.
+func (c *TestClient) Receive() Message {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(testTimeout))
+	var msg Message
+	err := c.conn.ReadJSON(&msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+// ReceiveType пропускает сообщения от сервера до первого сообщения типа typ.
+func (c *TestClient) ReceiveType(typ string) Message {
+	c.t.Helper()
+	for {
+		msg := c.Receive()
+		if msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+// Register регистрирует клиента под именем username и ждет подтверждения.
+func (c *TestClient) Register(username string) {
+	c.t.Helper()
+	c.Send(Message{Type: "register", Username: username})
+	msg := c.ReceiveType("registered")
+	if msg.Username != username {
+		c.t.Fatalf("зарегистрировано имя %q, ожидалось %q", msg.Username, username)
+	}
+}
+
+// Close закрывает соединение кадром close. Повторные вызовы ничего не делают.
+func (c *TestClient) Close() {
+	c.once.Do(func() {
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		c.conn.Close()
+	})
+}
+
+// fakeClient — соединение клиента в памяти, реализующее Sender: запоминает отправленные
+// сервером сообщения, а если задан sendErr — возвращает его вместо отправки.
+type fakeClient struct {
+	sendErr error
+	// errs — ошибки первых отправок по порядку, после них действует sendErr.
+	errs  []error
+	sends int
+	mutex sync.Mutex
+	sent  chan Message
+	// incoming — сообщения клиента серверу, их возвращает receive.
+	incoming chan Message
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newFakeClient(sendErr error) *fakeClient {
+	return &fakeClient{sendErr: sendErr, sent: make(chan Message, 4096), incoming: make(chan Message), closed: make(chan struct{})}
+}
+
+func (f *fakeClient) Send(msg Message) error {
+	f.mutex.Lock()
+	f.sends++
+	err := f.sendErr
+	if len(f.errs) > 0 {
+		err, f.errs = f.errs[0], f.errs[1:]
+	}
+	f.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	select {
+	case f.sent <- msg:
+	case <-f.closed:
+		return io.EOF
+	}
+	return nil
+}
+
+// attempts возвращает, сколько раз вызывалась Send.
+func (f *fakeClient) attempts() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.sends
+}
+
+func (f *fakeClient) Close() error {
+	f.once.Do(func() { close(f.closed) })
+	return nil
+}
+
+// receive — функция чтения для serveClient: возвращает сообщения incoming, пока соединение не закроют.
+func (f *fakeClient) receive() (Message, error) {
+	select {
+	case msg := <-f.incoming:
+		return msg, nil
+	case <-f.closed:
+		return Message{}, io.EOF
+	}
+}
+
+// next возвращает следующее отправленное клиенту сообщение.
+func (f *fakeClient) next(t testing.TB) Message {
+	t.Helper()
+	select {
+	case msg := <-f.sent:
+		return msg
+	case <-time.After(testTimeout):
+		t.Fatal("клиент не получил сообщение")
+		return Message{}
+	}
+}
+
+// texts возвращает тексты сообщений type:"message", полученных клиентом до сообщения с текстом last включительно.
+func (f *fakeClient) texts(t testing.TB, last string) []string {
+	t.Helper()
+	var texts []string
+	for {
+		msg := f.next(t)
+		if msg.Type != "message" {
+			continue
+		}
+		texts = append(texts, msg.Text)
+		if msg.Text == last {
+			return texts
+		}
+	}
+}
+
+// connectFake подключает к s клиента username поверх fakeClient и отключает его в конце теста.
+// Возвращается, когда клиент добавлен в список клиентов сервера (или уже отключен из-за sendErr).
+func connectFake(t testing.TB, s *Server, username string, sendErr error) (*Client, *fakeClient) {
+	t.Helper()
+	conn := newFakeClient(sendErr)
+	client := s.newClient("ws", "fake:"+username, conn)
+	err := s.claimUsername(client, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.serveClient(client, conn.receive)
+	t.Cleanup(func() {
+		conn.Close()
+		err := client.WaitClosed(testTimeout)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	deadline := time.Now().Add(testTimeout)
+	for !s.hasClient(client) && !closed(client.done) {
+		if time.Now().After(deadline) {
+			t.Fatalf("клиент %s не подключился", username)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return client, conn
+}
+
+// hasClient сообщает, есть ли клиент в списке клиентов сервера.
+func (s *Server) hasClient(client *Client) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.clients[client]
+}
+
+// closed сообщает, закрыт ли канал done.
+func closed(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// History хранит последние сообщения общего чата в кольцевом буфере.
+// Буфер общий для всех арендаторов; читающие отбирают сообщения своего (см. inTenant).
+type History struct {
+	messages []Message
+	// next — позиция для следующей записи.
+	next int
+	// full — буфер заполнен хотя бы один раз.
+	full  bool
+	mutex sync.RWMutex
+}
+
+// newHistory создает историю на size сообщений.
+func newHistory(size int) *History {
+	return &History{messages: make([]Message, size)}
+}
+
+// Add добавляет сообщение, вытесняя самое старое при заполнении буфера.
+func (h *History) Add(msg Message) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.messages[h.next] = msg
+	h.next = (h.next + 1) % len(h.messages)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Messages возвращает копию истории в хронологическом порядке.
+func (h *History) Messages() []Message {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	if !h.full {
+		return append([]Message(nil), h.messages[:h.next]...)
+	}
+	result := make([]Message, 0, len(h.messages))
+	result = append(result, h.messages[h.next:]...)
+	return append(result, h.messages[:h.next]...)
+}
+
+// RemoveUser удаляет из истории все сообщения пользователя username арендатора tenant.
+func (h *History) RemoveUser(tenant, username string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var kept []Message
+	if h.full {
+		kept = append(kept, h.messages[h.next:]...)
+	}
+	kept = append(kept, h.messages[:h.next]...)
+	kept = slices.DeleteFunc(kept, func(msg Message) bool { return msg.Tenant == tenant && msg.Username == username })
+
+	clear(h.messages)
+	h.next = copy(h.messages, kept) % len(h.messages)
+	h.full = len(kept) == len(h.messages)
+}
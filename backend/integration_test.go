@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// listenTCP принимает TCP соединения для s на свободном порту и возвращает его адрес.
+// В конце теста перестает принимать соединения и ждет завершения их обработки.
+func listenTCP(t *testing.T, s *Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.handleTCPConnection(conn)
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		wg.Wait()
+	})
+	return ln.Addr().String()
+}
+
+// dialTCP подключается к TCP серверу и закрывает соединение в конце теста.
+func dialTCP(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// sendTCP отправляет сообщение кадром TCP протокола.
+func sendTCP(t *testing.T, conn net.Conn, msg Message) {
+	t.Helper()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeFrame(conn, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receiveTCP пропускает кадры от сервера до первого сообщения типа typ.
+func receiveTCP(t *testing.T, conn net.Conn, typ string) Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	for {
+		data, err := readFrame(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg Message
+		err = json.Unmarshal(data, &msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+func TestWebSocketDisconnect(t *testing.T) {
+	t.Parallel()
+	s, url := newWSTestServer(t, testConfig())
+	client := connectAndCleanup(t, url)
+	client.Register("alice")
+	if got := s.ConnectedClients(); got != 1 {
+		t.Fatalf("подключено клиентов: %d, ожидался 1", got)
+	}
+
+	client.Close()
+	deadline := time.Now().Add(testTimeout)
+	for s.ConnectedClients() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("клиент не удален после закрытия соединения")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWebSocketToWebSocket(t *testing.T) {
+	t.Parallel()
+	_, url := newWSTestServer(t, testConfig())
+	alice := connectAndCleanup(t, url)
+	alice.Register("alice")
+	bob := connectAndCleanup(t, url)
+	bob.Register("bob")
+
+	alice.Send(Message{Type: "message", Text: "привет, bob"})
+	msg := bob.ReceiveType("message")
+	if msg.Text != "привет, bob" || msg.Username != "alice" {
+		t.Errorf("bob получил %q от %q, ожидалось %q от alice", msg.Text, msg.Username, "привет, bob")
+	}
+}
+
+func TestTCPToWebSocket(t *testing.T) {
+	t.Parallel()
+	cfg := testConfig()
+	cfg.EnableBridge = true
+	s, url := newWSTestServer(t, cfg)
+	addr := listenTCP(t, s)
+	ws := connectAndCleanup(t, url)
+	ws.Register("alice")
+	tcp := dialTCP(t, addr)
+	sendTCP(t, tcp, Message{Type: "register", Username: "bob"})
+	receiveTCP(t, tcp, "registered")
+
+	sendTCP(t, tcp, Message{Type: "message", Text: "привет по TCP"})
+	msg := ws.ReceiveType("message")
+	if msg.Text != "привет по TCP" || msg.Username != "bob" {
+		t.Errorf("alice получила %q от %q, ожидалось %q от bob", msg.Text, msg.Username, "привет по TCP")
+	}
+	// И обратно: TCP клиент получает сообщения WebSocket клиентов
+	ws.Send(Message{Type: "message", Text: "привет по WebSocket"})
+	for {
+		msg := receiveTCP(t, tcp, "message")
+		if msg.Username == "alice" {
+			if msg.Text != "привет по WebSocket" {
+				t.Errorf("bob получил %q, ожидалось %q", msg.Text, "привет по WebSocket")
+			}
+			break
+		}
+	}
+}
+
+func TestBridgeDisabled(t *testing.T) {
+	t.Parallel()
+	s, url := newWSTestServer(t, testConfig())
+	addr := listenTCP(t, s)
+	alice := connectAndCleanup(t, url)
+	alice.Register("alice")
+	carol := connectAndCleanup(t, url)
+	carol.Register("carol")
+	tcp := dialTCP(t, addr)
+	sendTCP(t, tcp, Message{Type: "register", Username: "bob"})
+	receiveTCP(t, tcp, "registered")
+
+	// Без моста сообщение TCP клиента доходит только до клиентов TCP
+	sendTCP(t, tcp, Message{Type: "message", Text: "привет по TCP"})
+	if msg := receiveTCP(t, tcp, "message"); msg.Source != "tcp" {
+		t.Errorf("Source = %q, ожидалось %q", msg.Source, "tcp")
+	}
+	carol.Send(Message{Type: "message", Text: "привет по WebSocket"})
+	if msg := alice.ReceiveType("message"); msg.Username != "carol" {
+		t.Errorf("alice получила %q от %q, ожидалось сообщение carol", msg.Text, msg.Username)
+	}
+	// И обратно: сообщение carol, разосланное раньше, до bob не дошло
+	sendTCP(t, tcp, Message{Type: "message", Text: "еще раз по TCP"})
+	if msg := receiveTCP(t, tcp, "message"); msg.Username != "bob" {
+		t.Errorf("bob получил %q от %q, ожидалось свое сообщение", msg.Text, msg.Username)
+	}
+}
+
+func TestDisconnectMidSend(t *testing.T) {
+	t.Parallel()
+	_, url := newWSTestServer(t, testConfig())
+	bob := connectAndCleanup(t, url)
+	bob.Register("bob")
+
+	// alice отправляет сообщения и сразу обрывает соединение, не читая ответов и без кадра close
+	alice := connectAndCleanup(t, url)
+	alice.Register("alice")
+	for range 5 {
+		alice.Send(Message{Type: "message", Text: "до обрыва"})
+	}
+	alice.conn.Close()
+
+	// Сервер продолжает работать: bob получает сообщения alice и свои
+	bob.ReceiveType("message")
+	bob.Send(Message{Type: "message", Text: "после обрыва"})
+	for {
+		msg := bob.ReceiveType("message")
+		if msg.Text == "после обрыва" {
+			break
+		}
+	}
+}
+
+// TestMaxConnections не параллельный: он занимает места общего для процесса семафора connSlots.
+func TestMaxConnections(t *testing.T) {
+	// Свободным остается одно место
+	busy := cap(connSlots) - 1
+	for range busy {
+		connSlots <- struct{}{}
+	}
+	t.Cleanup(func() {
+		for range busy {
+			<-connSlots
+		}
+	})
+
+	s, url := newWSTestServer(t, testConfig())
+	addr := listenTCP(t, s)
+	alice := connectAndCleanup(t, url)
+	alice.Register("alice")
+
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		conn.Close()
+		t.Fatal("подключение сверх лимита принято")
+	}
+	if !errors.Is(err, websocket.ErrBadHandshake) || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("подключение сверх лимита: %v, ожидался ответ %d", err, http.StatusServiceUnavailable)
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	tcp := dialTCP(t, addr)
+	msg := receiveTCP(t, tcp, "error")
+	if msg.Text != "сервер перегружен, попробуйте позже" {
+		t.Errorf("TCP клиент сверх лимита получил %q", msg.Text)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RoomInvite — приглашение пользователя в комнату только по приглашениям.
+type RoomInvite struct {
+	Tenant   string `json:"tenant,omitempty"`
+	Room     string `json:"room"`
+	Username string `json:"username"`
+	// InvitedBy — владелец или модератор, пригласивший пользователя.
+	InvitedBy string    `json:"invited_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// loadInvites заполняет приглашения новой комнаты из базы, чтобы они пережили перезапуск сервера.
+func (r *Room) loadInvites() {
+	if messageStore == nil {
+		return
+	}
+	invites, err := messageStore.RoomInvites(r.Tenant, r.Name)
+	if err != nil {
+		slog.Error("Ошибка загрузки приглашений в комнату", "room", r.Name, "tenant", r.Tenant, "err", err)
+		return
+	}
+	for _, invite := range invites {
+		r.invited[invite.Username] = invite
+	}
+}
+
+// canJoin сообщает, может ли пользователь username войти в комнату: в комнату только по
+// приглашениям входят приглашенные, а также владелец и модераторы.
+func (r *Room) canJoin(username string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.Invite {
+		return true
+	}
+	if _, ok := r.invited[username]; ok {
+		return true
+	}
+	return r.Roles[username].rank() > roleMember.rank()
+}
+
+// invites возвращает приглашения в комнату в порядке их выдачи.
+func (r *Room) invites() []RoomInvite {
+	r.mutex.Lock()
+	invites := make([]RoomInvite, 0, len(r.invited))
+	for _, invite := range r.invited {
+		invites = append(invites, invite)
+	}
+	r.mutex.Unlock()
+
+	slices.SortFunc(invites, func(a, b RoomInvite) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return invites
+}
+
+// commandInvite приглашает пользователя в комнату и сообщает ему об этом type:"invite":
+// /invite <имя> <комната>. Доступна владельцу и модераторам комнаты.
+func commandInvite(client *Client, args []string) error {
+	if len(args) != 2 {
+		return errors.New("использование: /invite <имя> <комната>")
+	}
+	username := args[0]
+	room, err := commandRoom(client, args[1], roleModerator)
+	if err != nil {
+		return err
+	}
+
+	invite := RoomInvite{
+		Tenant:    client.Tenant,
+		Room:      room.Name,
+		Username:  username,
+		InvitedBy: client.Username,
+		CreatedAt: time.Now().UTC(),
+	}
+	if messageStore != nil {
+		err = messageStore.AddRoomInvite(invite)
+		if err != nil {
+			client.logger().Error("Ошибка сохранения приглашения в комнату", "room", room.Name, "username", username, "err", err)
+			return errors.New("не удалось сохранить приглашение")
+		}
+	}
+	room.mutex.Lock()
+	room.invited[username] = invite
+	room.mutex.Unlock()
+
+	s := client.server
+	s.mutex.RLock()
+	invitee := s.clientsByName[tenantName{client.Tenant, username}]
+	s.mutex.RUnlock()
+	if invitee != nil {
+		invitee.deliver(Message{
+			Type:     "invite",
+			Room:     room.Name,
+			Username: client.Username,
+			Text:     client.Username + " приглашает вас в комнату " + room.Name,
+		})
+	}
+
+	client.logger().Info("Пользователь приглашен в комнату", "room", room.Name, "username", username)
+	return reply(client, "пользователь "+username+" приглашен в комнату "+room.Name)
+}
+
+// commandInviteOnly включает и выключает вход по приглашениям: /inviteonly <комната> on|off.
+// Участники, уже находящиеся в комнате, в ней остаются.
+func commandInviteOnly(client *Client, room *Room, args []string) error {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return errors.New("использование: /inviteonly <комната> on|off")
+	}
+
+	inviteOnly := args[0] == "on"
+	room.mutex.Lock()
+	room.Invite = inviteOnly
+	room.mutex.Unlock()
+	client.logger().Info("Изменен режим входа в комнату", "room", room.Name, "invite_only", inviteOnly)
+	if inviteOnly {
+		return reply(client, "в комнату "+room.Name+" теперь входят только по приглашениям")
+	}
+	return reply(client, "в комнату "+room.Name+" теперь может войти любой")
+}
+
+// handleRoomInvites возвращает приглашения в комнату арендатора из X-Tenant-ID
+// (GET /rooms/{name}/invites). Приглашения в комнату, которой сейчас нет в памяти, читаются из базы.
+func (s *Server) handleRoomInvites(w http.ResponseWriter, r *http.Request) {
+	name, tenant := strings.TrimSpace(r.PathValue("name")), requestTenant(r)
+	if room := s.findRoom(tenant, name); room != nil {
+		writeJSON(w, http.StatusOK, room.invites())
+		return
+	}
+	if messageStore == nil {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return
+	}
+	invites, err := messageStore.RoomInvites(tenant, name)
+	if err != nil {
+		slog.Error("Ошибка чтения приглашений в комнату", "room", name, "err", err)
+		http.Error(w, "ошибка чтения приглашений", http.StatusInternalServerError)
+		return
+	}
+	if invites == nil {
+		invites = []RoomInvite{}
+	}
+	writeJSON(w, http.StatusOK, invites)
+}
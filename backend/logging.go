@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// setupLogger настраивает журнал slog по умолчанию: format "text" (по умолчанию) или "json".
+// Вызовы пакета log тоже попадают в этот обработчик.
+func setupLogger(format string) error {
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, nil)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, nil)
+	default:
+		return fmt.Errorf("неизвестный формат журнала %q: ожидается \"text\" или \"json\"", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// fatal записывает ошибку в журнал и завершает процесс.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// logger возвращает журнал с атрибутами клиента.
+func (c *Client) logger() *slog.Logger {
+	return slog.With("client_id", c.ID, "remote_addr", c.RemoteAddr)
+}
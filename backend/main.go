@@ -1,161 +1,809 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"log"
-	"net"
+	"log/slog"
 	"net/http"
-	"sync"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"golang.org/x/net/websocket"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Client представляет клиента WebSocket.
-type Client struct {
-	conn *websocket.Conn
-	// Дополнительные поля, если нужны (например, имя пользователя)
-}
-
 // Message представляет сообщение чата.
 type Message struct {
+	// Type — тип сообщения протокола: "register", "registered", "join", "joined", "leave", "left",
+	// "message" (сообщение чата), "system" (вход и выход пользователей), "history", "ping", "pong", "ack", "error", "rate_limit", "kicked", "server_shutdown"
+	// "scheduled" (подтверждение отложенного сообщения), "typing" (пользователь набирает текст)
+	// "read" (клиент прочитал сообщение MsgID), "status" (клиент отошел или вернулся)
+	// "presence" (пользователь в сети, отошел или вышел), "reaction" (реакция на сообщение),
+	// "reaction_update" (все реакции на сообщение после изменения), "role_change" (роль пользователя в комнате изменена),
+	// "invite" (приглашение в комнату от пользователя Username), "room_update" (изменились тема или описание комнаты),
+	// "subscribe" и "unsubscribe" (подписка на темы Topic и отказ от нее), "subscribed" и "unsubscribed" (подтверждения)
+	// или "duplicate" (сообщение с этим MsgID уже получено и повторно не рассылается).
+	Type string `json:"type,omitempty"`
 	Text string `json:"text"`
-	// Дополнительные поля, если нужны (например, отправитель, время)
+	// Username — имя отправителя (для "register" — запрашиваемое имя).
+	Username string `json:"username,omitempty"`
+	// MsgID — уникальный ID сообщения (UUID). Клиент может задать его сам, чтобы сервер узнал
+	// повторную отправку того же сообщения; иначе ID присваивает сервер. Используется в подтверждениях type:"ack".
+	MsgID string `json:"msg_id,omitempty"`
+	// Sender идентифицирует отправителя. Заполняется сервером, значение от клиента игнорируется.
+	Sender string `json:"sender"`
+	// SeqNum — номер сообщения среди сообщений отправителя Sender в то же место назначения (общий чат,
+	// комнату Room, тему Topic или получателю Recipient), растет на единицу с каждым таким сообщением.
+	// Проставляется сервером; по пропускам получатель видит, что сообщения пришли не по порядку.
+	SeqNum uint64 `json:"seq_num,omitempty"`
+	// Room — комната, к которой относится сообщение. Пустое значение означает общий чат.
+	Room string `json:"room,omitempty"`
+	// Topic — тема сообщения вида "sensors/room1/temperature": такое сообщение получают только
+	// подписчики темы (см. topicTrie). Для "subscribe" — фильтр с шаблонами "+" и "#".
+	Topic string `json:"topic,omitempty"`
+	// History — последние сообщения чата (только для type:"history").
+	History []Message `json:"history,omitempty"`
+	// Recipient — имя получателя личного сообщения. Пустое значение означает рассылку всем.
+	Recipient string `json:"recipient,omitempty"`
+	// SentAt — время получения сообщения сервером (в JSON сериализуется в формате RFC3339).
+	SentAt time.Time `json:"sent_at"`
+	// TraceID — контекст трассировки OpenTelemetry в формате W3C traceparent.
+	// Связывает спан приема сообщения со спанами его рассылки.
+	TraceID string `json:"trace_id,omitempty"`
+	// Status — статус присутствия пользователя (для "presence"): "online", "away" или "offline".
+	Status string `json:"status,omitempty"`
+	// Password — пароль комнаты (только для "join"): открывает комнату с паролем или задает пароль новой комнаты.
+	Password string `json:"password,omitempty"`
+	// Token — JWT клиента TCP (только для "register", если аутентификация включена): имя и арендатор
+	// берутся из полей sub и tenant токена, а не из Username.
+	Token string `json:"token,omitempty"`
+	// ResumeToken — токен восстановления сессии (для "registered"): клиент передает его
+	// в параметре resume_token при переподключении по WebSocket, чтобы вернуть ID, имя и комнаты.
+	ResumeToken string `json:"resume_token,omitempty"`
+	// Code — машиночитаемая причина ошибки (для "error"), например "wrong_password".
+	Code string `json:"code,omitempty"`
+	// RoomInfo — тема, описание и другие свойства комнаты Room (для "room_update").
+	RoomInfo *roomInfo `json:"room_info,omitempty"`
+	// MutedUntil — когда снимется запрет писать (для "error" с Code "muted" или "globally_muted";
+	// нет у бессрочного запрета).
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+	// Role — роль пользователя Username в комнате (для "joined" и "role_change"): "owner", "moderator" или "member".
+	Role string `json:"role,omitempty"`
+	// Emoji и Action — реакция и действие с ней ("add" или "remove") для "reaction".
+	Emoji  string `json:"emoji,omitempty"`
+	Action string `json:"action,omitempty"`
+	// Reactions — реакции на сообщение MsgID (для "reaction_update"): эмодзи → имена пользователей.
+	Reactions map[string][]string `json:"reactions,omitempty"`
+	// Attachments — файлы, приложенные к сообщению (только описания, см. AttachmentMeta).
+	Attachments []AttachmentMeta `json:"attachments,omitempty"`
+	// Priority — приоритет рассылки: 0 — обычное сообщение, 1 — важное (только от администраторов),
+	// 2 — системное. Сообщения с большим приоритетом рассылаются первыми (см. messageQueue).
+	Priority uint8 `json:"priority,omitempty"`
+	// ParentMsgID — MsgID сообщения, на которое это сообщение отвечает (ветка обсуждения).
+	// Пустое значение у сообщений верхнего уровня.
+	ParentMsgID string `json:"parent_msg_id,omitempty"`
+	// Tenant — арендатор, которому принадлежит сообщение; пустое значение — арендатор по умолчанию.
+	// Проставляется сервером: сообщение видят только клиенты того же арендатора.
+	Tenant string `json:"tenant,omitempty"`
+	// DeliverAt — время отложенной доставки. Пустое значение или время в прошлом — отправить сразу.
+	DeliverAt *time.Time `json:"deliver_at,omitempty"`
+	// Source — протокол клиента-отправителя, "ws" или "tcp", для моста между ними (см. Bridge).
+	// Проставляется сервером; пустое значение у сообщений других протоколов и самого сервера.
+	Source string `json:"source,omitempty"`
+	// remote — сообщение получено от другого экземпляра сервера через Redis и не публикуется повторно.
+	remote bool
+	// protocol — протокол, по которому сообщение пришло от клиента ("ws", "tcp", "grpc", "poll",
+	// "mqtt"); пустое значение у сообщений самого сервера. Нужен только для метрик.
+	protocol string
+	// enqueuedAt — когда сообщение попало в очередь broadcast.
+	enqueuedAt time.Time
+	// fanout отслеживает доставку сообщения рассылки получателям (chat_broadcast_latency_seconds).
+	fanout *fanoutTracker
 }
 
-var (
-	// clients хранит список всех подключенных WebSocket клиентов.
-	clients = make(map[*Client]bool)
-	// broadcast используется для отправки сообщений всем клиентам.
-	broadcast = make(chan Message)
-	// mutex для безопасного доступа к карте clients.
-	mutex = &sync.Mutex{}
-)
-
 func main() {
-	// Запуск обработчика сообщений в отдельной горутине
-	go handleMessages()
+	startTime = time.Now()
+	configPath := flag.String("config", "config.json", "путь к файлу настроек (JSON)")
+	logFormat := flag.String("log-format", "text", "формат журнала: text или json")
+	flag.Parse()
+
+	err := setupLogger(*logFormat)
+	if err != nil {
+		fatal("Ошибка настройки журнала", err)
+	}
 
-	// Настройка обработчика WebSocket
-	http.Handle("/ws", websocket.Handler(handleWebSocket))
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		fatal("Ошибка настроек", err)
+	}
 
-	// Запуск HTTP сервера (для WebSockets)
-	go func() {
-		fmt.Println("WebSocket сервер запущен на :8080")
-		err := http.ListenAndServe(":8080", nil)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		fatal("Ошибка настройки трассировки", err)
+	}
+
+	roomCache = newRoomCache(config.RoomCacheSize)
+	connSlots = make(chan struct{}, config.MaxConnections)
+
+	// Журнал аудита, если задан файл; по SIGUSR1 файл открывается заново
+	if config.AuditLogFile != "" {
+		auditLog, err = openAuditLog(config.AuditLogFile)
 		if err != nil {
-			log.Fatal("ListenAndServe (WebSocket): ", err)
+			fatal("Ошибка открытия журнала аудита", err)
 		}
-	}()
+		defer auditLog.Close()
+		go reopenAuditLogOnSignal(ctx)
+		slog.Info("События аудита записываются в журнал", "file", config.AuditLogFile)
+	}
 
-	// Запуск TCP сервера
-	go func() {
-		fmt.Println("TCP сервер запущен на :8081")
-		listener, err := net.Listen("tcp", ":8081")
+	// Очередь недоставленных сообщений, если задан файл
+	if config.DeadLetterFile != "" {
+		deadLetters, err = openDeadLetterQueue(config.DeadLetterFile)
 		if err != nil {
-			log.Fatal("Listen (TCP): ", err)
+			fatal("Ошибка открытия очереди недоставленных сообщений", err)
 		}
-		defer listener.Close()
+		defer deadLetters.Close()
+		slog.Info("Недоставленные сообщения записываются в файл", "file", config.DeadLetterFile)
+	}
 
-		for {
-			// Принимаем входящие соединения
-			conn, err := listener.Accept()
-			if err != nil {
-				log.Println("Error accepting TCP connection:", err)
-				continue
-			}
-			// Обрабатываем соединение в отдельной горутине
-			go handleTCPConnection(conn)
+	// Фильтр запрещенных слов, если задан файл
+	if config.BannedWordsFile != "" {
+		wordFilter, err = loadWordFilter(config.BannedWordsFile)
+		if err != nil {
+			fatal("Ошибка загрузки запрещенных слов", err)
 		}
-	}()
+		slog.Info("Загружены запрещенные слова", "count", len(wordFilter.words))
+	}
+
+	blocklist, err = loadBlocklist(config.BlocklistFile)
+	if err != nil {
+		fatal("Ошибка загрузки списка блокировки", err)
+	}
+
+	// API ключи программных клиентов; по SIGHUP файл перечитывается
+	if config.APIKeysFile != "" {
+		apiKeys, err = loadAPIKeys(config.APIKeysFile)
+		if err != nil {
+			fatal("Ошибка загрузки API ключей", err)
+		}
+		go reloadAPIKeysOnHangup(ctx)
+		slog.Info("Загружены API ключи", "file", config.APIKeysFile, "count", apiKeys.Len())
+	}
+
+	// Хранение сообщений в PostgreSQL или, если он не задан, в SQLite
+	switch {
+	case config.PostgresDSN != "":
+		messageStore, err = openPostgres(config.PostgresDSN)
+		if err != nil {
+			fatal("Ошибка подключения к PostgreSQL", err)
+		}
+		slog.Info("Сообщения сохраняются в PostgreSQL")
+	case config.SQLiteFile != "":
+		messageStore, err = openSQLite(config.SQLiteFile)
+		if err != nil {
+			fatal("Ошибка открытия базы SQLite", err)
+		}
+		slog.Info("Сообщения сохраняются в SQLite", "file", config.SQLiteFile)
+	}
+	if messageStore != nil {
+		defer messageStore.Close()
+		err = loadReactions()
+		if err != nil {
+			fatal("Ошибка загрузки реакций", err)
+		}
+	}
+
+	// Push уведомления о личных сообщениях, если задан ключ сервисного аккаунта FCM.
+	// Токены устройств хранятся в базе, без нее уведомлять некого
+	if config.FCMServiceAccountJSON != "" {
+		if messageStore == nil {
+			fatal("Ошибка настройки FCM", errNoStoreForNotifications)
+		}
+		pushNotifier, err = newFCMClient(config.FCMServiceAccountJSON)
+		if err != nil {
+			fatal("Ошибка настройки FCM", err)
+		}
+		slog.Info("Push уведомления FCM включены", "project_id", pushNotifier.projectID)
+	}
+
+	// Письма о личных сообщениях пользователям не в сети, если задан SMTP сервер
+	if config.SMTPHost != "" {
+		if messageStore == nil {
+			fatal("Ошибка настройки почты", errNoStoreForNotifications)
+		}
+		mailer = newMailer(config)
+		slog.Info("Письма о личных сообщениях включены", "smtp", mailer.addr, "delay", mailer.delay)
+	}
+
+	// Общая рассылка для нескольких экземпляров сервера, если задан Redis
+	if config.RedisURL != "" {
+		redisClient = connectRedis(config.RedisURL)
+		if redisClient != nil {
+			defer redisClient.Close()
+		}
+	}
 
-	// Заставим основную горутину ждать (чтобы серверы не завершились сразу)
-	select {}
+	server := NewServer(config)
+
+	// Журнал сообщений (NDJSON), если задан файл
+	if config.MessageLogFile != "" {
+		server.messageLog, err = openMessageLog(config.MessageLogFile)
+		if err != nil {
+			fatal("Ошибка открытия журнала сообщений", err)
+		}
+		defer server.messageLog.Close()
+		slog.Info("Сообщения записываются в журнал", "file", config.MessageLogFile)
+	}
+
+	// Плагины регистрируют свои обработчики до начала рассылки сообщений
+	if config.PluginDir != "" {
+		err = server.loadPlugins(config.PluginDir)
+		if err != nil {
+			fatal("Ошибка загрузки плагинов", err)
+		}
+	}
+
+	err = server.Start()
+	if err != nil {
+		fatal("Ошибка запуска сервера", err)
+	}
+
+	// Ждем сигнала остановки (SIGINT/SIGTERM)
+	<-ctx.Done()
+	stop()
+	server.Stop()
+	shutdownPlugins()
+
+	tracingCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout.Duration)
+	defer cancel()
+	err = shutdownTracing(tracingCtx)
+	if err != nil {
+		slog.Error("Ошибка отправки трассировок", "err", err)
+	}
+}
+
+// handleWebSocket переводит HTTP запрос в WebSocket соединение и выбирает кодировку
+// по согласованному подпротоколу: chat.msgpack (или прежний msgpack) — MessagePack,
+// chat.json или без подпротокола — JSON.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrader сам отвечает клиенту ошибкой HTTP
+		slog.Warn("Ошибка WebSocket upgrade", "remote_addr", r.RemoteAddr, "err", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(int64(s.config.MaxMessageBytes) + wsFrameOverhead)
+
+	s.serveWebSocket(conn, r, encodingForSubprotocol(conn.Subprotocol()))
 }
 
-// handleWebSocket обрабатывает новое WebSocket соединение.
-func handleWebSocket(ws *websocket.Conn) {
+// serveWebSocket обслуживает WebSocket клиента, кодируя сообщения в кодировке enc.
+func (s *Server) serveWebSocket(conn *websocket.Conn, r *http.Request, enc Encoding) {
+	wsConnectionsTotal.Inc()
+
 	// Создаем нового клиента
-	client := &Client{conn: ws}
+	transport := &wsTransport{conn: conn, encoding: enc}
+	client := s.newClient("ws", r.RemoteAddr, transport)
+	client.Encoding = enc
+	transport.bytesSent = &client.BytesSent
+	client.Tenant = requestTenant(r)
+
+	// Если клиент прошел аутентификацию (JWT или API ключ), имя берется из токена или ключа
+	if username, ok := authenticatedUser(r.Context()); ok {
+		err := s.claimUsername(client, username)
+		if err != nil {
+			sendError(client, err.Error())
+			return
+		}
+		client.authenticated = true
+		rememberEmail(client, r)
+	}
+	client.readOnly = !authAllows(r.Context(), "send")
+	client.admin = isAdminRole(r.Context())
+
+	// Переподключившийся клиент передает токен восстановления, чтобы вернуть ID, имя и комнаты.
+	// Клиент с именем из токена или ключа сразу получает свой токен восстановления
+	if !restoreSession(client, r.URL.Query().Get("resume_token")) && client.Username != "" {
+		saveSession(client)
+		err := client.Send(registeredMessage(client))
+		if err != nil {
+			client.logger().Error("Ошибка отправки подтверждения регистрации", "err", err)
+			return
+		}
+	}
+
+	// Новый клиент сразу получает последние сообщения, чтобы понимать контекст разговора
+	err := client.Send(Message{Type: "history", History: s.bridge.Visible(inTenant(s.unexpired(s.history.Messages()), client.Tenant), client)})
+	if err != nil {
+		client.logger().Error("Ошибка отправки истории", "err", err)
+		return
+	}
+
+	// Ответ pong на управляющий ping подтверждает, что клиент жив (см. heartbeat)
+	conn.SetPongHandler(func(string) error {
+		client.touch()
+		return nil
+	})
+	go client.heartbeat(s.config.PingInterval.Duration, s.config.PongTimeout.Duration)
+
+	s.serveClient(client, func() (Message, error) {
+		var msg Message
+		_, data, err := conn.ReadMessage()
+		client.BytesReceived.Add(uint64(len(data)))
+		if err == nil {
+			err = deserialize(data, enc, &msg)
+		}
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			// Клиент закрыл соединение (в том числе без кадра close)
+			err = io.EOF
+		}
+		return msg, err
+	})
+}
+
+// serveClient регистрирует клиента и обрабатывает его сообщения до отключения.
+// receive читает очередное сообщение от клиента в зависимости от протокола.
+func (s *Server) serveClient(client *Client, receive func() (Message, error)) {
+	s.clientsWG.Add(1)
+	defer s.clientsWG.Done()
+	defer close(client.done)
 
 	// Добавляем клиента в список
-	mutex.Lock()
-	clients[client] = true
-	mutex.Unlock()
+	s.mutex.Lock()
+	s.clients[client] = true
+	s.mutex.Unlock()
+	connectedClients.Inc()
+	defer connectedClients.Dec()
 
-	fmt.Println("Новый WebSocket клиент подключен")
+	// Остановка ждет и writeLoop: он еще может записать недоставленное сообщение (DeadLetterQueue)
+	s.clientsWG.Add(1)
+	go func() {
+		defer s.clientsWG.Done()
+		client.writeLoop()
+	}()
+
+	client.logger().Info("Новый клиент подключен", "protocol", client.Protocol)
+	s.events.Emit(Event{Type: EventClientConnect, Client: client})
+
+	if s.config.AckTimeout.Duration > 0 {
+		go client.checkAcks(s.config.AckTimeout.Duration)
+	}
+
+	// Клиент, прошедший аутентификацию, уже имеет имя — сразу сообщаем о входе
+	if client.Username != "" {
+		s.announce(client.Tenant, fmt.Sprintf("пользователь %s вошел в чат", client.Username))
+		setPresence(client, "online")
+	}
 
 	// Чтение сообщений от клиента
 	for {
-		var msg Message
 		// Читаем сообщение от клиента
-		err := websocket.JSON.Receive(ws, &msg)
+		msg, err := receive()
 		if err != nil {
 			// Если произошла ошибка (например, клиент отключился), удаляем клиента
 			if err != io.EOF {
-				log.Printf("Ошибка чтения WebSocket сообщения от клиента %v: %v\n", client.conn.RemoteAddr(), err)
+				client.logger().Warn("Ошибка чтения сообщения", "err", err)
 			} else {
-				fmt.Printf("WebSocket клиент %v отключен\n", client.conn.RemoteAddr())
+				client.logger().Info("Клиент отключен")
 			}
 
-			mutex.Lock()
-			delete(clients, client)
-			mutex.Unlock()
+			leaveAllRooms(client)
+			unsubscribeAllTopics(client)
+			s.mutex.Lock()
+			s.removeClientLocked(client)
+			s.mutex.Unlock()
+			s.events.Emit(Event{Type: EventClientDisconnect, Client: client})
+			if client.Username != "" {
+				s.announce(client.Tenant, fmt.Sprintf("пользователь %s вышел из чата", client.Username))
+				setPresence(client, "offline")
+			}
 			break // Выходим из цикла чтения
 		}
 
-		// Отправляем полученное сообщение в канал broadcast
-		broadcast <- msg
+		client.touch()
+		client.active()
+		client.MessagesReceived.Add(1)
+		ctx, span := tracer.Start(context.Background(), client.Protocol+".receive", trace.WithAttributes(
+			attribute.String("client_id", client.ID),
+			attribute.String("message.type", msg.Type),
+		))
+		s.handleClientMessage(ctx, client, msg)
+		span.End()
 	}
 }
 
-// handleMessages принимает сообщения из канала broadcast и отправляет их всем клиентам.
-func handleMessages() {
-	for {
-		// Ожидаем новое сообщение из канала broadcast
-		msg := <-broadcast
-
-		fmt.Printf("Получено сообщение для рассылки: %s\n", msg.Text)
+// handleClientMessage обрабатывает одно сообщение протокола от клиента.
+// ctx несет спан приема сообщения, его контекст передается дальше в Message.TraceID.
+func (s *Server) handleClientMessage(ctx context.Context, client *Client, msg Message) {
+	switch msg.Type {
+	case "pong":
+		// Ответ на ping нужен только для проверки живости (см. heartbeat)
+		return
+	case "ack":
+		client.acks.acknowledge(msg.MsgID)
+		return
+	}
 
-		// Отправляем сообщение всем подключенным клиентам
-		mutex.Lock()
-		for client := range clients {
-			err := websocket.JSON.Send(client.conn, msg)
+	// Первым сообщением клиент обязан зарегистрировать имя. Если включена аутентификация,
+	// до регистрации имени нет только у клиентов TCP: имя берется из JWT в кадре регистрации
+	if msg.Type == "register" {
+		username := msg.Username
+		if s.config.JWTSecret != "" && client.Username == "" {
+			var err error
+			username, err = s.authenticateTCP(client, msg.Token)
 			if err != nil {
-				log.Printf("Ошибка отправки WebSocket сообщения клиенту %v: %v\n", client.conn.RemoteAddr(), err)
-				// Если не удалось отправить, возможно, клиент отключился, удаляем его
-				client.conn.Close() // Закрываем соединение
-				delete(clients, client)
+				sendError(client, err.Error())
+				client.Close()
+				return
 			}
 		}
-		mutex.Unlock()
+		s.registerClient(client, username)
+		return
 	}
+	if client.Username == "" {
+		sendError(client, "сначала зарегистрируйтесь: отправьте сообщение type:\"register\" с полем username")
+		return
+	}
+
+	switch msg.Type {
+	case "join":
+		joinRoom(client, msg.Room, msg.Password)
+		return
+	case "leave":
+		leaveRoom(client, msg.Room)
+		return
+	case "subscribe":
+		subscribeTopic(client, msg.Topic)
+		return
+	case "unsubscribe":
+		unsubscribeTopic(client, msg.Topic)
+		return
+	case "typing":
+		changeStatus(client, "online")
+		handleTyping(client, msg.Room)
+		return
+	case "status":
+		handleStatus(client, msg.Status)
+		return
+	case "reaction":
+		handleReaction(client, msg)
+		return
+	case "read":
+		if msg.MsgID == "" {
+			sendError(client, "не указан msg_id прочитанного сообщения")
+			return
+		}
+		s.receipts.Record(client.Tenant, msg.MsgID, client)
+		return
+	case "", "message":
+	default:
+		sendError(client, "неизвестный тип сообщения: "+msg.Type)
+		return
+	}
+
+	// Слишком длинные сообщения не рассылаются
+	if len(msg.Text) > s.config.MaxMessageBytes {
+		sendError(client, fmt.Sprintf("сообщение слишком длинное: %d байт при лимите %d", len(msg.Text), s.config.MaxMessageBytes))
+		return
+	}
+
+	// Вложения проверяются до рассылки: сервер не должен распространять ссылки на неизвестные домены
+	err := validateAttachments(s.config, msg.Attachments)
+	if err != nil {
+		sendError(client, err.Error())
+		return
+	}
+
+	// Сообщение с темой уходит подписчикам темы, а не в комнату или общий чат
+	if msg.Topic != "" {
+		if msg.Room != "" || msg.Recipient != "" {
+			sendError(client, "сообщение с темой не может быть адресовано комнате или пользователю")
+			return
+		}
+		err = validateTopic(msg.Topic)
+		if err != nil {
+			sendError(client, err.Error())
+			return
+		}
+	}
+
+	// Ответ должен ссылаться на существующее сообщение
+	if msg.ParentMsgID != "" && !s.messageExists(client.Tenant, msg.ParentMsgID) {
+		sendError(client, "сообщение не найдено: "+msg.ParentMsgID)
+		return
+	}
+
+	// Клиент, который шлет сообщения слишком часто, отключается
+	if !client.waitRateLimit() {
+		client.logger().Warn("Клиент превысил лимит сообщений и будет отключен")
+		client.Send(Message{Type: "rate_limit", Text: "превышен лимит сообщений, соединение закрыто"})
+		client.Close()
+		return
+	}
+
+	// Текст, начинающийся с "/", — команда чата (см. RegisterCommand)
+	if strings.HasPrefix(msg.Text, "/") {
+		runCommand(client, msg.Text)
+		return
+	}
+
+	// Пользователь, лишенный права писать (/mute), не может отправлять сообщения
+	if client.readOnly {
+		sendError(client, "вам запрещено писать в чат")
+		return
+	}
+	if until, muted := mutedUntil(client.Tenant, client.Username); muted {
+		sendMuted(client, "globally_muted", "администратор запретил вам писать в чат", until)
+		return
+	}
+	// Запрет писать в комнату проверяется до отложенной отправки: иначе его можно обойти через deliver_at
+	if room := client.rooms[msg.Room]; room != nil {
+		if until, muted := room.muteRemaining(client.Username); muted {
+			sendMuted(client, "muted", "вам запрещено писать в комнату "+msg.Room, until)
+			return
+		}
+	}
+
+	// Отправителя проставляет сервер, чтобы клиент не мог выдать себя за другого.
+	// Сообщение уходит всем, включая самого отправителя, — так он видит подтверждение отправки.
+	msg.Type = "message"
+	if msg.MsgID == "" || len(msg.MsgID) > maxClientMsgIDBytes {
+		msg.MsgID = newMsgID()
+	}
+	// Одно и то же сообщение может прийти дважды, если клиент повторил отправку после сетевой ошибки
+	if recentMessages.Seen(client.Tenant, msg.MsgID) {
+		messagesDeduplicatedTotal.Inc()
+		client.logger().Info("Повторное сообщение отброшено", "msg_id", msg.MsgID)
+		err := client.Send(Message{Type: "duplicate", MsgID: msg.MsgID})
+		if err != nil {
+			client.logger().Error("Ошибка отправки ответа о повторном сообщении", "msg_id", msg.MsgID, "err", err)
+		}
+		return
+	}
+	msg.Sender = client.ID
+	msg.SeqNum = client.nextSeqNum(msg)
+	msg.Priority = clientPriority(msg.Priority, client.admin)
+	msg.Username = client.Username
+	msg.Tenant = client.Tenant
+	msg.protocol = client.Protocol
+	msg.Source = messageSource(client.Protocol)
+	// Время тоже ставит сервер: часам клиента доверять нельзя.
+	msg.SentAt = time.Now().UTC()
+	msg.TraceID = injectTrace(ctx)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("msg_id", msg.MsgID))
+	s.events.Emit(Event{Type: EventMessageReceived, Client: client, Message: msg, Room: msg.Room})
+
+	// Отложенное сообщение ждет своего времени в очереди
+	if msg.DeliverAt != nil && msg.DeliverAt.After(msg.SentAt) {
+		if msg.Room != "" && client.rooms[msg.Room] == nil {
+			sendError(client, "вы не в комнате "+msg.Room)
+			return
+		}
+		scheduleMessage(msg)
+		err := client.Send(Message{Type: "scheduled", MsgID: msg.MsgID, Room: msg.Room, DeliverAt: msg.DeliverAt})
+		if err != nil {
+			client.logger().Error("Ошибка отправки подтверждения отложенного сообщения", "msg_id", msg.MsgID, "err", err)
+		}
+		return
+	}
+
+	if msg.Topic != "" {
+		s.publishTopic(msg)
+		return
+	}
+
+	// Сообщения комнаты уходят только ее участникам
+	if msg.Room != "" {
+		sendToRoom(client, msg)
+		return
+	}
+
+	// Отправляем полученное сообщение в очередь broadcast
+	err = s.Broadcast(msg)
+	if err != nil {
+		sendError(client, err.Error())
+	}
+}
+
+var (
+	errShuttingDown  = errors.New("сервер останавливается, сообщение не отправлено")
+	errBroadcastFull = errors.New("сервер перегружен, сообщение отброшено")
+)
+
+// Broadcast передает сообщение в очередь broadcast, не блокируясь.
+// Если очередь или очередь отправителя заполнена, сообщение отбрасывается и учитывается в метрике.
+func (s *Server) Broadcast(msg Message) error {
+	msg.enqueuedAt = time.Now()
+	err := s.broadcast.Push(msg)
+	if errors.Is(err, errBroadcastFull) {
+		droppedMessagesTotal.Inc()
+		slog.Warn("Очередь рассылки заполнена, сообщение отброшено", "client_id", msg.Sender, "msg_id", msg.MsgID)
+	}
+	if err != nil {
+		return err
+	}
+	observeMessageSize(msg)
+	return nil
 }
 
-// handleTCPConnection обрабатывает новое TCP соединение.
-func handleTCPConnection(conn net.Conn) {
-	fmt.Printf("Новое TCP соединение от %s\n", conn.RemoteAddr())
-	defer conn.Close() // Убедимся, что соединение закрыто при выходе из функции
+// registerClient задает имя клиента и отправляет ему подтверждение регистрации.
+func (s *Server) registerClient(client *Client, username string) {
+	err := s.claimUsername(client, username)
+	if err != nil {
+		sendError(client, err.Error())
+		return
+	}
+	saveSession(client)
+	client.logger().Info("Клиент зарегистрирован", "username", client.Username)
 
-	// Чтение данных из соединения
-	buffer := make([]byte, 1024)
+	err = client.Send(registeredMessage(client))
+	if err != nil {
+		client.logger().Error("Ошибка отправки подтверждения регистрации", "err", err)
+	}
+	s.announce(client.Tenant, fmt.Sprintf("пользователь %s вошел в чат", client.Username))
+	setPresence(client, "online")
+}
+
+// announce рассылает всем клиентам арендатора tenant системное сообщение (type:"system").
+func (s *Server) announce(tenant, text string) {
+	err := s.Broadcast(Message{Type: "system", MsgID: newMsgID(), Text: text, Tenant: tenant, Priority: prioritySystem, SentAt: time.Now().UTC()})
+	if err != nil {
+		slog.Warn("Системное сообщение не отправлено", "text", text, "err", err)
+	}
+}
+
+// claimUsername закрепляет имя за клиентом, если клиент еще не зарегистрирован и имя
+// свободно у его арендатора.
+func (s *Server) claimUsername(client *Client, username string) error {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return errors.New("имя пользователя не может быть пустым")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if client.Username != "" {
+		return errors.New("имя уже зарегистрировано: " + client.Username)
+	}
+	key := tenantName{client.Tenant, username}
+	if _, taken := s.clientsByName[key]; taken {
+		return errors.New("имя уже занято: " + username)
+	}
+	client.Username = username
+	s.clientsByName[key] = client
+	return nil
+}
+
+// sendError отправляет сообщение об ошибке только указанному клиенту.
+func sendError(client *Client, text string) {
+	err := client.Send(Message{Type: "error", Text: text})
+	if err != nil {
+		client.logger().Error("Ошибка отправки сообщения об ошибке", "err", err)
+	}
+}
+
+// sendErrorCode отправляет клиенту сообщение об ошибке с машиночитаемой причиной code.
+func sendErrorCode(client *Client, code, text string) {
+	err := client.Send(Message{Type: "error", Code: code, Text: text})
+	if err != nil {
+		client.logger().Error("Ошибка отправки сообщения об ошибке", "err", err)
+	}
+}
+
+// handleMessages принимает сообщения из очереди broadcast, начиная с самого приоритетного,
+// и отправляет их всем клиентам. Завершается после закрытия очереди, когда все оставшиеся сообщения разосланы.
+func (s *Server) handleMessages() {
+	defer close(s.messagesDone)
+
+	// Ожидаем новые сообщения из очереди broadcast
 	for {
-		n, err := conn.Read(buffer)
+		msg, ok := s.broadcast.Pop()
+		if !ok {
+			return
+		}
+		ctx, span := tracer.Start(extractTrace(msg), "ws.send", trace.WithAttributes(attribute.String("msg_id", msg.MsgID)))
+		msg, err := s.applyMiddleware(ctx, msg)
 		if err != nil {
-			if err != io.EOF {
-				log.Printf("Ошибка чтения TCP данных от %s: %v\n", conn.RemoteAddr(), err)
-			} else {
-				fmt.Printf("TCP клиент %s отключен\n", conn.RemoteAddr())
-			}
-			break // Выходим из цикла чтения
+			s.rejectMessage(msg, err)
+		} else {
+			s.fanOut(msg)
+		}
+		span.End()
+	}
+}
+
+// fanOut доставляет одно сообщение из очереди broadcast: личное — получателю, остальные —
+// всем клиентам арендатора сообщения.
+func (s *Server) fanOut(msg Message) {
+	messagesTotal.Add(1)
+	messagesBroadcastTotal.Inc()
+	s.messageLog.Write(msg)
+	publishRedis(msg)
+
+	// Задержка рассылки учитывается, когда последний получатель отправит сообщение или отбросит его
+	tracker := newFanoutTracker(msg, msg.enqueuedAt)
+	defer tracker.done(false)
+	msg.fanout = tracker
+
+	if msg.Recipient != "" {
+		s.sendDirect(msg)
+		return
+	}
+
+	slog.Info("Получено сообщение для рассылки", "client_id", msg.Sender, "msg_id", msg.MsgID, "text", msg.Text)
+	s.history.Add(msg)
+
+	// Отправляем сообщение всем подключенным клиентам
+	s.mutex.Lock()
+	for client := range s.clients {
+		if client.Tenant == msg.Tenant && s.bridge.Forward(msg, client) {
+			s.sendLocked(client, msg)
 		}
+	}
+	s.mutex.Unlock()
+	// Дальше сообщение уходит за пределы клиентских очередей, учет рассылки ему не нужен
+	msg.fanout = nil
+	broadcastSSE(msg)
+	broadcastPoll(msg)
+	roomCache.Record(msg)
+	s.events.Emit(Event{Type: EventMessageBroadcast, Message: msg})
+}
 
-		// Выводим полученные данные (для примера)
-		receivedData := string(buffer[:n])
-		fmt.Printf("Получено TCP данных от %s: %s\n", conn.RemoteAddr(), receivedData)
+// sendDirect доставляет личное сообщение получателю и копию отправителю.
+// Если получатель не подключен, ему уходит push уведомление или письмо (см. notifyOffline),
+// а если уведомления не настроены — отправитель получает сообщение об ошибке.
+func (s *Server) sendDirect(msg Message) {
+	slog.Info("Личное сообщение", "client_id", msg.Sender, "msg_id", msg.MsgID, "username", msg.Username, "recipient", msg.Recipient)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sender := s.clientsByName[tenantName{msg.Tenant, msg.Username}]
+	recipient, ok := s.clientsByName[tenantName{msg.Tenant, msg.Recipient}]
+	// Уведомляет экземпляр, которому сообщение отправили, — иначе каждый экземпляр отправил бы свое
+	if !ok && (pushNotifier != nil || mailer != nil) && !msg.remote {
+		msg.fanout = nil
+		go s.notifyOffline(msg)
+		return
+	}
+	if !ok {
+		if sender != nil {
+			s.sendLocked(sender, Message{Type: "error", Text: "пользователь не найден: " + msg.Recipient})
+		}
+		return
+	}
+
+	s.sendLocked(recipient, msg)
+	if sender != nil && sender != recipient {
+		s.sendLocked(sender, msg)
+	}
+}
+
+// sendLocked ставит сообщение в очередь клиента и удаляет клиента, если он отключен как медленный.
+// Вызывающий должен удерживать mutex.
+func (s *Server) sendLocked(client *Client, msg Message) {
+	msg.fanout.add(client)
+	if !client.deliver(msg) {
+		s.removeClientLocked(client)
+	}
+}
 
-		// Опционально: отправить ответ клиенту
-		// response := fmt.Sprintf("Вы прислали: %s", receivedData)
-		// conn.Write([]byte(response))
+// removeClientLocked удаляет клиента из всех индексов. Вызывающий должен удерживать mutex.
+func (s *Server) removeClientLocked(client *Client) {
+	delete(s.clients, client)
+	key := tenantName{client.Tenant, client.Username}
+	if client.Username != "" && s.clientsByName[key] == client {
+		delete(s.clientsByName, key)
 	}
 }
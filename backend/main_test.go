@@ -0,0 +1,534 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	cfg := defaultConfig()
+	roomCache = newRoomCache(cfg.RoomCacheSize)
+	connSlots = make(chan struct{}, cfg.MaxConnections)
+	goleak.VerifyTestMain(m)
+}
+
+func TestHandleMessages(t *testing.T) {
+	// В конце каждого случая рассылается last: получив его, клиент получил и все предыдущие сообщения
+	const last = "конец"
+	tests := []struct {
+		name    string
+		clients []string
+		// failing — клиенты, отправка которым завершается ошибкой закрытого соединения
+		failing  []string
+		messages []Message
+		// closeFirst — очередь закрывается до запуска handleMessages, и он должен разослать все, что в ней осталось
+		closeFirst bool
+		// want — тексты сообщений type:"message", которые получит каждый исправный клиент
+		want map[string][]string
+	}{
+		{
+			name:     "один клиент",
+			clients:  []string{"alice"},
+			messages: []Message{{Username: "alice", Text: "привет"}},
+			want:     map[string][]string{"alice": {"привет", last}},
+		},
+		{
+			name:     "несколько клиентов",
+			clients:  []string{"alice", "bob", "carol"},
+			messages: []Message{{Username: "alice", Text: "раз"}, {Username: "bob", Text: "два"}},
+			want: map[string][]string{
+				"alice": {"раз", "два", last},
+				"bob":   {"раз", "два", last},
+				"carol": {"раз", "два", last},
+			},
+		},
+		{
+			name:     "пробелы по краям убираются",
+			clients:  []string{"alice"},
+			messages: []Message{{Username: "alice", Text: "  привет \n"}},
+			want:     map[string][]string{"alice": {"привет", last}},
+		},
+		{
+			name:     "личное сообщение",
+			clients:  []string{"alice", "bob", "carol"},
+			messages: []Message{{Username: "alice", Recipient: "bob", Text: "лично"}},
+			want: map[string][]string{
+				"alice": {"лично", last},
+				"bob":   {"лично", last},
+				"carol": {last},
+			},
+		},
+		{
+			name:     "ошибка отправки отключает клиента",
+			clients:  []string{"alice", "bob"},
+			failing:  []string{"bob"},
+			messages: []Message{{Username: "alice", Text: "привет"}},
+			want:     map[string][]string{"alice": {"привет", last}},
+		},
+		{
+			name:       "очередь досылается после закрытия",
+			clients:    []string{"alice", "bob"},
+			messages:   []Message{{Username: "alice", Text: "раз"}, {Username: "bob", Text: "два"}, {Username: "alice", Text: "три"}},
+			closeFirst: true,
+			want: map[string][]string{
+				"alice": {"раз", "два", "три", last},
+				"bob":   {"раз", "два", "три", last},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(testConfig())
+			t.Cleanup(func() {
+				s.closeBroadcast()
+				<-s.messagesDone
+				s.clientsWG.Wait()
+			})
+			if !tt.closeFirst {
+				go s.handleMessages()
+			}
+
+			conns := make(map[string]*fakeClient)
+			clients := make(map[string]*Client)
+			for _, name := range tt.clients {
+				var sendErr error
+				if slices.Contains(tt.failing, name) {
+					sendErr = net.ErrClosed
+				}
+				clients[name], conns[name] = connectFake(t, s, name, sendErr)
+			}
+
+			for _, msg := range append(tt.messages, Message{Username: tt.clients[0], Text: last}) {
+				msg.Type = "message"
+				msg.MsgID = newMsgID()
+				err := s.Broadcast(msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.closeFirst {
+				s.closeBroadcast()
+				go s.handleMessages()
+			}
+
+			for name, want := range tt.want {
+				got := conns[name].texts(t, last)
+				if !slices.Equal(got, want) {
+					t.Errorf("%s получил %q, ожидалось %q", name, got, want)
+				}
+			}
+			for _, name := range tt.failing {
+				err := clients[name].WaitClosed(testTimeout)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if s.hasClient(clients[name]) {
+					t.Errorf("клиент %s с ошибкой отправки не удален", name)
+				}
+			}
+		})
+	}
+}
+
+// TestBroadcastDeliversText проверяет на случайных текстах, что сообщение доходит до клиента
+// с тем же MsgID и текстом без пробелов по краям.
+func TestBroadcastDeliversText(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	_, conn := connectFake(t, s, "alice", nil)
+
+	delivered := func(text string) bool {
+		id := newMsgID()
+		err := s.Broadcast(Message{Type: "message", MsgID: id, Username: "bob", Text: text})
+		if err != nil {
+			return false
+		}
+		for {
+			msg := conn.next(t)
+			if msg.MsgID == id {
+				return msg.Text == strings.TrimSpace(text)
+			}
+		}
+	}
+	err := quick.Check(delivered, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// TestHistoryKeepsLatest проверяет на случайных последовательностях, что история хранит
+// не больше size последних сообщений в порядке добавления.
+func TestHistoryKeepsLatest(t *testing.T) {
+	keepsLatest := func(texts []string, size uint8) bool {
+		h := newHistory(int(size%16) + 1)
+		for _, text := range texts {
+			h.Add(Message{Text: text})
+		}
+		var got []string
+		for _, msg := range h.Messages() {
+			got = append(got, msg.Text)
+		}
+		want := texts[max(0, len(texts)-len(h.messages)):]
+		return slices.Equal(got, want)
+	}
+	err := quick.Check(keepsLatest, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSlowClient(t *testing.T) {
+	tests := []struct {
+		name       string
+		disconnect bool
+	}{
+		{name: "сообщение отбрасывается"},
+		{name: "клиент отключается", disconnect: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cfg := testConfig()
+			cfg.DisconnectSlowClients = tt.disconnect
+			s := NewServer(cfg)
+			conn := newFakeClient(nil)
+			// writeLoop не запущен: очередь клиента никто не разбирает
+			client := s.newClient("ws", "fake", conn)
+			s.clients[client] = true
+
+			s.mutex.Lock()
+			for range sendQueueSize + 1 {
+				s.sendLocked(client, Message{Type: "message", Text: "спам"})
+			}
+			s.mutex.Unlock()
+
+			if got := s.hasClient(client); got == tt.disconnect {
+				t.Errorf("клиент в списке: %v, ожидалось %v", got, !tt.disconnect)
+			}
+			if got := closed(conn.closed); got != tt.disconnect {
+				t.Errorf("соединение закрыто: %v, ожидалось %v", got, tt.disconnect)
+			}
+			if got := len(client.send); got != sendQueueSize {
+				t.Errorf("в очереди клиента %d сообщений, ожидалось %d", got, sendQueueSize)
+			}
+		})
+	}
+}
+
+func TestBroadcastQueueFull(t *testing.T) {
+	cfg := testConfig()
+	cfg.BroadcastBuffer = 1
+	s := NewServer(cfg)
+
+	err := s.Broadcast(Message{Type: "message", Text: "раз"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Broadcast(Message{Type: "message", Text: "два"})
+	if !errors.Is(err, errBroadcastFull) {
+		t.Errorf("Broadcast в заполненную очередь вернул %v, ожидалось %v", err, errBroadcastFull)
+	}
+
+	go s.handleMessages()
+	s.closeBroadcast()
+	<-s.messagesDone
+	err = s.Broadcast(Message{Type: "message", Text: "три"})
+	if !errors.Is(err, errShuttingDown) {
+		t.Errorf("Broadcast в закрытую очередь вернул %v, ожидалось %v", err, errShuttingDown)
+	}
+}
+
+// timeoutError — сетевой таймаут, после которого отправку стоит повторить.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "таймаут" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClientWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		// errs — ошибки первых отправок, после них отправка удается, если не задан sendErr
+		errs          []error
+		sendErr       error
+		wantSent      bool
+		wantConnected bool
+		wantAttempts  int
+	}{
+		{name: "успешная отправка", wantSent: true, wantConnected: true, wantAttempts: 1},
+		{name: "таймаут повторяется", errs: []error{timeoutError{}, timeoutError{}}, wantSent: true, wantConnected: true, wantAttempts: 3},
+		{name: "повторы исчерпаны", sendErr: timeoutError{}, wantConnected: true, wantAttempts: 3},
+		{name: "закрытое соединение не повторяется", sendErr: net.ErrClosed, wantAttempts: 1},
+		{name: "сброс соединения не повторяется", sendErr: syscall.ECONNRESET, wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cfg := testConfig()
+			cfg.MaxSendRetries = 2
+			s := NewServer(cfg)
+			conn := newFakeClient(tt.sendErr)
+			conn.errs = tt.errs
+			client := s.newClient("ws", "fake", conn)
+
+			sent, connected := client.write(Message{Type: "message", Text: "привет"})
+			if sent != tt.wantSent || connected != tt.wantConnected {
+				t.Errorf("write = (%v, %v), ожидалось (%v, %v)", sent, connected, tt.wantSent, tt.wantConnected)
+			}
+			if got := conn.attempts(); got != tt.wantAttempts {
+				t.Errorf("попыток отправки %d, ожидалось %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestSeqNumPerDestination(t *testing.T) {
+	cfg := testConfig()
+	cfg.RateLimit = 1000
+	// Окно больше testTimeout: получатель, который ждал бы пропущенные номера, не дождался бы сообщений
+	cfg.ReorderBufferMS = 60000
+	s := newTestServer(t, cfg)
+	_, alice := connectFake(t, s, "alice", nil)
+	_, bob := connectFake(t, s, "bob", nil)
+	_, carol := connectFake(t, s, "carol", nil)
+	for _, conn := range []*fakeClient{alice, carol} {
+		conn.incoming <- Message{Type: "join", Room: "комната"}
+		for conn.next(t).Type != "joined" {
+		}
+	}
+	// Горутина комнаты работает, пока комнату не удалят
+	t.Cleanup(func() { s.deleteRoom(s.findRoom("", "комната")) })
+
+	// alice пишет то в комнату, то в общий чат
+	for i := 1; i <= 3; i++ {
+		alice.incoming <- Message{Type: "message", Room: "комната", Text: fmt.Sprintf("в комнату %d", i)}
+		alice.incoming <- Message{Type: "message", Text: fmt.Sprintf("в общий чат %d", i)}
+	}
+
+	tests := []struct {
+		name string
+		conn *fakeClient
+		// want — SeqNum полученных сообщений alice по комнатам ("" — общий чат)
+		want map[string][]uint64
+	}{
+		{name: "только общий чат", conn: bob, want: map[string][]uint64{"": {1, 2, 3}}},
+		{name: "комната и общий чат", conn: carol, want: map[string][]uint64{"": {1, 2, 3}, "комната": {1, 2, 3}}},
+		{name: "отправитель", conn: alice, want: map[string][]uint64{"": {1, 2, 3}, "комната": {1, 2, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string][]uint64)
+			for n := 0; n < 3*len(tt.want); {
+				msg := tt.conn.next(t)
+				if msg.Type == "message" && msg.Username == "alice" {
+					got[msg.Room] = append(got[msg.Room], msg.SeqNum)
+					n++
+				}
+			}
+			if !maps.EqualFunc(got, tt.want, slices.Equal[[]uint64]) {
+				t.Errorf("SeqNum по комнатам %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoomCommandsRequireAuthentication(t *testing.T) {
+	tests := []struct {
+		name          string
+		authenticated bool
+		wantRole      string
+		wantDeleted   bool
+	}{
+		{name: "имя не подтверждено", wantRole: "member"},
+		{name: "имя подтверждено", authenticated: true, wantRole: "owner", wantDeleted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, testConfig())
+			client, conn := connectFake(t, s, "alice", nil)
+			t.Cleanup(func() {
+				if room := s.findRoom("", "комната"); room != nil {
+					s.deleteRoom(room)
+				}
+			})
+			// Поле читает горутина клиента, но только после следующего сообщения от него
+			client.authenticated = tt.authenticated
+
+			conn.incoming <- Message{Type: "join", Room: "комната"}
+			msg := conn.next(t)
+			for msg.Type != "joined" {
+				msg = conn.next(t)
+			}
+			if msg.Role != tt.wantRole {
+				t.Errorf("роль создателя комнаты %q, ожидалась %q", msg.Role, tt.wantRole)
+			}
+
+			conn.incoming <- Message{Type: "message", Text: "/deleteroom комната"}
+			want := "error"
+			if tt.wantDeleted {
+				want = "left"
+			}
+			for msg.Type != want {
+				msg = conn.next(t)
+			}
+			if deleted := s.findRoom("", "комната") == nil; deleted != tt.wantDeleted {
+				t.Errorf("комната удалена: %v, ожидалось %v", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestUserErase(t *testing.T) {
+	s := NewServer(testConfig())
+	queue, err := openDeadLetterQueue(filepath.Join(t.TempDir(), "dead_letters.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadLetters = queue
+	t.Cleanup(func() {
+		deadLetters = nil
+		queue.Close()
+	})
+
+	deliverAt := time.Now().Add(time.Hour)
+	for _, username := range []string{"alice", "bob"} {
+		client := s.newClient("ws", "fake:"+username, newFakeClient(nil))
+		client.Username = username
+		s.receipts.Record("", "msg-1", client)
+		scheduleMessage(Message{Type: "message", MsgID: "scheduled-" + username, Username: username, DeliverAt: &deliverAt})
+		deadLetters.Add(Message{Type: "message", MsgID: "dead-" + username, Username: username}, 1, []string{client.ID})
+	}
+	t.Cleanup(func() { cancelScheduled("scheduled-bob") })
+	// Личное сообщение пользователю тоже его данные
+	scheduleMessage(Message{Type: "message", MsgID: "scheduled-to-alice", Username: "bob", Recipient: "alice", DeliverAt: &deliverAt})
+	t.Cleanup(func() { cancelScheduled("scheduled-to-alice") })
+
+	req := httptest.NewRequest(http.MethodDelete, "/admin/users/alice", nil)
+	req.SetPathValue("username", "alice")
+	rec := httptest.NewRecorder()
+	s.handleUserErase(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("код ответа %d, ожидался %d", rec.Code, http.StatusAccepted)
+	}
+
+	t.Run("отметки о прочтении", func(t *testing.T) {
+		receipts := s.receipts.List("", "msg-1")
+		if len(receipts) != 1 || receipts[0].Username != "bob" {
+			t.Errorf("отметки после удаления %+v, ожидалась только отметка bob", receipts)
+		}
+	})
+	t.Run("отложенные сообщения", func(t *testing.T) {
+		for id, want := range map[string]bool{"scheduled-alice": false, "scheduled-to-alice": false, "scheduled-bob": true} {
+			if _, ok := findScheduled(id); ok != want {
+				t.Errorf("отложенное сообщение %s в очереди: %v, ожидалось %v", id, ok, want)
+			}
+		}
+	})
+	t.Run("недоставленные сообщения", func(t *testing.T) {
+		letters, err := deadLetters.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(letters) != 1 || letters[0].Message.Username != "bob" {
+			t.Errorf("недоставленные сообщения после удаления %+v, ожидалось только сообщение bob", letters)
+		}
+	})
+}
+
+// countingConn — соединение клиента бенчмарка: только считает отправленные сообщения.
+type countingConn struct {
+	sent *atomic.Int64
+}
+
+func (c countingConn) Send(Message) error {
+	c.sent.Add(1)
+	return nil
+}
+
+func (c countingConn) Close() error {
+	return nil
+}
+
+// benchWindow — на сколько сообщений отправитель BenchmarkBroadcast может опережать клиентов.
+const benchWindow = sendQueueSize / 2
+
+// BenchmarkBroadcast измеряет рассылку сообщений одного отправителя N клиентам, очереди
+// которых разбирают их собственные writeLoop. Одна итерация — одно сообщение всем клиентам.
+// Кроме времени на сообщение сообщает сообщений и доставок в секунду, аллокаций на сообщение
+// и долю сообщений, отброшенных из-за переполненных очередей клиентов:
+//
+//	go test -run '^$' -bench BenchmarkBroadcast -race .
+func BenchmarkBroadcast(b *testing.B) {
+	for _, n := range []int{16, 64, 256, 1024} {
+		b.Run(fmt.Sprintf("clients=%d", n), func(b *testing.B) {
+			cfg := testConfig()
+			cfg.BroadcastBuffer = 1024
+			s := newTestServer(b, cfg)
+			var delivered atomic.Int64
+			for i := range n {
+				client := s.newClient("ws", fmt.Sprintf("bench:%d", i), countingConn{sent: &delivered})
+				s.mutex.Lock()
+				s.clients[client] = true
+				s.mutex.Unlock()
+				go client.writeLoop()
+				b.Cleanup(func() { close(client.done) })
+			}
+			msg := Message{Type: "message", Username: "bench", Text: "сообщение бенчмарка"}
+			droppedBefore := testutil.ToFloat64(slowClientDropsTotal)
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			b.ResetTimer()
+			start := time.Now()
+
+			// Сообщение обработано, когда каждый клиент получил его или отбросил
+			processed := func() int64 {
+				return delivered.Load() + int64(testutil.ToFloat64(slowClientDropsTotal)-droppedBefore)
+			}
+			for i := range b.N {
+				// Отправитель опережает клиентов не больше чем на половину их очереди,
+				// иначе бенчмарк измерял бы отбрасывание сообщений, а не рассылку
+				for int64(i-benchWindow)*int64(n) > processed() {
+					runtime.Gosched()
+				}
+				for s.Broadcast(msg) != nil {
+					runtime.Gosched()
+				}
+			}
+			want := int64(b.N) * int64(n)
+			for processed() < want {
+				time.Sleep(100 * time.Microsecond)
+			}
+			dropped := processed() - delivered.Load()
+
+			elapsed := time.Since(start).Seconds()
+			b.StopTimer()
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(b.N)/elapsed, "msgs/s")
+			b.ReportMetric(float64(delivered.Load())/elapsed, "deliveries/s")
+			b.ReportMetric(float64(after.Mallocs-before.Mallocs)/float64(b.N), "allocs/msg")
+			b.ReportMetric(float64(dropped)/float64(want), "dropped/delivery")
+		})
+	}
+}
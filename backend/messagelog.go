@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MessageLog дописывает сообщения в файл в формате NDJSON (один JSON объект на строку).
+type MessageLog struct {
+	file *os.File
+	// mutex сериализует запись: сообщения пишут горутины общего чата и комнат.
+	mutex sync.Mutex
+}
+
+// openMessageLog открывает файл журнала на дозапись, создавая его при необходимости.
+// Существующее содержимое не читается.
+func openMessageLog(path string) (*MessageLog, error) {
+	file, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageLog{file: file}, nil
+}
+
+// openLogFile открывает файл журнала сообщений на дозапись.
+func openLogFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+}
+
+// Write дописывает сообщение в журнал. Ошибки записи только логируются,
+// чтобы проблемы с диском не останавливали рассылку. Вызов на nil журнале ничего не делает.
+func (l *MessageLog) Write(msg Message) {
+	if l == nil {
+		return
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("Ошибка сериализации сообщения для журнала", "msg_id", msg.MsgID, "err", err)
+		return
+	}
+	data = append(data, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, err = l.file.Write(data)
+	if err != nil {
+		slog.Error("Ошибка записи в журнал сообщений", "msg_id", msg.MsgID, "err", err)
+	}
+}
+
+// Close закрывает файл журнала.
+func (l *MessageLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
+
+// ReadAll читает все сообщения журнала в порядке записи. Поврежденные строки пропускаются.
+func (l *MessageLog) ReadAll() ([]Message, error) {
+	file, err := os.Open(l.file.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var messages []Message
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxTCPFrameSize)
+	for scanner.Scan() {
+		var msg Message
+		if json.Unmarshal(scanner.Bytes(), &msg) != nil {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, scanner.Err()
+}
+
+// RemoveUser переписывает журнал без сообщений пользователя username арендатора tenant:
+// отправленных им и личных сообщений ему. Файл заменяется целиком, поэтому запись
+// на время перезаписи останавливается. Вызов на nil журнале ничего не делает.
+func (l *MessageLog) RemoveUser(tenant, username string) error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	path := l.file.Name()
+	err := rewriteLines(path, 0o644, func(line []byte) bool {
+		var msg Message
+		return json.Unmarshal(line, &msg) == nil && userMessage(msg, tenant, username)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Прежний дескриптор указывает на замененный файл
+	file, err := openLogFile(path)
+	if err != nil {
+		return err
+	}
+	l.file.Close()
+	l.file = file
+	return nil
+}
+
+// rewriteLines заменяет файл NDJSON path копией без строк, для которых drop возвращает true.
+// Копия пишется во временный файл рядом с path и переименовывается поверх него.
+func rewriteLines(path string, perm os.FileMode, drop func(line []byte) bool) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 64*1024), maxTCPFrameSize)
+	for scanner.Scan() {
+		if drop(scanner.Bytes()) {
+			continue
+		}
+		writer.Write(scanner.Bytes())
+		writer.WriteByte('\n')
+	}
+	err = scanner.Err()
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	return err
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Метрики Prometheus, доступные на GET /metrics.
+//
+// Имена всех метрик сервера, в том числе объявленных в других файлах, начинаются с пространства
+// имен "chat_", чтобы не смешиваться с метриками Go (go_*) и процесса (process_*) на том же /metrics:
+// счетчики доставок вебхуков и попаданий в кэш называются chat_webhook_deliveries_total
+// и chat_cache_hit_total, а не webhook_deliveries_total и cache_hit_total. Новые метрики
+// называются так же.
+var (
+	wsConnectionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_websocket_connections_total",
+		Help: "WebSocket connections accepted.",
+	})
+	tcpConnectionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_tcp_connections_total",
+		Help: "TCP connections accepted.",
+	})
+	messagesBroadcastTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_messages_broadcast_total",
+		Help: "Messages fanned out by the broadcast loop.",
+	})
+	sendErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_send_errors_total",
+		Help: "Failed sends to clients.",
+	})
+	droppedMessagesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_dropped_messages_total",
+		Help: "Messages dropped because the broadcast queue was full.",
+	})
+	sendRetriesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_send_retries_total",
+		Help: "Sends to clients retried after a temporary network error.",
+	})
+	sendFinalFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_send_final_failures_total",
+		Help: "Sends to clients that still failed after all retries.",
+	})
+	slowClientDropsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_slow_client_drops_total",
+		Help: "Messages dropped because a client's send queue was full.",
+	})
+	connectedClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_connected_clients",
+		Help: "Currently connected clients.",
+	})
+	messageSizeBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chat_message_size_bytes",
+		Help:    "Text size of messages entering the broadcast and room channels, by origin protocol.",
+		Buckets: []float64{16, 64, 256, 1024, 4096, 16384},
+	}, []string{"protocol"})
+	broadcastLatencySeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_broadcast_latency_seconds",
+		Help:    "Time from a message entering the broadcast queue to the last recipient's send completing or failing.",
+		Buckets: prometheus.ExponentialBucketsRange(0.001, 1, 10),
+	})
+	fanoutSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_fanout_size",
+		Help:    "Clients each broadcast message was queued for.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+)
+
+// fanoutTracker считает получателей сообщения рассылки, которые его еще не отправили.
+// Когда отправит последний, в chat_broadcast_latency_seconds записывается время
+// от попадания сообщения в очередь broadcast, а если не отправил никто — сообщение
+// попадает в очередь недоставленных (см. DeadLetterQueue). Сообщения, оставшиеся в очереди
+// отключившегося клиента, не учитываются.
+type fanoutTracker struct {
+	msg Message
+	// pending — получатели без отправки плюс один за саму рассылку (см. fanOut).
+	pending atomic.Int64
+	start   time.Time
+	// targets — ID получателей. Пополняется только горутиной рассылки до снятия ее единицы с pending.
+	targets []string
+	failed  atomic.Int64
+	// attempts — неудачные попытки доставки до этой рассылки (повтор из очереди недоставленных).
+	attempts int
+}
+
+// newFanoutTracker создает учет рассылки сообщения msg, попавшего в очередь broadcast в момент start.
+func newFanoutTracker(msg Message, start time.Time) *fanoutTracker {
+	t := &fanoutTracker{msg: msg, start: start}
+	t.pending.Store(1)
+	return t
+}
+
+// add учитывает еще одного получателя. Вызывается до постановки сообщения в его очередь.
+func (t *fanoutTracker) add(client *Client) {
+	if t != nil {
+		t.pending.Add(1)
+		t.targets = append(t.targets, client.ID)
+	}
+}
+
+// done отмечает, что получатель отправил сообщение или, если failed, не смог его отправить
+// (очередь переполнена, ошибка отправки). Вызов на nil ничего не делает.
+func (t *fanoutTracker) done(failed bool) {
+	if t == nil {
+		return
+	}
+	if failed {
+		t.failed.Add(1)
+	}
+	if t.pending.Add(-1) != 0 {
+		return
+	}
+	broadcastLatencySeconds.Observe(time.Since(t.start).Seconds())
+	fanoutSize.Observe(float64(len(t.targets)))
+	if failed := int(t.failed.Load()); failed > 0 && failed == len(t.targets) {
+		deadLetters.Add(t.msg, t.attempts+failed, t.targets)
+	}
+}
+
+// observeMessageSize учитывает размер текста сообщения в chat_message_size_bytes.
+// Сообщения других экземпляров (Redis) уже учтены там, где их отправили.
+func observeMessageSize(msg Message) {
+	if msg.remote {
+		return
+	}
+	protocol := msg.protocol
+	if protocol == "" {
+		protocol = "system"
+	}
+	messageSizeBytes.WithLabelValues(protocol).Observe(float64(len(msg.Text)))
+}
+
+var (
+	// messagesTotal — число сообщений, прошедших через handleMessages.
+	messagesTotal atomic.Uint64
+	// startTime — время запуска сервера, выставляется в main.
+	startTime time.Time
+)
+
+// healthStatus — ответ GET /health.
+type healthStatus struct {
+	Status           string `json:"status"`
+	UptimeSeconds    int64  `json:"uptime_seconds"`
+	ConnectedClients int    `json:"connected_clients"`
+	MessagesTotal    uint64 `json:"messages_total"`
+}
+
+// handleHealth отдает состояние сервера для liveness/readiness проб.
+// Если очередь broadcast заполнена более чем на 80%, сервер считается перегруженным (503).
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if s.broadcast.Len()*5 > s.broadcast.Cap()*4 {
+		writeJSON(w, http.StatusServiceUnavailable, healthStatus{Status: "degraded"})
+		return
+	}
+
+	s.mutex.RLock()
+	connected := len(s.clients)
+	s.mutex.RUnlock()
+
+	writeJSON(w, http.StatusOK, healthStatus{
+		Status:           "ok",
+		UptimeSeconds:    int64(time.Since(startTime).Seconds()),
+		ConnectedClients: connected,
+		MessagesTotal:    messagesTotal.Load(),
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// MessageMiddleware обрабатывает сообщение перед рассылкой: возвращает (возможно измененное)
+// сообщение или ошибку — тогда сообщение отбрасывается, а отправитель получает type:"error".
+type MessageMiddleware func(ctx context.Context, msg Message) (Message, error)
+
+// chainMiddleware объединяет обработчики в один, вызывающий их по порядку.
+func chainMiddleware(chain ...MessageMiddleware) MessageMiddleware {
+	return func(ctx context.Context, msg Message) (Message, error) {
+		var err error
+		for _, m := range chain {
+			msg, err = m(ctx, msg)
+			if err != nil {
+				return msg, err
+			}
+		}
+		return msg, nil
+	}
+}
+
+// trimWhitespace убирает пробелы в начале и конце текста.
+func trimWhitespace(_ context.Context, msg Message) (Message, error) {
+	msg.Text = strings.TrimSpace(msg.Text)
+	return msg, nil
+}
+
+// banWords отклоняет сообщения с запрещенными словами (см. WordFilter).
+func banWords(_ context.Context, msg Message) (Message, error) {
+	if _, found := wordFilter.Match(msg.Text); found {
+		return msg, errors.New("сообщение содержит запрещенные слова и не отправлено")
+	}
+	return msg, nil
+}
+
+// stampTimestamp проставляет время отправки сообщениям, у которых его нет.
+func stampTimestamp(_ context.Context, msg Message) (Message, error) {
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now().UTC()
+	}
+	return msg, nil
+}
+
+// rejectMessage сообщает отправителю, что его сообщение отброшено цепочкой обработчиков.
+func (s *Server) rejectMessage(msg Message, err error) {
+	slog.Warn("Сообщение отброшено обработчиком", "client_id", msg.Sender, "msg_id", msg.MsgID, "err", err)
+	if client := s.findClientByID(msg.Sender); client != nil {
+		sendError(client, err.Error())
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"slices"
+)
+
+// migrate применяет к базе SQL файлы из каталога dir в fsys, которые еще не применялись.
+// Файлы применяются по порядку имен (0001_..., 0002_...), каждый в своей транзакции;
+// применённые версии записываются в таблицу schema_migrations.
+func migrate(db *sql.DB, fsys fs.FS, dir string) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version TEXT PRIMARY KEY)`)
+	if err != nil {
+		return fmt.Errorf("создание schema_migrations: %w", err)
+	}
+
+	names, err := fs.Glob(fsys, path.Join(dir, "*.sql"))
+	if err != nil {
+		return err
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		version := path.Base(name)
+		var applied int
+		err = db.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE version = $1`, version).Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("проверка миграции %s: %w", version, err)
+		}
+		if applied > 0 {
+			continue
+		}
+
+		script, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		err = applyMigration(db, version, string(script))
+		if err != nil {
+			return fmt.Errorf("миграция %s: %w", version, err)
+		}
+		slog.Info("Применена миграция", "version", version)
+	}
+	return nil
+}
+
+// applyMigration выполняет скрипт и отмечает версию применённой в одной транзакции.
+func applyMigration(db *sql.DB, version, script string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(script)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS messages (
+    id        SERIAL PRIMARY KEY,
+    client_id TEXT NOT NULL,
+    username  TEXT NOT NULL,
+    room      TEXT NOT NULL,
+    text      TEXT NOT NULL,
+    sent_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS messages_room_id ON messages (room, id);
@@ -0,0 +1,5 @@
+ALTER TABLE messages ADD COLUMN msg_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN parent_msg_id TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS messages_msg_id ON messages (msg_id);
+CREATE INDEX IF NOT EXISTS messages_parent_msg_id ON messages (parent_msg_id);
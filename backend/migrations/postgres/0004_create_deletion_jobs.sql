@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS deletion_jobs (
+    id               SERIAL PRIMARY KEY,
+    username         TEXT NOT NULL,
+    status           TEXT NOT NULL,
+    deleted_messages BIGINT NOT NULL DEFAULT 0,
+    error            TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMPTZ NOT NULL,
+    updated_at       TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS deletion_jobs_username_id ON deletion_jobs (username, id);
+CREATE INDEX IF NOT EXISTS messages_username_id ON messages (username, id);
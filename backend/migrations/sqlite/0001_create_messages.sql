@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS messages (
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    client_id TEXT NOT NULL,
+    username  TEXT NOT NULL,
+    room      TEXT NOT NULL,
+    text      TEXT NOT NULL,
+    sent_at   TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS messages_room_id ON messages (room, id);
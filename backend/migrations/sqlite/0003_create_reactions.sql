@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS reactions (
+    msg_id   TEXT NOT NULL,
+    emoji    TEXT NOT NULL,
+    username TEXT NOT NULL,
+    PRIMARY KEY (msg_id, emoji, username)
+);
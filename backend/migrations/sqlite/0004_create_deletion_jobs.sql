@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS deletion_jobs (
+    id               INTEGER PRIMARY KEY AUTOINCREMENT,
+    username         TEXT NOT NULL,
+    status           TEXT NOT NULL,
+    deleted_messages INTEGER NOT NULL DEFAULT 0,
+    error            TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMP NOT NULL,
+    updated_at       TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS deletion_jobs_username_id ON deletion_jobs (username, id);
+CREATE INDEX IF NOT EXISTS messages_username_id ON messages (username, id);
@@ -0,0 +1,5 @@
+ALTER TABLE messages ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+ALTER TABLE deletion_jobs ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS messages_tenant_room_id ON messages (tenant, room, id);
+CREATE INDEX IF NOT EXISTS messages_tenant_username_id ON messages (tenant, username, id);
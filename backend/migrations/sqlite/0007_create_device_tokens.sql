@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS device_tokens (
+    token      TEXT PRIMARY KEY,
+    tenant     TEXT NOT NULL DEFAULT '',
+    username   TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS device_tokens_tenant_username ON device_tokens (tenant, username);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS users (
+    tenant     TEXT NOT NULL DEFAULT '',
+    username   TEXT NOT NULL,
+    email      TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tenant, username)
+);
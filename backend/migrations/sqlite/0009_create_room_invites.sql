@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS room_invites (
+    tenant     TEXT NOT NULL DEFAULT '',
+    room       TEXT NOT NULL,
+    username   TEXT NOT NULL,
+    invited_by TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tenant, room, username)
+);
@@ -0,0 +1,4 @@
+DELETE FROM messages WHERE client_id = '' AND username = '';
+DELETE FROM messages WHERE msg_id <> '' AND id NOT IN (SELECT MIN(id) FROM messages WHERE msg_id <> '' GROUP BY tenant, msg_id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS messages_tenant_msg_id ON messages (tenant, msg_id) WHERE msg_id <> '';
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS reactions_by_tenant (
+    tenant   TEXT NOT NULL DEFAULT '',
+    msg_id   TEXT NOT NULL,
+    emoji    TEXT NOT NULL,
+    username TEXT NOT NULL,
+    PRIMARY KEY (tenant, msg_id, emoji, username)
+);
+
+INSERT INTO reactions_by_tenant (tenant, msg_id, emoji, username)
+SELECT DISTINCT m.tenant, r.msg_id, r.emoji, r.username FROM reactions r JOIN messages m ON m.msg_id = r.msg_id;
+
+DROP TABLE reactions;
+ALTER TABLE reactions_by_tenant RENAME TO reactions;
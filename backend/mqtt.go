@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+const (
+	// mqttInboundTopics — темы, сообщения из которых пересылаются в общий чат.
+	mqttInboundTopics = "chat/#"
+	// mqttBroadcastTopic — тема, в которую публикуется общая рассылка для MQTT клиентов.
+	mqttBroadcastTopic = "chat/broadcast"
+)
+
+// mqttAuthHook проверяет MQTT подключения тем же JWT, что и WebSocket: токен передается
+// в поле password, а username должен совпадать с полем sub токена. При пустом JWTSecret
+// подключаться может любой клиент. Темы MQTT общие для всех клиентов брокера, поэтому
+// подключаться могут только пользователи арендатора по умолчанию.
+type mqttAuthHook struct {
+	mqtt.HookBase
+	secret string
+}
+
+func (h *mqttAuthHook) ID() string {
+	return "chat-jwt-auth"
+}
+
+func (h *mqttAuthHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{mqtt.OnConnectAuthenticate, mqtt.OnACLCheck}, []byte{b})
+}
+
+func (h *mqttAuthHook) OnConnectAuthenticate(cl *mqtt.Client, pk packets.Packet) bool {
+	if blocklist.Blocked(cl.Net.Remote) {
+		slog.Warn("Отказ в MQTT подключении: адрес в списке блокировки", "remote_addr", cl.Net.Remote)
+		return false
+	}
+	if h.secret == "" {
+		return true
+	}
+
+	claims, err := parseJWTClaims(h.secret, string(pk.Connect.Password))
+	if err == nil && claims.Subject != string(pk.Connect.Username) {
+		err = fmt.Errorf("username %q не совпадает с полем sub токена", pk.Connect.Username)
+	}
+	if err == nil && claims.Tenant != "" {
+		err = fmt.Errorf("MQTT доступен только арендатору по умолчанию, а в токене арендатор %q", claims.Tenant)
+	}
+	if err != nil {
+		slog.Warn("Отказ в MQTT подключении: ошибка аутентификации", "remote_addr", cl.Net.Remote, "err", err)
+		auditLog.Record(auditLoginFailed, string(pk.Connect.Username), cl.ID, cl.Net.Remote, "method", "mqtt", "err", err)
+		return false
+	}
+	auditLog.Record(auditLogin, claims.Subject, cl.ID, cl.Net.Remote, "method", "mqtt")
+	return true
+}
+
+// OnACLCheck разрешает клиентам только темы chat/; публиковать в chat/broadcast может лишь сервер.
+func (h *mqttAuthHook) OnACLCheck(cl *mqtt.Client, topic string, write bool) bool {
+	if !strings.HasPrefix(topic, "chat/") {
+		return false
+	}
+	return !write || topic != mqttBroadcastTopic
+}
+
+// startMQTT запускает MQTT брокер на порту s.config.MQTTPort, пересылает сообщения
+// из chat/# в общий чат и публикует рассылку в chat/broadcast.
+func (s *Server) startMQTT() error {
+	s.mqttBroker = mqtt.New(&mqtt.Options{InlineClient: true, Logger: slog.Default().With("component", "mqtt")})
+	err := s.mqttBroker.AddHook(&mqttAuthHook{secret: s.config.JWTSecret}, nil)
+	if err != nil {
+		return err
+	}
+	addr := fmt.Sprintf(":%d", s.config.MQTTPort)
+	err = s.mqttBroker.AddListener(listeners.NewTCP(listeners.Config{Type: "tcp", ID: "chat", Address: addr}))
+	if err != nil {
+		return err
+	}
+	err = s.mqttBroker.Subscribe(mqttInboundTopics, 1, s.receiveMQTT)
+	if err != nil {
+		return err
+	}
+	s.events.On(EventMessageBroadcast, s.publishMQTT)
+
+	err = s.mqttBroker.Serve()
+	if err != nil {
+		return err
+	}
+	slog.Info("MQTT брокер запущен", "addr", addr)
+	return nil
+}
+
+// receiveMQTT пересылает сообщение MQTT клиента в общий чат. Полезная нагрузка —
+// JSON сообщения того же формата, что и в WebSocket, либо просто текст.
+// Обработчик получает встроенного клиента, а отправитель указан в pk.Origin.
+func (s *Server) receiveMQTT(_ *mqtt.Client, _ packets.Subscription, pk packets.Packet) {
+	// Собственные публикации сервера в chat/broadcast тоже попадают под chat/#
+	if pk.Origin == mqtt.InlineClientId {
+		return
+	}
+	cl, ok := s.mqttBroker.Clients.Get(pk.Origin)
+	if !ok {
+		return
+	}
+
+	var msg Message
+	if json.Unmarshal(pk.Payload, &msg) != nil {
+		msg = Message{Text: string(pk.Payload)}
+	}
+	// MQTT клиенты относятся к арендатору по умолчанию, поле tenant от клиента не учитывается
+	msg.Tenant = ""
+	// Имя подтверждено JWT при подключении (или аутентификация отключена)
+	if username := string(cl.Properties.Username); username != "" {
+		msg.Username = username
+	}
+	msg.Username = strings.TrimSpace(msg.Username)
+	logger := slog.With("client_id", "mqtt:"+cl.ID, "topic", pk.TopicName)
+	if msg.Username == "" {
+		logger.Warn("MQTT сообщение без имени пользователя отброшено")
+		return
+	}
+	if len(msg.Text) > s.config.MaxMessageBytes {
+		logger.Warn("MQTT сообщение слишком длинное и отброшено", "size", len(msg.Text))
+		return
+	}
+	if _, muted := mutedUntil(msg.Tenant, msg.Username); muted {
+		logger.Warn("MQTT сообщение пользователя, которому запрещено писать, отброшено", "username", msg.Username)
+		return
+	}
+	if !s.senderLimits.wait(msg.Tenant, msg.Username) {
+		logger.Warn("MQTT клиент превысил лимит сообщений, сообщение отброшено", "username", msg.Username)
+		return
+	}
+
+	msg.Type = "message"
+	msg.MsgID = newMsgID()
+	msg.Sender = "mqtt:" + cl.ID
+	msg.Room = ""
+	msg.Recipient = ""
+	msg.protocol = "mqtt"
+	msg.Source = ""
+	msg.SentAt = time.Now().UTC()
+	err := s.Broadcast(msg)
+	if err != nil {
+		logger.Warn("MQTT сообщение не отправлено", "err", err)
+	}
+}
+
+// publishMQTT публикует сообщение общего чата в chat/broadcast. MQTT клиенты относятся
+// к арендатору по умолчанию и сообщений других арендаторов не получают.
+func (s *Server) publishMQTT(e Event) {
+	if e.Room != "" || e.Message.Tenant != "" {
+		return
+	}
+	payload, err := json.Marshal(e.Message)
+	if err != nil {
+		slog.Error("Ошибка кодирования сообщения для MQTT", "msg_id", e.Message.MsgID, "err", err)
+		return
+	}
+	err = s.mqttBroker.Publish(mqttBroadcastTopic, payload, false, 0)
+	if err != nil {
+		slog.Error("Ошибка публикации сообщения в MQTT", "msg_id", e.Message.MsgID, "err", err)
+	}
+}
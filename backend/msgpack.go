@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Подпротоколы WebSocket (Sec-WebSocket-Protocol), которыми клиент выбирает кодировку сообщений.
+const (
+	jsonSubprotocol    = "chat.json"
+	msgpackSubprotocol = "chat.msgpack"
+	// msgpackProtocol — прежнее имя подпротокола MessagePack, оставлено для старых клиентов.
+	msgpackProtocol = "msgpack"
+)
+
+// wsFrameOverhead — запас к MaxMessageBytes на JSON обертку сообщения при ограничении размера кадра.
+const wsFrameOverhead = 1024
+
+// Encoding — кодировка сообщений WebSocket клиента.
+type Encoding string
+
+// Поддерживаемые кодировки: JSON передается текстовыми кадрами, MessagePack — бинарными.
+// Имена полей MessagePack берутся из JSON тегов, поэтому схема сообщений совпадает с JSON.
+const (
+	EncodingJSON    Encoding = "json"
+	EncodingMsgPack Encoding = "msgpack"
+)
+
+// encodingForSubprotocol возвращает кодировку согласованного подпротокола; без подпротокола — JSON.
+func encodingForSubprotocol(subprotocol string) Encoding {
+	switch subprotocol {
+	case msgpackSubprotocol, msgpackProtocol:
+		return EncodingMsgPack
+	}
+	return EncodingJSON
+}
+
+// frameType возвращает тип кадра WebSocket, которым передаются сообщения в кодировке enc.
+func (enc Encoding) frameType() int {
+	if enc == EncodingMsgPack {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// serialize кодирует сообщение в кодировке enc.
+func serialize(msg Message, enc Encoding) ([]byte, error) {
+	if enc != EncodingMsgPack {
+		return json.Marshal(msg)
+	}
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+	err := encoder.Encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// deserialize декодирует сообщение в кодировке enc.
+func deserialize(data []byte, enc Encoding, msg *Message) error {
+	if enc != EncodingMsgPack {
+		return json.Unmarshal(data, msg)
+	}
+	decoder := msgpack.NewDecoder(bytes.NewReader(data))
+	decoder.SetCustomStructTag("json")
+	return decoder.Decode(msg)
+}
+
+// upgrader принимает WebSocket соединения со сжатием permessage-deflate.
+// gorilla/websocket выбирает подпротокол по порядку Subprotocols, а не по порядку клиента:
+// клиент, предложивший и chat.json, и chat.msgpack, получает chat.json.
+var upgrader = websocket.Upgrader{
+	Subprotocols:      []string{jsonSubprotocol, msgpackSubprotocol, msgpackProtocol},
+	EnableCompression: true,
+	// Origin проверяет checkOrigin до upgrade
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
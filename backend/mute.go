@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// mutedGlobal — пользователи (по арендатору и имени), которым администратор запретил писать
+	// по всем каналам: имя → время, когда запрет снимается. Нулевое время — бессрочный запрет (/mute).
+	mutedGlobal = make(map[tenantName]time.Time)
+	// mutedMutex для безопасного доступа к mutedGlobal.
+	mutedMutex = &sync.RWMutex{}
+)
+
+// muteRequest — тело POST /admin/users/{username}/mute.
+type muteRequest struct {
+	DurationMinutes int `json:"duration_minutes"`
+}
+
+// muteGlobally запрещает пользователю username арендатора tenant писать до until
+// (нулевое until — бессрочно). actor и remoteAddr — кто и откуда наложил запрет (для журнала аудита).
+func muteGlobally(tenant, username, actor, remoteAddr string, until time.Time) {
+	mutedMutex.Lock()
+	mutedGlobal[tenantName{tenant, username}] = until
+	mutedMutex.Unlock()
+
+	slog.Info("Пользователь лишен права писать в чат", "username", username, "tenant", tenant, "until", until)
+	auditLog.Record(auditUserMute, actor, username, remoteAddr, "tenant", tenant, "until", until)
+}
+
+// unmuteGlobally снимает запрет писать с пользователя username арендатора tenant.
+// Возвращает false, если запрета не было.
+func unmuteGlobally(tenant, username, actor, remoteAddr string) bool {
+	mutedMutex.Lock()
+	key := tenantName{tenant, username}
+	_, ok := mutedGlobal[key]
+	delete(mutedGlobal, key)
+	mutedMutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	slog.Info("Пользователю снова разрешено писать в чат", "username", username, "tenant", tenant)
+	auditLog.Record(auditUserUnmute, actor, username, remoteAddr, "tenant", tenant)
+	return true
+}
+
+// mutedUntil сообщает, запрещено ли пользователю username арендатора tenant писать, и до какого
+// времени (нулевое время — бессрочно). Истекший запрет снимается.
+func mutedUntil(tenant, username string) (time.Time, bool) {
+	key := tenantName{tenant, username}
+	mutedMutex.RLock()
+	until, ok := mutedGlobal[key]
+	mutedMutex.RUnlock()
+	if !ok {
+		return time.Time{}, false
+	}
+	if until.IsZero() || time.Now().Before(until) {
+		return until, true
+	}
+
+	mutedMutex.Lock()
+	// Запрет могли продлить, пока блокировка была снята
+	expired := mutedGlobal[key] == until
+	if expired {
+		delete(mutedGlobal, key)
+	}
+	mutedMutex.Unlock()
+	if expired {
+		slog.Info("Истек запрет писать в чат", "username", username, "tenant", tenant)
+		auditLog.Record(auditUserUnmute, "", username, "", "tenant", tenant, "reason", "expired")
+	}
+	return time.Time{}, false
+}
+
+// isMuted сообщает, запрещено ли пользователю username арендатора tenant писать в чат.
+func isMuted(tenant, username string) bool {
+	_, muted := mutedUntil(tenant, username)
+	return muted
+}
+
+// sendMuted сообщает клиенту, что писать ему запрещено до until: type:"error" с причиной code,
+// оставшимся временем запрета в тексте и его окончанием в muted_until. Нулевое until — бессрочный запрет.
+func sendMuted(client *Client, code, text string, until time.Time) {
+	msg := Message{Type: "error", Code: code, Text: text}
+	if !until.IsZero() {
+		until = until.UTC()
+		msg.Text += ", осталось " + time.Until(until).Round(time.Second).String()
+		msg.MutedUntil = &until
+	}
+	err := client.Send(msg)
+	if err != nil {
+		client.logger().Error("Ошибка отправки сообщения об ошибке", "err", err)
+	}
+}
+
+// handleUserMute запрещает пользователю арендатора из X-Tenant-ID писать по всем каналам
+// на duration_minutes минут (POST /admin/users/{username}/mute, тело {"duration_minutes":60}).
+func (s *Server) handleUserMute(w http.ResponseWriter, r *http.Request) {
+	var req muteRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "некорректный JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.DurationMinutes <= 0 {
+		http.Error(w, "duration_minutes должно быть положительным", http.StatusBadRequest)
+		return
+	}
+
+	username, tenant := r.PathValue("username"), requestTenant(r)
+	until := time.Now().Add(time.Duration(req.DurationMinutes) * time.Minute).UTC()
+	muteGlobally(tenant, username, auditAdminActor, r.RemoteAddr, until)
+	writeJSON(w, http.StatusOK, map[string]any{"username": username, "muted_until": until})
+}
+
+// handleUserUnmute снимает запрет писать с пользователя арендатора из X-Tenant-ID
+// (DELETE /admin/users/{username}/mute).
+func (s *Server) handleUserUnmute(w http.ResponseWriter, r *http.Request) {
+	if !unmuteGlobally(requestTenant(r), r.PathValue("username"), auditAdminActor, r.RemoteAddr) {
+		http.Error(w, "user is not muted", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
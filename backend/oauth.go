@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+// oauthStateCookie — cookie, в которой между /auth/login и /auth/callback хранится state,
+// защищающий callback от подделки запроса (CSRF).
+const oauthStateCookie = "oauth_state"
+
+// oauthStateTTL — сколько пользователь может пробыть на странице провайдера до возврата в callback.
+const oauthStateTTL = 10 * time.Minute
+
+// oauthProvider описывает провайдера OAuth2: адреса авторизации и профиля пользователя.
+type oauthProvider struct {
+	endpoint oauth2.Endpoint
+	scopes   []string
+	// profileURL возвращает JSON профиля текущего пользователя.
+	profileURL string
+	// username извлекает имя пользователя из профиля.
+	username func(profile map[string]any) string
+}
+
+// oauthProviders — поддерживаемые значения AUTH_PROVIDER.
+var oauthProviders = map[string]oauthProvider{
+	"github": {
+		endpoint:   endpoints.GitHub,
+		scopes:     []string{"read:user"},
+		profileURL: "https://api.github.com/user",
+		username: func(profile map[string]any) string {
+			login, _ := profile["login"].(string)
+			return login
+		},
+	},
+	"google": {
+		endpoint:   endpoints.Google,
+		scopes:     []string{"openid", "email"},
+		profileURL: "https://openidconnect.googleapis.com/v1/userinfo",
+		username: func(profile map[string]any) string {
+			email, _ := profile["email"].(string)
+			return email
+		},
+	},
+}
+
+// oauthConfig возвращает настройки OAuth2 клиента для провайдера AUTH_PROVIDER.
+func (s *Server) oauthConfig() (*oauth2.Config, oauthProvider) {
+	provider := oauthProviders[s.config.AuthProvider]
+	return &oauth2.Config{
+		ClientID:     s.config.OAuthClientID,
+		ClientSecret: s.config.OAuthClientSecret,
+		RedirectURL:  s.config.OAuthRedirectURL,
+		Endpoint:     provider.endpoint,
+		Scopes:       provider.scopes,
+	}, provider
+}
+
+// handleOAuthLogin перенаправляет пользователя на страницу входа провайдера (GET /auth/login).
+func (s *Server) handleOAuthLogin(w http.ResponseWriter, r *http.Request) {
+	state := make([]byte, 16)
+	rand.Read(state)
+	stateString := hex.EncodeToString(state)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    stateString,
+		Path:     "/auth",
+		MaxAge:   int(oauthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	oauthConfig, _ := s.oauthConfig()
+	http.Redirect(w, r, oauthConfig.AuthCodeURL(stateString), http.StatusFound)
+}
+
+// handleOAuthCallback обменивает код авторизации на токен провайдера, получает профиль
+// пользователя, выпускает короткоживущий JWT и перенаправляет на OAuthSuccessURL?token=<jwt>
+// (GET /auth/callback?code=X&state=Y). Дальше клиент подключается по обычному JWT.
+func (s *Server) handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(oauthStateCookie)
+	state := r.URL.Query().Get("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		slog.Warn("Отказ во входе через OAuth: неверный state", "remote_addr", r.RemoteAddr)
+		auditLog.Record(auditLoginFailed, "", "", r.RemoteAddr, "method", "oauth", "err", "неверный state")
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/auth", MaxAge: -1})
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "missing code", http.StatusBadRequest)
+		return
+	}
+
+	oauthConfig, provider := s.oauthConfig()
+	token, err := oauthConfig.Exchange(r.Context(), code)
+	if err != nil {
+		slog.Warn("Ошибка обмена кода OAuth на токен", "provider", s.config.AuthProvider, "remote_addr", r.RemoteAddr, "err", err)
+		auditLog.Record(auditLoginFailed, "", "", r.RemoteAddr, "method", "oauth", "err", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	username, err := fetchOAuthUsername(oauthConfig.Client(r.Context(), token), provider)
+	if err != nil {
+		slog.Warn("Ошибка получения профиля OAuth", "provider", s.config.AuthProvider, "remote_addr", r.RemoteAddr, "err", err)
+		auditLog.Record(auditLoginFailed, "", "", r.RemoteAddr, "method", "oauth", "err", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	claims := chatClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: username}}
+	signed, err := issueJWT(s.config.JWTSecret, claims, s.config.OAuthTokenTTL.Duration)
+	if err != nil {
+		slog.Error("Ошибка выпуска JWT", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Вход через OAuth", "provider", s.config.AuthProvider, "username", username, "remote_addr", r.RemoteAddr)
+	auditLog.Record(auditLogin, username, "", r.RemoteAddr, "method", "oauth", "provider", s.config.AuthProvider)
+
+	target, err := url.Parse(s.config.OAuthSuccessURL)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	query := target.Query()
+	query.Set("token", signed)
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// fetchOAuthUsername запрашивает профиль пользователя у провайдера и возвращает его имя.
+func fetchOAuthUsername(httpClient *http.Client, provider oauthProvider) (string, error) {
+	resp, err := httpClient.Get(provider.profileURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("профиль: статус %d", resp.StatusCode)
+	}
+
+	var profile map[string]any
+	err = json.NewDecoder(resp.Body).Decode(&profile)
+	if err != nil {
+		return "", fmt.Errorf("разбор профиля: %w", err)
+	}
+	username := provider.username(profile)
+	if username == "" {
+		return "", errors.New("в профиле нет имени пользователя")
+	}
+	return username, nil
+}
+
+// issueJWT выпускает токен HS256 с полями claims (sub, tenant, role, email), действующий ttl.
+// Случайный jti делает токен уникальным, даже если он выпущен в ту же секунду, что и прежний.
+func issueJWT(secret string, claims chatClaims, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims.ID = newMsgID()
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// originAllowed сообщает, разрешен ли Origin браузерного клиента.
+// Пустой список разрешенных источников в режиме разработки пропускает всех,
+// а в рабочем режиме — никого.
+func (s *Server) originAllowed(origin string) bool {
+	if len(s.config.AllowedOrigins) == 0 {
+		return !s.config.Production()
+	}
+	return slices.Contains(s.config.AllowedOrigins, strings.TrimSuffix(origin, "/"))
+}
+
+// checkOrigin отклоняет WebSocket upgrade с HTTP 403, если Origin не входит в ALLOWED_ORIGINS.
+// Это защищает от подключения с чужих сайтов от имени пользователя (cross-site WebSocket hijacking).
+func (s *Server) checkOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if !s.originAllowed(origin) {
+			slog.Warn("Отказ в WebSocket подключении: источник не разрешен", "remote_addr", r.RemoteAddr, "origin", origin)
+			http.Error(w, "forbidden origin", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cors добавляет CORS заголовки для разрешенных источников (тот же список ALLOWED_ORIGINS)
+// и сам отвечает на предварительные запросы OPTIONS.
+func (s *Server) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin != "" && s.originAllowed(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Admin-Token, X-API-Key, X-Tenant-ID")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"plugin"
+	"slices"
+)
+
+// Plugin — расширение сервера, загружаемое из .so файла каталога PLUGIN_DIR.
+//
+// Контракт ABI:
+//   - плагин собирается командой go build -buildmode=plugin тем же тулчейном Go,
+//     с теми же версиями зависимостей (go.sum) и флагами сборки, что и сервер;
+//     иначе plugin.Open вернет ошибку о несовпадении версий пакетов;
+//   - плагин экспортирует переменную Plugin, реализующую этот интерфейс
+//     (значение или указатель на него);
+//   - Init вызывается один раз при запуске до начала приема подключений; ошибка Init
+//     останавливает запуск сервера. Регистрировать обработчики можно только в Init;
+//   - Shutdown вызывается при остановке после отключения клиентов, в порядке,
+//     обратном загрузке;
+//   - стабильны только методы Server (AddMiddleware, RegisterCommand, On, OnAsync),
+//     типы Message и Event и константы Event*; остальное может меняться без предупреждения.
+//
+// Пакет main не может быть импортирован, поэтому плагин видит эти типы только
+// после их вынесения в отдельный пакет; до тех пор интерфейс фиксирует контракт.
+type Plugin interface {
+	Name() string
+	Init(server *Server) error
+	Shutdown() error
+}
+
+// pluginSymbol — имя переменной, которую экспортирует плагин.
+const pluginSymbol = "Plugin"
+
+// plugins — инициализированные плагины в порядке загрузки.
+var plugins []Plugin
+
+// RegisterCommand добавляет команду чата (см. RegisterCommand).
+func (s *Server) RegisterCommand(name string, handler CommandFunc) {
+	RegisterCommand(name, handler)
+}
+
+// On регистрирует синхронный обработчик события (см. EventBus.On).
+func (s *Server) On(eventType string, handler func(Event)) {
+	s.events.On(eventType, handler)
+}
+
+// OnAsync регистрирует асинхронный обработчик события (см. EventBus.OnAsync).
+func (s *Server) OnAsync(eventType string, handler func(Event)) {
+	s.events.OnAsync(eventType, handler)
+}
+
+// loadPlugins загружает и инициализирует все .so файлы каталога dir в алфавитном порядке.
+func (s *Server) loadPlugins(dir string) error {
+	// Glob не сообщает об отсутствующем каталоге, а опечатка в PLUGIN_DIR не должна оставаться незамеченной
+	_, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		p, err := openPlugin(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		err = p.Init(s)
+		if err != nil {
+			return fmt.Errorf("%s: инициализация плагина %s: %w", path, p.Name(), err)
+		}
+		plugins = append(plugins, p)
+		slog.Info("Загружен плагин", "name", p.Name(), "file", path)
+	}
+	return nil
+}
+
+// openPlugin открывает .so файл и находит в нем переменную Plugin.
+func openPlugin(path string) (Plugin, error) {
+	so, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := so.Lookup(pluginSymbol)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lookup возвращает указатель на переменную: *T, где T реализует Plugin, или *Plugin
+	switch p := sym.(type) {
+	case Plugin:
+		return p, nil
+	case *Plugin:
+		return *p, nil
+	default:
+		return nil, fmt.Errorf("символ %s типа %T не реализует Plugin", pluginSymbol, sym)
+	}
+}
+
+// shutdownPlugins останавливает плагины в порядке, обратном загрузке.
+func shutdownPlugins() {
+	for _, p := range slices.Backward(plugins) {
+		err := p.Shutdown()
+		if err != nil {
+			slog.Error("Ошибка остановки плагина", "name", p.Name(), "err", err)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pollWait — сколько GET /poll/{token} ждет новых сообщений.
+	pollWait = 30 * time.Second
+	// pollSessionTTL — через сколько удаляется сессия без запросов.
+	pollSessionTTL = 5 * time.Minute
+	// pollMaxPending — сколько недоставленных сообщений хранит сессия; старые вытесняются.
+	pollMaxPending = 256
+	// pollMaxSessions — сколько сессий long-polling может быть открыто одновременно.
+	pollMaxSessions = 10000
+)
+
+// pollSession — сессия long-polling клиента, накапливающая сообщения между запросами.
+type pollSession struct {
+	// tenant — арендатор сессии: в нее попадают только его сообщения.
+	tenant string
+	// username — пользователь, открывший сессию с аутентификацией; пустое значение — без нее.
+	username string
+	pending  []Message
+	// notify получает сигнал, когда в pending появились сообщения.
+	notify   chan struct{}
+	lastSeen time.Time
+}
+
+var (
+	// pollSessions хранит сессии long-polling по токену.
+	pollSessions = make(map[string]*pollSession)
+	// pollMutex для безопасного доступа к pollSessions и содержимому сессий.
+	pollMutex sync.Mutex
+)
+
+var errTooManyPollSessions = errors.New("слишком много сессий long-polling, попробуйте позже")
+
+// handlePollSend принимает сообщение от long-polling клиента и отправляет его в общий чат.
+// Имя отправителя берется из JWT, а если аутентификация отключена — из поля username.
+// Сообщение уходит арендатору запроса (см. requestTenant). Сообщение без текста и вложений
+// не рассылается, а открывает сессию: в ответе приходит токен для GET /poll/{token}.
+func (s *Server) handlePollSend(w http.ResponseWriter, r *http.Request) {
+	var msg Message
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, int64(s.config.MaxMessageBytes)*2)).Decode(&msg)
+	if err != nil {
+		http.Error(w, "некорректный JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if msg.Text == "" && len(msg.Attachments) == 0 {
+		username, _ := authenticatedUser(r.Context())
+		token, err := openPollSession(requestTenant(r), username)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"token": token})
+		return
+	}
+	if username, ok := authenticatedUser(r.Context()); ok {
+		msg.Username = username
+	}
+	msg.Username = strings.TrimSpace(msg.Username)
+	if msg.Username == "" {
+		http.Error(w, "не указано имя пользователя", http.StatusBadRequest)
+		return
+	}
+	if _, muted := mutedUntil(requestTenant(r), msg.Username); muted {
+		http.Error(w, "администратор запретил вам писать в чат", http.StatusForbidden)
+		return
+	}
+	if !s.senderLimits.wait(requestTenant(r), msg.Username) {
+		http.Error(w, "превышен лимит сообщений", http.StatusTooManyRequests)
+		return
+	}
+	if len(msg.Text) > s.config.MaxMessageBytes {
+		http.Error(w, fmt.Sprintf("сообщение слишком длинное: %d байт при лимите %d", len(msg.Text), s.config.MaxMessageBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	err = validateAttachments(s.config, msg.Attachments)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if _, found := wordFilter.Match(msg.Text); found {
+		http.Error(w, "сообщение содержит запрещенные слова и не отправлено", http.StatusUnprocessableEntity)
+		return
+	}
+
+	msg.Type = "message"
+	msg.MsgID = newMsgID()
+	msg.Sender = "poll:" + r.RemoteAddr
+	msg.Room = ""
+	msg.Recipient = ""
+	msg.Tenant = requestTenant(r)
+	msg.Priority = clientPriority(msg.Priority, isAdminRole(r.Context()))
+	msg.protocol = "poll"
+	msg.Source = ""
+	msg.SentAt = time.Now().UTC()
+
+	err = s.Broadcast(msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"msg_id": msg.MsgID})
+}
+
+// openPollSession открывает сессию long-polling арендатора tenant и возвращает ее токен.
+func openPollSession(tenant, username string) (string, error) {
+	token := make([]byte, 16)
+	rand.Read(token)
+	tokenString := hex.EncodeToString(token)
+
+	pollMutex.Lock()
+	defer pollMutex.Unlock()
+	if len(pollSessions) >= pollMaxSessions {
+		return "", errTooManyPollSessions
+	}
+	pollSessions[tokenString] = &pollSession{tenant: tenant, username: username, notify: make(chan struct{}, 1), lastSeen: time.Now()}
+	return tokenString, nil
+}
+
+// handlePollReceive ждет до pollWait новых сообщений для сессии token и возвращает их JSON массивом.
+// Сессию открывает POST /poll; сообщения копятся в ней до следующего запроса. Чужая сессия
+// (другого арендатора или пользователя) не отличается от несуществующей.
+func (s *Server) handlePollReceive(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	username, _ := authenticatedUser(r.Context())
+
+	pollMutex.Lock()
+	session, ok := pollSessions[token]
+	if !ok || session.tenant != requestTenant(r) || (session.username != "" && session.username != username) {
+		pollMutex.Unlock()
+		http.Error(w, "poll session not found", http.StatusNotFound)
+		return
+	}
+	session.lastSeen = time.Now()
+	messages := session.take()
+	pollMutex.Unlock()
+
+	if len(messages) == 0 {
+		timer := time.NewTimer(pollWait)
+		defer timer.Stop()
+
+		select {
+		case <-session.notify:
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		case <-s.stopping:
+		}
+
+		pollMutex.Lock()
+		session.lastSeen = time.Now()
+		messages = session.take()
+		pollMutex.Unlock()
+	}
+
+	writeJSON(w, http.StatusOK, messages)
+}
+
+// take забирает накопленные сообщения. Вызывающий должен удерживать pollMutex.
+func (s *pollSession) take() []Message {
+	messages := s.pending
+	s.pending = nil
+	if messages == nil {
+		messages = []Message{}
+	}
+	return messages
+}
+
+// broadcastPoll добавляет сообщение во все сессии long-polling его арендатора и будит ожидающие запросы.
+func broadcastPoll(msg Message) {
+	pollMutex.Lock()
+	defer pollMutex.Unlock()
+	for _, session := range pollSessions {
+		if session.tenant != msg.Tenant {
+			continue
+		}
+		if len(session.pending) >= pollMaxPending {
+			session.pending = session.pending[1:]
+		}
+		session.pending = append(session.pending, msg)
+		select {
+		case session.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// expirePollSessions раз в минуту удаляет сессии, к которым не обращались дольше pollSessionTTL.
+func (s *Server) expirePollSessions() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopping:
+			return
+		case <-ticker.C:
+		}
+
+		pollMutex.Lock()
+		for token, session := range pollSessions {
+			if time.Since(session.lastSeen) > pollSessionTTL {
+				delete(pollSessions, token)
+			}
+		}
+		pollMutex.Unlock()
+	}
+}
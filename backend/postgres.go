@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"embed"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresMigrations — схема базы PostgreSQL, применяется при запуске (см. migrate).
+//
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// postgresStore сохраняет сообщения чата в PostgreSQL.
+type postgresStore struct {
+	sqlStore
+	pool *pgxpool.Pool
+}
+
+// openPostgres подключается к PostgreSQL по dsn и применяет миграции.
+// Соединения берутся из пула pgxpool, database/sql работает поверх него.
+func openPostgres(dsn string) (MessageStore, error) {
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		return nil, err
+	}
+	db := stdlib.OpenDBFromPool(pool)
+
+	err = db.Ping()
+	if err == nil {
+		err = migrate(db, postgresMigrations, "migrations/postgres")
+	}
+	if err != nil {
+		db.Close()
+		pool.Close()
+		return nil, err
+	}
+	return &postgresStore{sqlStore: sqlStore{db: db}, pool: pool}, nil
+}
+
+func (s *postgresStore) Close() error {
+	err := s.db.Close()
+	s.pool.Close()
+	return err
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// PresenceEntry — состояние присутствия пользователя.
+type PresenceEntry struct {
+	// Status — "online", "away" или "offline".
+	Status   string    `json:"status"`
+	LastSeen time.Time `json:"lastSeen"`
+	ClientID string    `json:"clientID"`
+}
+
+// Presence хранит присутствие пользователей по арендатору и имени. Отключившиеся остаются со статусом "offline".
+type Presence struct {
+	entries map[tenantName]PresenceEntry
+	mutex   sync.RWMutex
+}
+
+func newPresence() *Presence {
+	return &Presence{entries: make(map[tenantName]PresenceEntry)}
+}
+
+// Set записывает статус пользователя username арендатора tenant, подключенного как клиент clientID.
+func (p *Presence) Set(tenant, username, status, clientID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.entries[tenantName{tenant, username}] = PresenceEntry{Status: status, LastSeen: time.Now().UTC(), ClientID: clientID}
+}
+
+// Forget помечает "offline" имя, которое пользователь больше не носит (после /nick).
+func (p *Presence) Forget(tenant, username string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	key := tenantName{tenant, username}
+	entry := p.entries[key]
+	entry.Status = "offline"
+	entry.LastSeen = time.Now().UTC()
+	p.entries[key] = entry
+}
+
+// Delete удаляет присутствие пользователя (при удалении его данных).
+func (p *Presence) Delete(tenant, username string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.entries, tenantName{tenant, username})
+}
+
+// Get возвращает присутствие пользователя username арендатора tenant (ok == false, если он не подключался).
+func (p *Presence) Get(tenant, username string) (entry PresenceEntry, ok bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	entry, ok = p.entries[tenantName{tenant, username}]
+	return entry, ok
+}
+
+// Status возвращает текущий статус пользователя username арендатора tenant.
+func (p *Presence) Status(tenant, username string) string {
+	entry, _ := p.Get(tenant, username)
+	return entry.Status
+}
+
+// Tenant возвращает копию присутствия всех известных пользователей арендатора tenant по имени.
+func (p *Presence) Tenant(tenant string) map[string]PresenceEntry {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	entries := make(map[string]PresenceEntry)
+	for key, entry := range p.entries {
+		if key.tenant == tenant {
+			entries[key.name] = entry
+		}
+	}
+	return entries
+}
+
+// idleAwayAfter — через сколько без сообщений от клиента он автоматически помечается "away".
+const idleAwayAfter = 10 * time.Minute
+
+// setPresence обновляет статус пользователя и рассылает клиентам его арендатора событие type:"presence".
+func setPresence(client *Client, status string) {
+	updatePresence(client, status)
+	client.server.notifyAll(presenceEvent(client, status), client)
+}
+
+// changeStatus обновляет статус пользователя ("online" или "away") и сообщает об этом
+// участникам его комнат. Событие рассылается, только если статус изменился.
+func changeStatus(client *Client, status string) {
+	if client.server.presence.Status(client.Tenant, client.Username) == status {
+		return
+	}
+	updatePresence(client, status)
+	client.server.notifyRooms(client, presenceEvent(client, status))
+}
+
+// updatePresence записывает статус пользователя в присутствие сервера.
+func updatePresence(client *Client, status string) {
+	client.server.presence.Set(client.Tenant, client.Username, status, client.ID)
+}
+
+// presenceEvent создает событие type:"presence" для клиента.
+func presenceEvent(client *Client, status string) Message {
+	return Message{Type: "presence", Username: client.Username, Sender: client.ID, Status: status, Tenant: client.Tenant}
+}
+
+// handleStatus обрабатывает сообщение type:"status" — клиент сам отмечает, что отошел или вернулся.
+func handleStatus(client *Client, status string) {
+	if status != "online" && status != "away" {
+		sendError(client, "статус должен быть \"online\" или \"away\"")
+		return
+	}
+	changeStatus(client, status)
+}
+
+// markIdleAway раз в минуту помечает "away" клиентов, от которых дольше idleAwayAfter
+// не было сообщений. Завершается при остановке сервера.
+func (s *Server) markIdleAway() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopping:
+			return
+		case <-ticker.C:
+		}
+
+		var idle []*Client
+		s.mutex.RLock()
+		for client := range s.clients {
+			if client.Username != "" && time.Since(client.lastActive()) > idleAwayAfter {
+				idle = append(idle, client)
+			}
+		}
+		s.mutex.RUnlock()
+		for _, client := range idle {
+			if s.presence.Status(client.Tenant, client.Username) == "online" {
+				changeStatus(client, "away")
+			}
+		}
+	}
+}
+
+// notifyRooms доставляет служебное событие участникам комнат клиента (кроме него самого),
+// а если клиент не состоит в комнатах — всем клиентам общего чата его арендатора.
+// Комнаты ищутся по их спискам участников: client.rooms доступна только горутине клиента.
+func (s *Server) notifyRooms(client *Client, msg Message) {
+	s.roomsMutex.Lock()
+	all := make([]*Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		all = append(all, room)
+	}
+	s.roomsMutex.Unlock()
+
+	inRoom := false
+	notified := map[*Client]bool{client: true}
+	for _, room := range all {
+		room.mutex.Lock()
+		if room.members[client] {
+			inRoom = true
+			for member := range room.members {
+				if notified[member] {
+					continue
+				}
+				notified[member] = true
+				if !member.deliver(msg) {
+					delete(room.members, member)
+				}
+			}
+		}
+		room.mutex.Unlock()
+	}
+	if !inRoom {
+		s.notifyAll(msg, client)
+	}
+}
+
+// notifyAll доставляет служебное событие всем клиентам арендатора msg.Tenant, кроме except,
+// минуя историю и журнал.
+func (s *Server) notifyAll(msg Message, except *Client) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for client := range s.clients {
+		if client != except && client.Tenant == msg.Tenant {
+			s.sendLocked(client, msg)
+		}
+	}
+}
+
+// handlePresence возвращает присутствие всех известных пользователей арендатора запроса (GET /presence).
+func (s *Server) handlePresence(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.presence.Tenant(requestTenant(r)))
+}
@@ -0,0 +1,142 @@
+package main
+
+import "sync"
+
+// Приоритеты сообщений (Message.Priority): из очереди рассылки первыми уходят сообщения
+// с большим приоритетом (см. messageQueue).
+const (
+	priorityNormal uint8 = 0
+	// priorityHigh могут задавать только клиенты с ролью admin в JWT.
+	priorityHigh uint8 = 1
+	// prioritySystem — системные сообщения сервера (вход и выход пользователей).
+	prioritySystem uint8 = 2
+)
+
+// clientPriority возвращает приоритет, с которым рассылается сообщение клиента, запросившего
+// requested: обычные клиенты отправляют только priorityNormal, администраторы — до priorityHigh.
+func clientPriority(requested uint8, admin bool) uint8 {
+	if !admin {
+		return priorityNormal
+	}
+	return min(requested, priorityHigh)
+}
+
+// senderQueueSize — сколько сообщений одного отправителя может ждать в очереди рассылки.
+// Сообщения сверх лимита отбрасываются, чтобы частый отправитель не занял всю очередь.
+// Сообщения самого сервера (пустой Sender) лимитом не ограничены.
+const senderQueueSize = 32
+
+// messageQueue — очередь рассылки емкостью capacity (Config.BroadcastBuffer). Первыми уходят
+// сообщения с большим приоритетом, а внутри приоритета у каждого отправителя своя небольшая
+// очередь, и отправители обслуживаются по кругу: сотня сообщений одного клиента не задерживает
+// единственное сообщение другого. Отправители не ждут, пока handleMessages разошлет предыдущие
+// сообщения; сам handleMessages ждет новых сообщений на условной переменной.
+type messageQueue struct {
+	mutex    sync.Mutex
+	ready    *sync.Cond
+	levels   [prioritySystem + 1]fairQueue
+	len      int
+	capacity int
+	// closed выставляется при остановке сервера: Push больше не принимает сообщения.
+	closed bool
+}
+
+// fairQueue — сообщения одного приоритета по отправителям.
+type fairQueue struct {
+	bySender map[string]*senderQueue
+	// active — отправители с сообщениями в порядке обслуживания по кругу.
+	active []*senderQueue
+}
+
+// senderQueue — сообщения одного отправителя в порядке поступления.
+type senderQueue struct {
+	sender   string
+	messages []Message
+}
+
+// newMessageQueue создает очередь рассылки на capacity сообщений.
+func newMessageQueue(capacity int) *messageQueue {
+	q := &messageQueue{capacity: capacity}
+	q.ready = sync.NewCond(&q.mutex)
+	for i := range q.levels {
+		q.levels[i].bySender = make(map[string]*senderQueue)
+	}
+	return q
+}
+
+// Push ставит сообщение в очередь, не блокируясь. Возвращает errShuttingDown после Close
+// и errBroadcastFull, если заполнена вся очередь или очередь отправителя.
+func (q *messageQueue) Push(msg Message) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return errShuttingDown
+	}
+	if q.len >= q.capacity {
+		return errBroadcastFull
+	}
+	level := &q.levels[min(msg.Priority, prioritySystem)]
+	queue := level.bySender[msg.Sender]
+	if queue == nil {
+		queue = &senderQueue{sender: msg.Sender}
+		level.bySender[msg.Sender] = queue
+		level.active = append(level.active, queue)
+	} else if msg.Sender != "" && len(queue.messages) >= senderQueueSize {
+		return errBroadcastFull
+	}
+	queue.messages = append(queue.messages, msg)
+	q.len++
+	q.ready.Signal()
+	return nil
+}
+
+// Pop ждет и возвращает следующее сообщение: из самого приоритетного непустого уровня,
+// от очередного по кругу отправителя. После Close отдает оставшиеся сообщения,
+// а когда очередь опустеет, возвращает false.
+func (q *messageQueue) Pop() (Message, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for q.len == 0 && !q.closed {
+		q.ready.Wait()
+	}
+	for i := len(q.levels) - 1; i >= 0; i-- {
+		level := &q.levels[i]
+		if len(level.active) == 0 {
+			continue
+		}
+		queue := level.active[0]
+		msg := queue.messages[0]
+		queue.messages[0] = Message{}
+		queue.messages = queue.messages[1:]
+		level.active = level.active[1:]
+		if len(queue.messages) > 0 {
+			// Отправитель встает в конец круга
+			level.active = append(level.active, queue)
+		} else {
+			delete(level.bySender, queue.sender)
+		}
+		q.len--
+		return msg, true
+	}
+	return Message{}, false
+}
+
+// Close перестает принимать сообщения и будит ожидающий Pop.
+func (q *messageQueue) Close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.closed = true
+	q.ready.Broadcast()
+}
+
+// Len возвращает число сообщений в очереди.
+func (q *messageQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.len
+}
+
+// Cap возвращает емкость очереди.
+func (q *messageQueue) Cap() int {
+	return q.capacity
+}
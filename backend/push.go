@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/oauth2/jwt"
+)
+
+// fcmScope — право сервисного аккаунта отправлять уведомления FCM.
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// fcmTimeout — сколько ждать ответа FCM на одно уведомление.
+const fcmTimeout = 10 * time.Second
+
+// pushBodyLimit — сколько символов текста сообщения попадает в уведомление.
+const pushBodyLimit = 200
+
+// maxDeviceTokenBytes — наибольшая длина токена устройства в POST /devices.
+const maxDeviceTokenBytes = 4096
+
+// errDeviceUnregistered — FCM больше не знает токен: приложение удалено или токен обновлен.
+var errDeviceUnregistered = errors.New("токен устройства не зарегистрирован в FCM")
+
+var pushNotificationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "chat_push_notifications_total",
+	Help: "FCM push notifications for direct messages to offline users, by result.",
+}, []string{"result"})
+
+// fcmServiceAccount — поля JSON ключа сервисного аккаунта Google, нужные для FCM HTTP v1.
+type fcmServiceAccount struct {
+	ProjectID    string `json:"project_id"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// FCMClient отправляет push уведомления через FCM HTTP v1 API от имени сервисного аккаунта.
+type FCMClient struct {
+	projectID string
+	// http добавляет к запросам OAuth2 токен сервисного аккаунта и обновляет его.
+	http *http.Client
+	// endpoint — адрес messages:send проекта.
+	endpoint string
+}
+
+// pushNotifier — клиент FCM; nil, если FCM_SERVICE_ACCOUNT_JSON не задан.
+var pushNotifier *FCMClient
+
+// newFCMClient создает клиент FCM по ключу сервисного аккаунта: credentials — сам JSON
+// или путь к файлу с ним.
+func newFCMClient(credentials string) (*FCMClient, error) {
+	data := []byte(credentials)
+	if !strings.HasPrefix(strings.TrimSpace(credentials), "{") {
+		var err error
+		data, err = os.ReadFile(credentials)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var account fcmServiceAccount
+	err := json.Unmarshal(data, &account)
+	if err != nil {
+		return nil, fmt.Errorf("разбор ключа сервисного аккаунта: %w", err)
+	}
+	if account.ProjectID == "" || account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, errors.New("в ключе сервисного аккаунта нет project_id, client_email или private_key")
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	jwtConfig := &jwt.Config{
+		Email:        account.ClientEmail,
+		PrivateKey:   []byte(account.PrivateKey),
+		PrivateKeyID: account.PrivateKeyID,
+		TokenURL:     account.TokenURI,
+		Scopes:       []string{fcmScope},
+	}
+	httpClient := jwtConfig.Client(context.Background())
+	httpClient.Timeout = fcmTimeout
+	return &FCMClient{
+		projectID: account.ProjectID,
+		http:      httpClient,
+		endpoint:  "https://fcm.googleapis.com/v1/projects/" + account.ProjectID + "/messages:send",
+	}, nil
+}
+
+// fcmRequest — тело запроса messages:send.
+type fcmRequest struct {
+	Message fcmMessage `json:"message"`
+}
+
+type fcmMessage struct {
+	Token        string            `json:"token"`
+	Notification fcmNotification   `json:"notification"`
+	Data         map[string]string `json:"data"`
+}
+
+type fcmNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// Send отправляет на устройство token уведомление о личном сообщении msg.
+// Возвращает errDeviceUnregistered, если FCM больше не знает токен.
+func (c *FCMClient) Send(ctx context.Context, token string, msg Message) error {
+	body := msg.Text
+	if runes := []rune(body); len(runes) > pushBodyLimit {
+		body = string(runes[:pushBodyLimit]) + "…"
+	}
+	payload, err := json.Marshal(fcmRequest{Message: fcmMessage{
+		Token:        token,
+		Notification: fcmNotification{Title: msg.Username, Body: body},
+		Data:         map[string]string{"type": msg.Type, "msg_id": msg.MsgID, "username": msg.Username},
+	}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode == http.StatusNotFound || bytes.Contains(respBody, []byte("UNREGISTERED")) {
+		return errDeviceUnregistered
+	}
+	return fmt.Errorf("FCM: статус %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+}
+
+// notifyOffline уведомляет о личном сообщении получателя, который сейчас не подключен:
+// push уведомлениями на все его устройства, а если устройств нет — письмом (см. Mailer).
+// Если уведомить нечем, отправитель получает ошибку, иначе — копию сообщения как подтверждение отправки.
+func (s *Server) notifyOffline(msg Message) {
+	var tokens []string
+	if pushNotifier != nil {
+		var err error
+		tokens, err = messageStore.DeviceTokens(msg.Tenant, msg.Recipient)
+		if err != nil {
+			slog.Error("Ошибка чтения токенов устройств", "msg_id", msg.MsgID, "recipient", msg.Recipient, "err", err)
+		}
+	}
+	switch {
+	case len(tokens) > 0:
+		s.pushDirect(msg, tokens)
+	case mailer != nil:
+		mailer.Notify(s, msg)
+	default:
+		s.replyToSender(msg, Message{Type: "error", Text: "пользователь не найден: " + msg.Recipient})
+		return
+	}
+	s.replyToSender(msg, msg)
+}
+
+// pushDirect отправляет личное сообщение msg push уведомлениями на устройства tokens его получателя.
+// Токены, которые FCM больше не знает, удаляются.
+func (s *Server) pushDirect(msg Message, tokens []string) {
+	logger := slog.With("msg_id", msg.MsgID, "recipient", msg.Recipient)
+
+	ctx, cancel := context.WithTimeout(context.Background(), fcmTimeout*time.Duration(len(tokens)))
+	defer cancel()
+	for _, token := range tokens {
+		err := pushNotifier.Send(ctx, token, msg)
+		if errors.Is(err, errDeviceUnregistered) {
+			pushNotificationsTotal.WithLabelValues("unregistered").Inc()
+			logger.Info("Токен устройства больше не действует и удален")
+			err = messageStore.RemoveDeviceToken(token)
+			if err != nil {
+				logger.Error("Ошибка удаления токена устройства", "err", err)
+			}
+			continue
+		}
+		if err != nil {
+			pushNotificationsTotal.WithLabelValues("failed").Inc()
+			logger.Warn("Ошибка отправки push уведомления", "err", err)
+			continue
+		}
+		pushNotificationsTotal.WithLabelValues("sent").Inc()
+	}
+}
+
+// replyToSender отправляет reply отправителю личного сообщения msg, если он еще подключен.
+func (s *Server) replyToSender(msg, reply Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if sender := s.clientsByName[tenantName{msg.Tenant, msg.Username}]; sender != nil {
+		s.sendLocked(sender, reply)
+	}
+}
+
+// deviceRequest — тело POST /devices.
+type deviceRequest struct {
+	Token string `json:"token"`
+	// Username — имя пользователя, если аутентификация отключена; с JWT или API ключом берется из них.
+	Username string `json:"username"`
+}
+
+// handleRegisterDevice привязывает токен устройства FCM к пользователю (POST /devices),
+// чтобы он получал push уведомления о личных сообщениях, пока не подключен.
+func (s *Server) handleRegisterDevice(w http.ResponseWriter, r *http.Request) {
+	if messageStore == nil {
+		http.Error(w, "хранилище сообщений не настроено", http.StatusNotFound)
+		return
+	}
+	var req deviceRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*maxDeviceTokenBytes)).Decode(&req)
+	if err != nil {
+		http.Error(w, "некорректное тело запроса", http.StatusBadRequest)
+		return
+	}
+	if username, ok := authenticatedUser(r.Context()); ok {
+		req.Username = username
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Token == "" || len(req.Token) > maxDeviceTokenBytes || req.Username == "" {
+		http.Error(w, "нужны token и username", http.StatusBadRequest)
+		return
+	}
+
+	tenant := requestTenant(r)
+	err = messageStore.AddDeviceToken(tenant, req.Username, req.Token)
+	if err != nil {
+		slog.Error("Ошибка сохранения токена устройства", "username", req.Username, "err", err)
+		http.Error(w, "ошибка сохранения токена устройства", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Устройство зарегистрировано для push уведомлений", "username", req.Username, "tenant", tenant)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// senderLimitsSweep — как часто из senderLimits удаляются лимиты пользователей, давно ничего не отправлявших.
+const senderLimitsSweep = time.Minute
+
+// senderLimits ограничивает частоту сообщений отправителей без постоянного соединения
+// (gRPC Send, MQTT, POST /poll): лимит Config.RateLimit у каждого пользователя арендатора свой,
+// как у клиента WebSocket или TCP.
+type senderLimits struct {
+	mutex     sync.Mutex
+	limit     int
+	limiters  map[tenantName]*rate.Limiter
+	lastSweep time.Time
+}
+
+func newSenderLimits(limit int) *senderLimits {
+	return &senderLimits{limit: limit, limiters: make(map[tenantName]*rate.Limiter), lastSweep: time.Now()}
+}
+
+// wait ждет, пока пользователю username арендатора tenant снова можно отправлять сообщения.
+// Возвращает false, если ждать пришлось бы дольше rateLimitWait (см. Client.waitRateLimit).
+func (l *senderLimits) wait(tenant, username string) bool {
+	l.mutex.Lock()
+	now := time.Now()
+	if now.Sub(l.lastSweep) > senderLimitsSweep {
+		// Полный запас токенов — пользователь давно не писал, лимит можно создать заново
+		for key, limiter := range l.limiters {
+			if limiter.TokensAt(now) >= float64(limiter.Burst()) {
+				delete(l.limiters, key)
+			}
+		}
+		l.lastSweep = now
+	}
+	key := tenantName{tenant, username}
+	limiter, ok := l.limiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(l.limit), l.limit)
+		l.limiters[key] = limiter
+	}
+	l.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitWait)
+	defer cancel()
+	return limiter.Wait(ctx) == nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+var (
+	// reactions хранит реакции на сообщения: (арендатор, MsgID) → эмодзи → имена поставивших реакцию.
+	reactions = make(map[tenantName]map[string][]string)
+	// reactionsMutex для безопасного доступа к reactions.
+	reactionsMutex = &sync.Mutex{}
+)
+
+// loadReactions загружает сохраненные реакции из базы (если она настроена).
+func loadReactions() error {
+	if messageStore == nil {
+		return nil
+	}
+	saved, err := messageStore.Reactions()
+	if err != nil {
+		return err
+	}
+	reactionsMutex.Lock()
+	reactions = saved
+	reactionsMutex.Unlock()
+	return nil
+}
+
+// handleReaction обрабатывает сообщение type:"reaction": добавляет или убирает реакцию
+// пользователя и рассылает type:"reaction_update" с реакциями на сообщение целиком.
+func handleReaction(client *Client, msg Message) {
+	if msg.Action != "add" && msg.Action != "remove" {
+		sendError(client, "action должен быть \"add\" или \"remove\"")
+		return
+	}
+	if !slices.Contains(client.server.config.AllowedReactions, msg.Emoji) {
+		sendError(client, "недопустимая реакция: "+msg.Emoji)
+		return
+	}
+	var room *Room
+	if msg.Room != "" {
+		room = client.rooms[msg.Room]
+		if room == nil {
+			sendError(client, "вы не в комнате "+msg.Room)
+			return
+		}
+	}
+	if msg.MsgID == "" || !client.server.messageExists(client.Tenant, msg.MsgID) {
+		sendError(client, "сообщение не найдено: "+msg.MsgID)
+		return
+	}
+
+	update := Message{
+		Type:      "reaction_update",
+		MsgID:     msg.MsgID,
+		Room:      msg.Room,
+		Tenant:    client.Tenant,
+		Reactions: updateReaction(client.Tenant, msg.MsgID, msg.Emoji, client.Username, msg.Action == "add"),
+	}
+	if room != nil {
+		room.notify(update, nil)
+	} else {
+		client.server.notifyAll(update, nil)
+	}
+}
+
+// updateReaction добавляет или убирает реакцию пользователя арендатора tenant и возвращает
+// копию реакций на сообщение.
+func updateReaction(tenant, msgID, emoji, username string, add bool) map[string][]string {
+	reactionsMutex.Lock()
+	defer reactionsMutex.Unlock()
+
+	key := tenantName{tenant, msgID}
+	byEmoji := reactions[key]
+	if byEmoji == nil {
+		byEmoji = make(map[string][]string)
+		reactions[key] = byEmoji
+	}
+	users := byEmoji[emoji]
+	i := slices.Index(users, username)
+	switch {
+	case add && i < 0:
+		byEmoji[emoji] = append(users, username)
+		persistReaction(tenant, msgID, emoji, username, true)
+	case !add && i >= 0:
+		byEmoji[emoji] = slices.Delete(users, i, i+1)
+		if len(byEmoji[emoji]) == 0 {
+			delete(byEmoji, emoji)
+		}
+		persistReaction(tenant, msgID, emoji, username, false)
+	}
+	if len(byEmoji) == 0 {
+		delete(reactions, key)
+	}
+
+	result := make(map[string][]string, len(byEmoji))
+	for emoji, users := range byEmoji {
+		result[emoji] = slices.Clone(users)
+	}
+	return result
+}
+
+// persistReaction сохраняет изменение реакции в базу (если она настроена).
+func persistReaction(tenant, msgID, emoji, username string, add bool) {
+	if messageStore == nil {
+		return
+	}
+	var err error
+	if add {
+		err = messageStore.AddReaction(tenant, msgID, emoji, username)
+	} else {
+		err = messageStore.RemoveReaction(tenant, msgID, emoji, username)
+	}
+	if err != nil {
+		slog.Error("Ошибка сохранения реакции в базу", "msg_id", msgID, "err", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxReceiptMessages — для скольких последних сообщений хранятся отметки о прочтении.
+const maxReceiptMessages = 10000
+
+// receipt — отметка о прочтении сообщения клиентом (ответ GET /messages/{id}/receipts).
+type receipt struct {
+	ClientID string    `json:"clientID"`
+	Username string    `json:"username"`
+	ReadAt   time.Time `json:"readAt"`
+}
+
+// receiptEntry — отметки о прочтении одного сообщения.
+type receiptEntry struct {
+	receipts []receipt
+	// createdAt — время первой отметки; по нему отметки удаляются после MESSAGE_TTL_HOURS.
+	createdAt time.Time
+}
+
+// Receipts хранит в памяти отметки о прочтении для последних maxReceiptMessages сообщений.
+type Receipts struct {
+	// entries — отметки по (арендатор, MsgID): MsgID выбирает клиент, у разных арендаторов они могут совпадать.
+	entries map[tenantName]*receiptEntry
+	// order — ключи сообщений в порядке появления первой отметки, самые старые в начале.
+	order []tenantName
+	// ttl — срок хранения отметок (MESSAGE_TTL_HOURS), 0 — бессрочно.
+	ttl   time.Duration
+	mutex sync.Mutex
+}
+
+func newReceipts(ttl time.Duration) *Receipts {
+	return &Receipts{entries: make(map[tenantName]*receiptEntry), ttl: ttl}
+}
+
+// Record отмечает, что клиент прочитал сообщение msgID арендатора tenant. Повторные отметки игнорируются.
+func (r *Receipts) Record(tenant, msgID string, client *Client) {
+	now := time.Now().UTC()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.expireLocked(now)
+
+	key := tenantName{tenant, msgID}
+	entry, ok := r.entries[key]
+	if !ok {
+		if len(r.order) >= maxReceiptMessages {
+			delete(r.entries, r.order[0])
+			r.order = r.order[1:]
+		}
+		entry = &receiptEntry{createdAt: now}
+		r.entries[key] = entry
+		r.order = append(r.order, key)
+	}
+	for _, existing := range entry.receipts {
+		if existing.ClientID == client.ID {
+			return
+		}
+	}
+	entry.receipts = append(entry.receipts, receipt{ClientID: client.ID, Username: client.Username, ReadAt: now})
+}
+
+// List возвращает копию отметок о прочтении сообщения msgID арендатора tenant.
+func (r *Receipts) List(tenant, msgID string) []receipt {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.expireLocked(time.Now().UTC())
+
+	entry, ok := r.entries[tenantName{tenant, msgID}]
+	if !ok {
+		return []receipt{}
+	}
+	return append([]receipt(nil), entry.receipts...)
+}
+
+// RemoveUser удаляет отметки о прочтении пользователя username арендатора tenant.
+func (r *Receipts) RemoveUser(tenant, username string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for key, entry := range r.entries {
+		if key.tenant != tenant {
+			continue
+		}
+		entry.receipts = slices.DeleteFunc(entry.receipts, func(rc receipt) bool { return rc.Username == username })
+		if len(entry.receipts) == 0 {
+			delete(r.entries, key)
+		}
+	}
+	r.order = slices.DeleteFunc(r.order, func(key tenantName) bool { return r.entries[key] == nil })
+}
+
+// expireLocked удаляет отметки старше MESSAGE_TTL_HOURS. Вызывающий должен удерживать mutex.
+func (r *Receipts) expireLocked(now time.Time) {
+	if r.ttl == 0 {
+		return
+	}
+	for len(r.order) > 0 && now.Sub(r.entries[r.order[0]].createdAt) > r.ttl {
+		delete(r.entries, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// handleReceipts возвращает отметки о прочтении сообщения арендатора запроса (GET /messages/{id}/receipts).
+func (s *Server) handleReceipts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.receipts.List(requestTenant(r), r.PathValue("id")))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisChannel — канал Redis, через который экземпляры сервера обмениваются сообщениями рассылки.
+const redisChannel = "chat:broadcast"
+
+// redisTimeout ограничивает подключение к Redis и публикацию одного сообщения.
+const redisTimeout = 2 * time.Second
+
+// redisClient — подключение к Redis. nil означает, что сервер работает только локально.
+var redisClient *redis.Client
+
+// instanceID отличает этот экземпляр сервера от остальных, чтобы не получать обратно свои сообщения.
+var instanceID = newMsgID()
+
+// redisEnvelope — сообщение рассылки вместе с ID экземпляра, который его опубликовал.
+type redisEnvelope struct {
+	Instance string  `json:"instance"`
+	Message  Message `json:"message"`
+}
+
+// connectRedis подключается к Redis по url. Если Redis недоступен, сервер продолжает
+// работать без него: клиенты разных экземпляров просто не видят сообщений друг друга.
+func connectRedis(url string) *redis.Client {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		slog.Warn("Некорректный REDIS_URL, сервер работает без Redis", "err", err)
+		return nil
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	err = client.Ping(ctx).Err()
+	if err != nil {
+		slog.Warn("Redis недоступен, сервер работает без Redis", "addr", opts.Addr, "err", err)
+		client.Close()
+		return nil
+	}
+	slog.Info("Подключен Redis, рассылка общая для всех экземпляров", "addr", opts.Addr, "instance", instanceID)
+	return client
+}
+
+// publishRedis отправляет сообщение рассылки общего чата или комнаты (Message.Room) остальным экземплярам сервера.
+func publishRedis(msg Message) {
+	if redisClient == nil || msg.remote {
+		return
+	}
+
+	data, err := json.Marshal(redisEnvelope{Instance: instanceID, Message: msg})
+	if err != nil {
+		slog.Error("Ошибка сериализации сообщения для Redis", "msg_id", msg.MsgID, "err", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	err = redisClient.Publish(ctx, redisChannel, data).Err()
+	if err != nil {
+		slog.Warn("Ошибка публикации сообщения в Redis", "msg_id", msg.MsgID, "err", err)
+	}
+}
+
+// subscribeRedis передает в локальную рассылку сообщения, опубликованные другими экземплярами:
+// сообщения комнат — в рассылку комнаты, остальные — в общую. Завершается, когда отменен ctx.
+func (s *Server) subscribeRedis(ctx context.Context) {
+	sub := redisClient.Subscribe(ctx, redisChannel)
+	defer sub.Close()
+
+	for {
+		m, err := sub.ReceiveMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// go-redis сам переподключается при следующем чтении
+			slog.Warn("Ошибка чтения из Redis", "err", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		var envelope redisEnvelope
+		err = json.Unmarshal([]byte(m.Payload), &envelope)
+		if err != nil {
+			slog.Warn("Некорректное сообщение из Redis", "err", err)
+			continue
+		}
+		if envelope.Instance == instanceID {
+			continue
+		}
+
+		msg := envelope.Message
+		msg.remote = true
+		if msg.Room != "" {
+			// Если комнаты нет на этом экземпляре, нет в ней и его клиентов
+			room := s.findRoom(msg.Tenant, msg.Room)
+			if room != nil {
+				room.post(msg)
+			}
+			continue
+		}
+		err = s.Broadcast(msg)
+		if err != nil {
+			slog.Warn("Сообщение из Redis не разослано", "msg_id", msg.MsgID, "err", err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// reorderMaxSenders — сколько последовательностей отправителей помнит буфер одного получателя. Когда их больше,
+// забываются последовательности без задержанных сообщений: их следующее сообщение считается идущим по порядку.
+const reorderMaxSenders = 1000
+
+// reorderBuffer восстанавливает порядок сообщений каждого отправителя в каждое место назначения
+// по SeqNum перед постановкой в очередь получателя. Сообщение, пришедшее раньше предыдущих, ждет их не дольше
+// window; после этого оно отправляется, а пропущенные номера больше не ждут. Пропуск может и не заполниться
+// (сообщение отклонил обработчик или отбросила переполненная очередь), поэтому порядок соблюдается
+// в меру возможного, без гарантий.
+type reorderBuffer struct {
+	client *Client
+	window time.Duration
+	mutex  sync.Mutex
+	// senders — состояние порядка по отправителю и месту назначения.
+	senders map[seqKey]*senderOrder
+}
+
+// seqKey — последовательность номеров SeqNum: ID отправителя и место назначения его сообщений.
+type seqKey struct {
+	sender      string
+	destination string
+}
+
+// seqDestination возвращает место назначения сообщения, внутри которого нумеруются сообщения
+// отправителя: тема, комната, получатель личного сообщения или общий чат (""). Номера у каждого
+// места назначения свои: иначе получатель, который видит только часть мест, ждал бы пропуски,
+// которые никогда не заполнятся.
+func seqDestination(msg Message) string {
+	switch {
+	case msg.Topic != "":
+		return "topic:" + msg.Topic
+	case msg.Room != "":
+		return "room:" + msg.Room
+	case msg.Recipient != "":
+		return "dm:" + msg.Recipient
+	}
+	return ""
+}
+
+// senderOrder — порядок сообщений одной последовательности у получателя.
+type senderOrder struct {
+	// last — SeqNum последнего сообщения, поставленного в очередь.
+	last uint64
+	// pending — задержанные сообщения по возрастанию SeqNum.
+	pending []Message
+}
+
+// newReorderBuffer создает буфер порядка для клиента c. При window == 0 возвращает nil.
+func newReorderBuffer(c *Client, window time.Duration) *reorderBuffer {
+	if window <= 0 {
+		return nil
+	}
+	return &reorderBuffer{client: c, window: window, senders: make(map[seqKey]*senderOrder)}
+}
+
+// push ставит msg в очередь клиента сразу или задерживает до прихода предыдущих сообщений отправителя.
+// Возвращает false, если клиент отключен как медленный (см. deliver).
+func (b *reorderBuffer) push(msg Message) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	key := seqKey{msg.Sender, seqDestination(msg)}
+	order := b.senders[key]
+	if order == nil {
+		if len(b.senders) >= reorderMaxSenders {
+			maps.DeleteFunc(b.senders, func(_ seqKey, o *senderOrder) bool { return len(o.pending) == 0 })
+		}
+		// Первое сообщение последовательности, которое видит получатель, считается идущим по порядку
+		order = &senderOrder{last: msg.SeqNum - 1}
+		b.senders[key] = order
+	}
+
+	if msg.SeqNum > order.last+1 {
+		i, _ := slices.BinarySearchFunc(order.pending, msg.SeqNum, func(m Message, seq uint64) int {
+			return cmp.Compare(m.SeqNum, seq)
+		})
+		order.pending = slices.Insert(order.pending, i, msg)
+		time.AfterFunc(b.window, func() { b.expire(key, msg.SeqNum) })
+		return true
+	}
+	return b.release(order, msg)
+}
+
+// expire отправляет задержанные сообщения последовательности key с номерами до seq включительно,
+// не дожидаясь пропущенных.
+func (b *reorderBuffer) expire(key seqKey, seq uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	order := b.senders[key]
+	if order == nil {
+		return
+	}
+	for len(order.pending) > 0 && order.pending[0].SeqNum <= seq {
+		msg := order.pending[0]
+		order.pending = order.pending[1:]
+		b.release(order, msg)
+	}
+}
+
+// release ставит msg в очередь клиента, а за ним — задержанные сообщения, которые стали следующими
+// по порядку. Вызывающий должен удерживать mutex.
+func (b *reorderBuffer) release(order *senderOrder, msg Message) bool {
+	connected := b.client.enqueue(msg)
+	order.last = max(order.last, msg.SeqNum)
+	for len(order.pending) > 0 && order.pending[0].SeqNum <= order.last+1 {
+		next := order.pending[0]
+		order.pending = order.pending[1:]
+		connected = b.client.enqueue(next) && connected
+		order.last = max(order.last, next.SeqNum)
+	}
+	return connected
+}
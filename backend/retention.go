@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// purgeInterval — как часто удалять из базы сообщения старше MESSAGE_TTL_HOURS.
+const purgeInterval = time.Hour
+
+var messagesPurgedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "chat_messages_purged_total",
+	Help: "Messages deleted from the message store after MESSAGE_TTL_HOURS.",
+})
+
+// purgeExpiredMessages раз в purgeInterval удаляет из базы сообщения старше ttl.
+// Завершается при остановке сервера.
+func (s *Server) purgeExpiredMessages(ttl time.Duration) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := messageStore.Purge(time.Now().UTC().Add(-ttl))
+		if err != nil {
+			slog.Error("Ошибка удаления устаревших сообщений", "err", err)
+		} else {
+			messagesPurgedTotal.Add(float64(purged))
+			slog.Info("Удалены устаревшие сообщения", "count", purged, "ttl", ttl)
+			if purged > 0 {
+				// Номера сообщений в комнатах сдвинулись — кэш заполнится из базы заново
+				roomCache.Reset()
+			}
+		}
+
+		select {
+		case <-s.stopping:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// unexpired убирает из messages сообщения старше MESSAGE_TTL_HOURS (если срок задан).
+func (s *Server) unexpired(messages []Message) []Message {
+	ttl := s.config.MessageTTL()
+	if ttl == 0 {
+		return messages
+	}
+	cutoff := time.Now().Add(-ttl)
+	result := messages[:0]
+	for _, msg := range messages {
+		if !msg.SentAt.Before(cutoff) {
+			result = append(result, msg)
+		}
+	}
+	return result
+}
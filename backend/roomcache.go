@@ -0,0 +1,178 @@
+package main
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	cacheHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_cache_hit_total",
+		Help: "GET /messages pages served from the room cache.",
+	})
+	cacheMissesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_cache_miss_total",
+		Help: "GET /messages pages that fell through to the message store.",
+	})
+)
+
+// roomCacheEntry — последние сообщения одной комнаты.
+type roomCacheEntry struct {
+	recent *History
+	// total — сколько всего сообщений в комнате, включая вытесненные из recent.
+	total int
+}
+
+// window возвращает сообщения с номерами [offset, offset+limit), которые есть в recent.
+// hit == false, если часть окна старше recent и ее нужно читать из базы.
+func (e *roomCacheEntry) window(offset, limit int) (items []Message, hit bool) {
+	recent := e.recent.Messages()
+	first := e.total - len(recent)
+	from := max(offset, first) - first
+	to := min(offset+limit, e.total) - first
+	if from >= to {
+		return []Message{}, offset >= first
+	}
+	return recent[from:to], offset >= first
+}
+
+// RoomCache хранит в памяти последние сообщения каждой комнаты (общий чат — комната "")
+// каждого арендатора, чтобы GET /messages не обращался к базе за свежими сообщениями.
+type RoomCache struct {
+	size  int
+	rooms map[tenantName]*roomCacheEntry
+	mutex sync.RWMutex
+}
+
+// roomCache — кэш сообщений комнат. Создается в main.
+var roomCache *RoomCache
+
+// newRoomCache создает кэш на size последних сообщений в каждой комнате.
+func newRoomCache(size int) *RoomCache {
+	return &RoomCache{size: size, rooms: make(map[tenantName]*roomCacheEntry)}
+}
+
+// Record сохраняет сообщение в базу и добавляет его в кэш комнаты. Личные и системные сообщения
+// пропускаются: системные сообщения о входе и выходе — не переписка.
+// В базу сообщение записывается до блокировки, чтобы запросы к кэшу не ждали базу.
+// Если кэш комнаты успел заполниться из базы (см. load) уже с этим сообщением, повторно оно не добавляется.
+func (c *RoomCache) Record(msg Message) {
+	if msg.Recipient != "" || msg.Type == "system" {
+		return
+	}
+	storeMessage(msg)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := tenantName{msg.Tenant, msg.Room}
+	entry, ok := c.rooms[key]
+	if !ok {
+		if messageStore != nil {
+			// Старые сообщения комнаты есть только в базе — кэш заполнится при первом запросе
+			return
+		}
+		entry = &roomCacheEntry{recent: newHistory(c.size)}
+		c.rooms[key] = entry
+	}
+	if messageStore != nil && slices.ContainsFunc(entry.recent.Messages(), func(m Message) bool { return m.MsgID == msg.MsgID }) {
+		return
+	}
+	entry.recent.Add(msg)
+	entry.total++
+}
+
+// Page возвращает страницу сообщений комнаты арендатора tenant и общее число сообщений в ней.
+// Свежие сообщения берутся из кэша, более старые — из базы (если она настроена).
+func (c *RoomCache) Page(tenant, room string, offset, limit int) ([]Message, int, error) {
+	c.mutex.RLock()
+	entry, ok := c.rooms[tenantName{tenant, room}]
+	if !ok && messageStore == nil {
+		// В комнате еще не было сообщений
+		c.mutex.RUnlock()
+		cacheHitsTotal.Inc()
+		return []Message{}, 0, nil
+	}
+	if ok {
+		items, hit := entry.window(offset, limit)
+		total := entry.total
+		if hit || messageStore == nil {
+			c.mutex.RUnlock()
+			cacheHitsTotal.Inc()
+			return items, total, nil
+		}
+	}
+	c.mutex.RUnlock()
+
+	cacheMissesTotal.Inc()
+	total, err := c.load(tenant, room)
+	if err != nil {
+		return nil, 0, err
+	}
+	items, err := messageStore.Query(tenant, room, offset, limit)
+	if items == nil {
+		items = []Message{}
+	}
+	return items, total, err
+}
+
+// Contains сообщает, есть ли в кэше сообщение арендатора tenant с указанным MsgID.
+func (c *RoomCache) Contains(tenant, msgID string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for key, entry := range c.rooms {
+		if key.tenant == tenant && slices.ContainsFunc(entry.recent.Messages(), func(msg Message) bool { return msg.MsgID == msgID }) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveUser удаляет из кэша все сообщения пользователя username арендатора tenant.
+func (c *RoomCache) RemoveUser(tenant, username string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, entry := range c.rooms {
+		if key.tenant != tenant {
+			continue
+		}
+		before := len(entry.recent.Messages())
+		entry.recent.RemoveUser(tenant, username)
+		entry.total -= before - len(entry.recent.Messages())
+	}
+}
+
+// Reset очищает кэш; комнаты заново заполнятся из базы при следующем запросе.
+func (c *RoomCache) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	clear(c.rooms)
+}
+
+// load заполняет кэш комнаты последними сообщениями из базы, если он еще пуст,
+// и возвращает общее число сообщений в комнате.
+func (c *RoomCache) load(tenant, room string) (int, error) {
+	key := tenantName{tenant, room}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry, ok := c.rooms[key]; ok {
+		return entry.total, nil
+	}
+
+	total, err := messageStore.Count(tenant, room)
+	if err != nil {
+		return 0, err
+	}
+	recent, err := messageStore.Query(tenant, room, max(total-c.size, 0), c.size)
+	if err != nil {
+		return 0, err
+	}
+	entry := &roomCacheEntry{recent: newHistory(c.size), total: total}
+	for _, msg := range recent {
+		entry.recent.Add(msg)
+	}
+	c.rooms[key] = entry
+	return total, nil
+}
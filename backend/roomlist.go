@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// roomInfo — описание комнаты в списке GET /rooms, в GET /rooms/{name} и в type:"room_update".
+type roomInfo struct {
+	Name          string    `json:"name"`
+	Topic         string    `json:"topic"`
+	Description   string    `json:"description"`
+	MemberCount   int       `json:"member_count"`
+	CreatedAt     time.Time `json:"created_at"`
+	IsInviteOnly  bool      `json:"is_invite_only"`
+	HasPassword   bool      `json:"has_password"`
+	OwnerUsername string    `json:"owner_username"`
+}
+
+// info возвращает описание комнаты для списка комнат.
+func (r *Room) info() roomInfo {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	info := roomInfo{
+		Name:         r.Name,
+		Topic:        r.Topic,
+		Description:  r.Description,
+		MemberCount:  len(r.members),
+		CreatedAt:    r.CreatedAt,
+		IsInviteOnly: r.Invite,
+		HasPassword:  r.PasswordHash != "",
+	}
+	for username, role := range r.Roles {
+		if role == roleOwner {
+			info.OwnerUsername = username
+		}
+	}
+	return info
+}
+
+// visibleTo сообщает, может ли пользователь username видеть сообщения комнаты: сообщения
+// комнаты только по приглашениям видят ее участники, приглашенные, владелец и модераторы.
+func (r *Room) visibleTo(username string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.Invite {
+		return true
+	}
+	if username == "" {
+		return false
+	}
+	if _, ok := r.invited[username]; ok || r.Roles[username].rank() > roleMember.rank() {
+		return true
+	}
+	for member := range r.members {
+		if member.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+// handleRooms возвращает комнаты арендатора запроса по имени (GET /rooms?page=1&limit=50&search=X).
+// search оставляет комнаты, в имени или теме которых есть подстрока (без учета регистра);
+// page — номер страницы, начиная с 1. Комнаты только по приглашениям в списке есть,
+// но их участники и сообщения видны лишь участникам.
+func (s *Server) handleRooms(w http.ResponseWriter, r *http.Request) {
+	page, err := queryInt(r, "page", 1)
+	if err == nil && page == 0 {
+		err = errors.New("некорректный параметр page: страницы нумеруются с 1")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultPageLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit = min(limit, maxPageLimit)
+	tenant, search := requestTenant(r), strings.ToLower(r.URL.Query().Get("search"))
+
+	s.roomsMutex.Lock()
+	rooms := make([]*Room, 0, len(s.rooms))
+	for key, room := range s.rooms {
+		if key.tenant == tenant {
+			rooms = append(rooms, room)
+		}
+	}
+	s.roomsMutex.Unlock()
+
+	infos := make([]roomInfo, 0, len(rooms))
+	for _, room := range rooms {
+		info := room.info()
+		if search != "" && !strings.Contains(strings.ToLower(info.Name), search) && !strings.Contains(strings.ToLower(info.Topic), search) {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a, b roomInfo) int { return strings.Compare(a.Name, b.Name) })
+	writeJSON(w, http.StatusOK, paginate(infos, (page-1)*limit, limit))
+}
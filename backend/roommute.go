@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// defaultRoomMute — на сколько /mute запрещает писать в комнату, если длительность не указана.
+const defaultRoomMute = time.Hour
+
+var roomMutesTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "chat_room_mutes_total",
+	Help: "Users muted in a room by its moderators.",
+})
+
+// commandRoomMute запрещает пользователю писать в комнату: /mute <имя> <комната> [длительность].
+// Длительность — в формате time.ParseDuration ("30m", "2h"), по умолчанию defaultRoomMute.
+// Сообщения комнаты пользователь по-прежнему получает.
+func commandRoomMute(client *Client, args []string) error {
+	if len(args) > 3 {
+		return errors.New("использование: /mute <имя> <комната> [длительность]")
+	}
+	username := args[0]
+	room, err := commandRoom(client, args[1], roleModerator)
+	if err != nil {
+		return err
+	}
+	duration := defaultRoomMute
+	if len(args) == 3 {
+		duration, err = time.ParseDuration(args[2])
+		if err != nil || duration <= 0 {
+			return errors.New("некорректная длительность: " + args[2] + ", например 30m или 2h")
+		}
+	}
+	err = checkModerationTarget(client, room, username)
+	if err != nil {
+		return err
+	}
+
+	until := time.Now().Add(duration)
+	room.mutex.Lock()
+	room.MutedUsers[username] = until
+	room.mutex.Unlock()
+	roomMutesTotal.Inc()
+	client.logger().Info("Пользователь лишен права писать в комнату", "room", room.Name, "username", username, "until", until)
+	return reply(client, "пользователь "+username+" не может писать в комнату "+room.Name+" "+duration.String())
+}
+
+// muteRemaining сообщает, запрещено ли пользователю username писать в комнату, и до какого времени.
+// Истекший запрет снимается.
+func (r *Room) muteRemaining(username string) (time.Time, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	until, ok := r.MutedUsers[username]
+	if !ok {
+		return time.Time{}, false
+	}
+	if !time.Now().Before(until) {
+		delete(r.MutedUsers, username)
+		slog.Info("Истек запрет писать в комнату", "room", r.Name, "tenant", r.Tenant, "username", username)
+		return time.Time{}, false
+	}
+	return until, true
+}
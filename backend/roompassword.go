@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// errWrongPassword — отказ во входе в комнату с паролем (type:"error", code:"wrong_password").
+var errWrongPassword = errors.New("неверный пароль комнаты")
+
+// hashRoomPassword возвращает bcrypt хеш пароля комнаты.
+func hashRoomPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkPassword пускает в комнату с паролем клиента, приславшего верный пароль или действующий
+// токен входа (client.joinTokens), и выдает ему новый токен: по нему клиент возвращается
+// в комнату при восстановлении сессии, не зная пароля. В комнату без пароля пускает всех.
+func (r *Room) checkPassword(client *Client, password string) error {
+	r.mutex.Lock()
+	hash := r.PasswordHash
+	tokenValid := r.joinTokens[client.joinTokens[r.Name]] == client.Username
+	r.mutex.Unlock()
+	if hash == "" {
+		return nil
+	}
+	// Сравнение bcrypt медленное, поэтому идет без блокировки комнаты
+	if !(password == "" && tokenValid) && bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return errWrongPassword
+	}
+
+	token := make([]byte, 16)
+	rand.Read(token)
+	tokenString := hex.EncodeToString(token)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// Пароль могли сменить, пока шло сравнение
+	if r.PasswordHash != hash {
+		return errWrongPassword
+	}
+	delete(r.joinTokens, client.joinTokens[r.Name])
+	r.joinTokens[tokenString] = client.Username
+	client.joinTokens[r.Name] = tokenString
+	return nil
+}
+
+// commandSetPass меняет пароль комнаты или снимает его, если пароль не указан:
+// /setpass <комната> [пароль]. Выданные ранее токены входа перестают действовать,
+// участники, уже находящиеся в комнате, в ней остаются.
+func commandSetPass(client *Client, room *Room, args []string) error {
+	if len(args) > 1 {
+		return errors.New("использование: /setpass <комната> [пароль]")
+	}
+	hash := ""
+	if len(args) == 1 {
+		var err error
+		hash, err = hashRoomPassword(args[0])
+		if err != nil {
+			return errors.New("недопустимый пароль: " + err.Error())
+		}
+	}
+
+	room.mutex.Lock()
+	room.PasswordHash = hash
+	room.joinTokens = make(map[string]string)
+	room.mutex.Unlock()
+	client.logger().Info("Изменен пароль комнаты", "room", room.Name, "has_password", hash != "")
+	if hash == "" {
+		return reply(client, "пароль комнаты "+room.Name+" снят")
+	}
+	return reply(client, "пароль комнаты "+room.Name+" изменен")
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RoomRole — роль пользователя в комнате.
+type RoomRole string
+
+// Роли в комнате. Владелец — создатель комнаты: он удаляет комнату и назначает модераторов.
+// Модераторы удаляют участников из комнаты и лишают их права писать в нее.
+// Обычные участники могут только писать сообщения.
+const (
+	roleOwner     RoomRole = "owner"
+	roleModerator RoomRole = "moderator"
+	roleMember    RoomRole = "member"
+)
+
+// rank упорядочивает роли: у владельца права модератора, у модератора — участника.
+func (role RoomRole) rank() int {
+	switch role {
+	case roleOwner:
+		return 2
+	case roleModerator:
+		return 1
+	}
+	return 0
+}
+
+// RoomCommandFunc обрабатывает команду над комнатой room. args — аргументы команды после имени комнаты.
+type RoomCommandFunc func(client *Client, room *Room, args []string) error
+
+// requireRoomRole разрешает команду вида "/команда <комната> аргументы..." только пользователям
+// с ролью в комнате не ниже required. Комната ищется у арендатора клиента.
+func requireRoomRole(required RoomRole, handler RoomCommandFunc) CommandFunc {
+	return func(client *Client, args []string) error {
+		if len(args) == 0 {
+			return errors.New("не указано имя комнаты")
+		}
+		room, err := commandRoom(client, args[0], required)
+		if err != nil {
+			return err
+		}
+		return handler(client, room, args[1:])
+	}
+}
+
+// commandRoom находит комнату name арендатора клиента и проверяет, что роль клиента в ней не ниже required.
+// Без аутентификации имя может занять кто угодно, поэтому неподтвержденному имени команды ролей недоступны.
+func commandRoom(client *Client, name string, required RoomRole) (*Room, error) {
+	if !client.authenticated {
+		return nil, errors.New("команды ролей в комнате доступны только после входа по JWT или API ключу")
+	}
+	room := client.server.findRoom(client.Tenant, name)
+	if room == nil {
+		return nil, errors.New("комната не найдена: " + name)
+	}
+	if room.role(client.Username).rank() < required.rank() {
+		if required == roleOwner {
+			return nil, errors.New("команда доступна только владельцу комнаты " + room.Name)
+		}
+		return nil, errors.New("команда доступна только модераторам комнаты " + room.Name)
+	}
+	return room, nil
+}
+
+// role возвращает роль пользователя username в комнате.
+func (r *Room) role(username string) RoomRole {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if role, ok := r.Roles[username]; ok {
+		return role
+	}
+	return roleMember
+}
+
+// clientRole возвращает роль клиента в комнате: роль его имени, если имя подтверждено
+// (Client.authenticated), иначе — обычного участника.
+func (r *Room) clientRole(client *Client) RoomRole {
+	if !client.authenticated {
+		return roleMember
+	}
+	return r.role(client.Username)
+}
+
+// setRole меняет роль пользователя username и сообщает об этом участникам комнаты
+// сообщением type:"role_change". Для обычного участника запись из Roles удаляется.
+func (r *Room) setRole(username string, role RoomRole) {
+	r.mutex.Lock()
+	if role == roleMember {
+		delete(r.Roles, username)
+	} else {
+		r.Roles[username] = role
+	}
+	r.mutex.Unlock()
+
+	r.notify(Message{
+		Type:     "role_change",
+		Room:     r.Name,
+		Username: username,
+		Role:     string(role),
+		Text:     fmt.Sprintf("%s теперь %s в комнате %s", username, role, r.Name),
+	}, nil)
+}
+
+// sessions возвращает подключения пользователя username, находящиеся в комнате.
+func (r *Room) sessions(username string) []*Client {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var sessions []*Client
+	for member := range r.members {
+		if member.Username == username {
+			sessions = append(sessions, member)
+		}
+	}
+	return sessions
+}
+
+// commandOp назначает участника модератором: /op <комната> <имя>.
+func commandOp(client *Client, room *Room, args []string) error {
+	if len(args) != 1 {
+		return errors.New("использование: /op <комната> <имя>")
+	}
+	if room.role(args[0]) != roleMember {
+		return errors.New("пользователь " + args[0] + " уже " + string(room.role(args[0])))
+	}
+	room.setRole(args[0], roleModerator)
+	client.logger().Info("Назначен модератор комнаты", "room", room.Name, "username", args[0])
+	return nil
+}
+
+// commandDeop снимает с модератора его роль: /deop <комната> <имя>.
+func commandDeop(client *Client, room *Room, args []string) error {
+	if len(args) != 1 {
+		return errors.New("использование: /deop <комната> <имя>")
+	}
+	if room.role(args[0]) != roleModerator {
+		return errors.New("пользователь " + args[0] + " не модератор комнаты " + room.Name)
+	}
+	room.setRole(args[0], roleMember)
+	client.logger().Info("Снят модератор комнаты", "room", room.Name, "username", args[0])
+	return nil
+}
+
+// commandDeleteRoom удаляет комнату: /deleteroom <комната>. Участники получают type:"left".
+func commandDeleteRoom(client *Client, room *Room, args []string) error {
+	if len(args) != 0 {
+		return errors.New("использование: /deleteroom <комната>")
+	}
+	client.server.deleteRoom(room)
+	client.logger().Info("Комната удалена", "room", room.Name)
+	return reply(client, "комната "+room.Name+" удалена")
+}
+
+// commandRoomKick удаляет пользователя из комнаты: /roomkick <комната> <имя>.
+// Модератор не может удалить владельца или другого модератора.
+func commandRoomKick(client *Client, room *Room, args []string) error {
+	if len(args) != 1 {
+		return errors.New("использование: /roomkick <комната> <имя>")
+	}
+	err := checkModerationTarget(client, room, args[0])
+	if err != nil {
+		return err
+	}
+	sessions := room.sessions(args[0])
+	if len(sessions) == 0 {
+		return errors.New("пользователя " + args[0] + " нет в комнате " + room.Name)
+	}
+
+	// client.rooms удаленного меняет только его горутина: без членства в комнате запись в ней ничего не дает
+	for _, target := range sessions {
+		room.leave(target)
+		target.deliver(Message{Type: "left", Room: room.Name, Text: "вы удалены из комнаты модератором " + client.Username})
+	}
+	client.logger().Info("Пользователь удален из комнаты", "room", room.Name, "username", args[0])
+	return reply(client, "пользователь "+args[0]+" удален из комнаты "+room.Name)
+}
+
+// checkModerationTarget проверяет, что модератор client может применить меру к username:
+// роль цели должна быть ниже его собственной.
+func checkModerationTarget(client *Client, room *Room, username string) error {
+	if room.role(username).rank() >= room.role(client.Username).rank() {
+		return errors.New("нельзя применить команду к " + username + ": роль этого пользователя в комнате не ниже вашей")
+	}
+	return nil
+}
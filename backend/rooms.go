@@ -0,0 +1,286 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Room представляет именованную комнату чата со своим каналом рассылки.
+type Room struct {
+	Name string
+	// Tenant — арендатор комнаты: в одноименные комнаты разных арендаторов попадают разные клиенты.
+	Tenant string
+	// Topic (до maxTopicLength символов) и Description (до maxDescriptionLength символов) —
+	// тема и описание комнаты, их задают владелец и модераторы (/settopic, /setdesc).
+	Topic       string
+	Description string
+	// topicHistory — последние topicHistorySize смен темы, от старых к новым.
+	topicHistory []TopicChange
+	// CreatedAt — время создания комнаты.
+	CreatedAt time.Time
+	// members — клиенты, находящиеся в комнате.
+	members map[*Client]bool
+	// Roles — роли пользователей в комнате по имени (см. RoomRole). Владелец — создатель комнаты;
+	// пользователи без записи — обычные участники.
+	Roles map[string]RoomRole
+	// Invite — в комнату входят только приглашенные (/invite), владелец и модераторы.
+	Invite bool
+	// invited — приглашенные в комнату пользователи по имени.
+	invited map[string]RoomInvite
+	// PasswordHash — bcrypt хеш пароля комнаты; пустое значение — комната без пароля.
+	PasswordHash string
+	// joinTokens — действующие токены входа в комнату с паролем: токен → имя пользователя.
+	joinTokens map[string]string
+	// MutedUsers — пользователи, которым модератор запретил писать в комнату (/mute): имя → время,
+	// когда запрет снимается. Истекшие записи удаляются при следующей проверке (см. muteRemaining).
+	MutedUsers map[string]time.Time
+	// broadcast получает сообщения, адресованные комнате.
+	broadcast chan Message
+	// done закрывается при удалении комнаты: ее рассылка останавливается.
+	done chan struct{}
+	// mutex для безопасного доступа к Topic, Description, topicHistory, members, Roles, Invite, invited, PasswordHash, joinTokens и MutedUsers.
+	mutex sync.Mutex
+	// server — сервер, которому принадлежит комната.
+	server *Server
+}
+
+// getOrCreateRoom возвращает комнату арендатора tenant с указанным именем, создавая ее
+// при первом обращении; владельцем новой комнаты становится creator, а ее пароль задает
+// passwordHash. Для каждой новой комнаты запускается собственная горутина рассылки.
+func (s *Server) getOrCreateRoom(tenant, name, creator, passwordHash string) *Room {
+	s.roomsMutex.Lock()
+	defer s.roomsMutex.Unlock()
+
+	key := tenantName{tenant, name}
+	room, ok := s.rooms[key]
+	if !ok {
+		room = &Room{
+			Name:         name,
+			Tenant:       tenant,
+			CreatedAt:    time.Now().UTC(),
+			members:      make(map[*Client]bool),
+			Roles:        map[string]RoomRole{creator: roleOwner},
+			invited:      make(map[string]RoomInvite),
+			PasswordHash: passwordHash,
+			joinTokens:   make(map[string]string),
+			MutedUsers:   make(map[string]time.Time),
+			broadcast:    make(chan Message),
+			done:         make(chan struct{}),
+			server:       s,
+		}
+		room.loadInvites()
+		s.rooms[key] = room
+		go room.handleMessages()
+		slog.Info("Создана комната", "room", name, "tenant", tenant, "owner", creator)
+	}
+	return room
+}
+
+// findRoom возвращает существующую комнату арендатора tenant или nil.
+func (s *Server) findRoom(tenant, name string) *Room {
+	s.roomsMutex.Lock()
+	defer s.roomsMutex.Unlock()
+	return s.rooms[tenantName{tenant, name}]
+}
+
+// deleteRoom удаляет комнату: останавливает ее рассылку и выводит из нее всех участников,
+// отправляя им type:"left". Одноименная комната, созданная позже, — уже новая комната.
+func (s *Server) deleteRoom(room *Room) {
+	s.roomsMutex.Lock()
+	key := tenantName{room.Tenant, room.Name}
+	if s.rooms[key] == room {
+		delete(s.rooms, key)
+	}
+	s.roomsMutex.Unlock()
+
+	room.mutex.Lock()
+	select {
+	case <-room.done:
+	default:
+		close(room.done)
+	}
+	members := room.members
+	room.members = make(map[*Client]bool)
+	room.mutex.Unlock()
+
+	for member := range members {
+		member.deliver(Message{Type: "left", Room: room.Name, Text: "комната " + room.Name + " удалена"})
+	}
+	slog.Info("Удалена комната", "room", room.Name, "tenant", room.Tenant)
+}
+
+// post передает сообщение в рассылку комнаты. Возвращает false, если комната удалена.
+func (r *Room) post(msg Message) bool {
+	select {
+	case r.broadcast <- msg:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// join добавляет клиента в комнату.
+func (r *Room) join(client *Client) {
+	r.mutex.Lock()
+	r.members[client] = true
+	r.mutex.Unlock()
+}
+
+// leave удаляет клиента из комнаты.
+func (r *Room) leave(client *Client) {
+	r.mutex.Lock()
+	delete(r.members, client)
+	r.mutex.Unlock()
+}
+
+// hasMember сообщает, находится ли клиент в комнате.
+func (r *Room) hasMember(client *Client) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.members[client]
+}
+
+// notify доставляет служебное событие всем участникам комнаты, кроме except, минуя историю и журнал.
+func (r *Room) notify(msg Message, except *Client) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for member := range r.members {
+		if member != except && !member.deliver(msg) {
+			delete(r.members, member)
+		}
+	}
+}
+
+// handleMessages рассылает сообщения комнаты ее участникам.
+func (r *Room) handleMessages() {
+	for {
+		var msg Message
+		select {
+		case msg = <-r.broadcast:
+		case <-r.done:
+			return
+		}
+		ctx, span := tracer.Start(extractTrace(msg), "ws.send", trace.WithAttributes(
+			attribute.String("msg_id", msg.MsgID),
+			attribute.String("room", r.Name),
+		))
+		msg, err := r.server.applyMiddleware(ctx, msg)
+		if err != nil {
+			r.server.rejectMessage(msg, err)
+			span.End()
+			continue
+		}
+		slog.Info("Сообщение в комнату", "room", r.Name, "client_id", msg.Sender, "msg_id", msg.MsgID, "text", msg.Text)
+		r.server.messageLog.Write(msg)
+		publishRedis(msg)
+
+		r.mutex.Lock()
+		for client := range r.members {
+			if !r.server.bridge.Forward(msg, client) {
+				continue
+			}
+			if !client.deliver(msg) {
+				// Соединение закрыто; остальное (выход из комнат и т.д.) сделает serveClient
+				delete(r.members, client)
+			}
+		}
+		r.mutex.Unlock()
+		roomCache.Record(msg)
+		r.server.events.Emit(Event{Type: EventMessageBroadcast, Message: msg, Room: r.Name})
+		span.End()
+	}
+}
+
+// joinRoom обрабатывает сообщение type:"join". Комнату с паролем открывает password
+// или выданный прежде токен входа; пароль новой комнаты задает ее создатель.
+func joinRoom(client *Client, name, password string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		sendError(client, "не указано имя комнаты")
+		return
+	}
+	// Запись в client.rooms остается и после /roomkick или удаления комнаты: тогда войти можно снова
+	if room, ok := client.rooms[name]; ok && room.hasMember(client) {
+		sendError(client, "вы уже в комнате "+name)
+		return
+	}
+
+	passwordHash := ""
+	if password != "" && client.server.findRoom(client.Tenant, name) == nil {
+		var err error
+		passwordHash, err = hashRoomPassword(password)
+		if err != nil {
+			sendError(client, "недопустимый пароль: "+err.Error())
+			return
+		}
+	}
+	room := client.server.getOrCreateRoom(client.Tenant, name, client.Username, passwordHash)
+	if !room.canJoin(client.Username) {
+		sendError(client, "в комнату "+name+" входят только по приглашениям")
+		return
+	}
+	err := room.checkPassword(client, password)
+	if err != nil {
+		client.logger().Warn("Отказ во входе в комнату: неверный пароль", "room", name)
+		sendErrorCode(client, "wrong_password", err.Error()+" "+name)
+		return
+	}
+	room.join(client)
+	client.rooms[name] = room
+	saveSession(client)
+	client.logger().Info("Клиент вошел в комнату", "room", name)
+	client.server.events.Emit(Event{Type: EventRoomJoin, Client: client, Room: name})
+
+	err = client.Send(Message{Type: "joined", Room: name, Role: string(room.clientRole(client))})
+	if err != nil {
+		client.logger().Error("Ошибка отправки подтверждения входа в комнату", "err", err)
+	}
+}
+
+// leaveRoom обрабатывает сообщение type:"leave".
+func leaveRoom(client *Client, name string) {
+	room, ok := client.rooms[name]
+	if !ok {
+		sendError(client, "вы не в комнате "+name)
+		return
+	}
+
+	room.leave(client)
+	delete(client.rooms, name)
+	saveSession(client)
+	client.logger().Info("Клиент вышел из комнаты", "room", name)
+	client.server.events.Emit(Event{Type: EventRoomLeave, Client: client, Room: name})
+
+	err := client.Send(Message{Type: "left", Room: name})
+	if err != nil {
+		client.logger().Error("Ошибка отправки подтверждения выхода из комнаты", "err", err)
+	}
+}
+
+// leaveAllRooms удаляет клиента из всех комнат (при отключении).
+func leaveAllRooms(client *Client) {
+	for name, room := range client.rooms {
+		room.leave(client)
+		delete(client.rooms, name)
+		client.server.events.Emit(Event{Type: EventRoomLeave, Client: client, Room: name})
+	}
+}
+
+// sendToRoom передает сообщение в канал комнаты. Писать в комнату могут только ее участники.
+func sendToRoom(client *Client, msg Message) {
+	room, ok := client.rooms[msg.Room]
+	if !ok || !room.hasMember(client) {
+		sendError(client, "вы не в комнате "+msg.Room)
+		return
+	}
+	if !room.post(msg) {
+		sendError(client, "комната "+msg.Room+" удалена")
+		return
+	}
+	observeMessageSize(msg)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Ограничения длины темы и описания комнаты в символах.
+const (
+	maxTopicLength       = 120
+	maxDescriptionLength = 500
+)
+
+// topicHistorySize — сколько последних смен темы помнит комната.
+const topicHistorySize = 10
+
+// TopicChange — смена темы комнаты.
+type TopicChange struct {
+	Topic     string    `json:"topic"`
+	ChangedBy string    `json:"changed_by"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// roomDetails — описание комнаты с историей смен темы (GET /rooms/{name}).
+type roomDetails struct {
+	roomInfo
+	TopicHistory []TopicChange `json:"topic_history"`
+}
+
+// commandSetTopic меняет тему комнаты: /settopic <комната> <тема>. Без темы тема снимается.
+func commandSetTopic(client *Client, room *Room, args []string) error {
+	topic := strings.Join(args, " ")
+	if utf8.RuneCountInString(topic) > maxTopicLength {
+		return fmt.Errorf("тема слишком длинная: больше %d символов", maxTopicLength)
+	}
+
+	room.mutex.Lock()
+	room.Topic = topic
+	room.topicHistory = append(room.topicHistory, TopicChange{Topic: topic, ChangedBy: client.Username, ChangedAt: time.Now().UTC()})
+	if len(room.topicHistory) > topicHistorySize {
+		room.topicHistory = room.topicHistory[len(room.topicHistory)-topicHistorySize:]
+	}
+	room.mutex.Unlock()
+
+	client.logger().Info("Изменена тема комнаты", "room", room.Name, "topic", topic)
+	room.notifyUpdate(client, "тема комнаты "+room.Name+" изменена")
+	return nil
+}
+
+// commandSetDesc меняет описание комнаты: /setdesc <комната> <описание>. Без описания оно снимается.
+func commandSetDesc(client *Client, room *Room, args []string) error {
+	description := strings.Join(args, " ")
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		return fmt.Errorf("описание слишком длинное: больше %d символов", maxDescriptionLength)
+	}
+
+	room.mutex.Lock()
+	room.Description = description
+	room.mutex.Unlock()
+
+	client.logger().Info("Изменено описание комнаты", "room", room.Name)
+	room.notifyUpdate(client, "описание комнаты "+room.Name+" изменено")
+	return nil
+}
+
+// notifyUpdate сообщает участникам комнаты о новых свойствах комнаты сообщением type:"room_update".
+func (r *Room) notifyUpdate(client *Client, text string) {
+	info := r.info()
+	r.notify(Message{Type: "room_update", Room: r.Name, Username: client.Username, Text: text, RoomInfo: &info}, nil)
+}
+
+// handleRoom возвращает описание комнаты арендатора запроса вместе с последними сменами темы (GET /rooms/{name}).
+func (s *Server) handleRoom(w http.ResponseWriter, r *http.Request) {
+	room := s.findRoom(requestTenant(r), r.PathValue("name"))
+	if room == nil {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return
+	}
+	details := roomDetails{roomInfo: room.info()}
+	room.mutex.Lock()
+	details.TopicHistory = append([]TopicChange{}, room.topicHistory...)
+	room.mutex.Unlock()
+	writeJSON(w, http.StatusOK, details)
+}
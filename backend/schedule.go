@@ -0,0 +1,171 @@
+package main
+
+import (
+	"container/heap"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// scheduleTick — как часто проверять, не пора ли доставить отложенные сообщения.
+const scheduleTick = time.Second
+
+// scheduledQueue — очередь отложенных сообщений, упорядоченная по DeliverAt (container/heap).
+type scheduledQueue []*scheduledMessage
+
+// scheduledMessage — отложенное сообщение и его позиция в куче (нужна для отмены).
+type scheduledMessage struct {
+	msg   Message
+	index int
+}
+
+func (q scheduledQueue) Len() int { return len(q) }
+
+func (q scheduledQueue) Less(i, j int) bool { return q[i].msg.DeliverAt.Before(*q[j].msg.DeliverAt) }
+
+func (q scheduledQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *scheduledQueue) Push(x any) {
+	item := x.(*scheduledMessage)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *scheduledQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return item
+}
+
+var (
+	// scheduled — отложенные сообщения. Хранятся только в памяти и теряются при остановке сервера.
+	scheduled scheduledQueue
+	// scheduledByID индексирует отложенные сообщения по MsgID для отмены.
+	scheduledByID = make(map[string]*scheduledMessage)
+	// scheduleMutex для безопасного доступа к scheduled и scheduledByID.
+	scheduleMutex sync.Mutex
+)
+
+// scheduleMessage откладывает сообщение до msg.DeliverAt.
+func scheduleMessage(msg Message) {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	item := &scheduledMessage{msg: msg}
+	heap.Push(&scheduled, item)
+	scheduledByID[msg.MsgID] = item
+}
+
+// cancelScheduled удаляет отложенное сообщение из очереди.
+func cancelScheduled(id string) {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	item, ok := scheduledByID[id]
+	if !ok {
+		return
+	}
+	heap.Remove(&scheduled, item.index)
+	delete(scheduledByID, id)
+}
+
+// cancelUserScheduled удаляет из очереди отложенные сообщения пользователя username арендатора tenant:
+// отправленные им и личные сообщения ему.
+func cancelUserScheduled(tenant, username string) {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	for id, item := range scheduledByID {
+		if userMessage(item.msg, tenant, username) {
+			heap.Remove(&scheduled, item.index)
+			delete(scheduledByID, id)
+		}
+	}
+}
+
+// findScheduled возвращает отложенное сообщение по ID.
+func findScheduled(id string) (Message, bool) {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	item, ok := scheduledByID[id]
+	if !ok {
+		return Message{}, false
+	}
+	return item.msg, true
+}
+
+// dueMessages извлекает из очереди сообщения, время доставки которых наступило.
+func dueMessages(now time.Time) []Message {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	var due []Message
+	for len(scheduled) > 0 && !scheduled[0].msg.DeliverAt.After(now) {
+		item := heap.Pop(&scheduled).(*scheduledMessage)
+		delete(scheduledByID, item.msg.MsgID)
+		due = append(due, item.msg)
+	}
+	return due
+}
+
+// deliverScheduled раз в scheduleTick отправляет отложенные сообщения, время которых наступило.
+// Завершается при остановке сервера.
+func (s *Server) deliverScheduled() {
+	ticker := time.NewTicker(scheduleTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopping:
+			return
+		case now := <-ticker.C:
+			for _, msg := range dueMessages(now) {
+				s.deliverScheduledMessage(msg)
+			}
+		}
+	}
+}
+
+// deliverScheduledMessage отправляет отложенное сообщение в комнату, подписчикам темы или в общий чат.
+func (s *Server) deliverScheduledMessage(msg Message) {
+	if msg.Room != "" {
+		room := s.findRoom(msg.Tenant, msg.Room)
+		if room == nil {
+			slog.Warn("Комната отложенного сообщения не найдена", "msg_id", msg.MsgID, "room", msg.Room)
+			return
+		}
+		if !room.post(msg) {
+			slog.Warn("Комната отложенного сообщения удалена", "msg_id", msg.MsgID, "room", msg.Room)
+		}
+		return
+	}
+	if msg.Topic != "" {
+		s.publishTopic(msg)
+		return
+	}
+	err := s.Broadcast(msg)
+	if err != nil {
+		slog.Warn("Отложенное сообщение не отправлено", "msg_id", msg.MsgID, "err", err)
+	}
+}
+
+// handleCancelScheduled отменяет отложенное сообщение (DELETE /messages/{id}/scheduled).
+// При включенной JWT аутентификации отменить сообщение может только его отправитель.
+func (s *Server) handleCancelScheduled(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	msg, ok := findScheduled(id)
+	if !ok {
+		http.Error(w, "scheduled message not found", http.StatusNotFound)
+		return
+	}
+	if username, ok := authenticatedUser(r.Context()); ok && (username != msg.Username || requestTenant(r) != msg.Tenant) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	cancelScheduled(id)
+	slog.Info("Отложенное сообщение отменено", "msg_id", id, "client_id", msg.Sender)
+	w.WriteHeader(http.StatusNoContent)
+}
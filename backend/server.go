@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+
+	"server-7/chatpb"
+)
+
+// Server — экземпляр чат-сервера: настройки, подключенные клиенты, комнаты, общая рассылка,
+// история, присутствие, отметки о прочтении, журнал сообщений и серверы всех протоколов
+// (WebSocket/HTTP, TCP, gRPC, MQTT). Общими для процесса остаются подключения к внешним
+// ресурсам (messageStore, redisClient, auditLog) и данные, которые хранятся рядом с ними
+// (кэш комнат, реакции, запреты писать, отложенные сообщения): экземпляры одного процесса
+// работают с одной базой и одним Redis.
+type Server struct {
+	config Config
+
+	// clients хранит список всех подключенных клиентов (WebSocket, TCP и gRPC).
+	clients map[*Client]bool
+	// clientsByName индексирует зарегистрированных клиентов по арендатору и имени (для личных сообщений).
+	clientsByName map[tenantName]*Client
+	// mutex для безопасного доступа к картам clients и clientsByName.
+	mutex sync.RWMutex
+
+	// rooms хранит все созданные комнаты по арендатору и имени.
+	rooms map[tenantName]*Room
+	// roomsMutex для безопасного доступа к карте rooms.
+	roomsMutex sync.Mutex
+	// topics — подписки клиентов на темы (type:"subscribe").
+	topics *topicTrie
+
+	// broadcast — очередь сообщений для отправки всем клиентам, разбирается по приоритету.
+	// Емкость (Config.BroadcastBuffer) сглаживает всплески: отправители не ждут,
+	// пока handleMessages разошлет предыдущие сообщения.
+	broadcast *messageQueue
+	// messagesDone закрывается, когда handleMessages разослал все оставшиеся сообщения.
+	messagesDone chan struct{}
+
+	// clientsWG отслеживает горутины клиентов (serveClient и writeLoop), чтобы дождаться их при остановке.
+	clientsWG sync.WaitGroup
+	// stopping закрывается в начале остановки, чтобы завершить длительные ответы и фоновые задачи.
+	stopping chan struct{}
+	// cancel отменяет контекст фоновых задач (подписка на Redis).
+	cancel context.CancelFunc
+
+	// history — последние сообщения общего чата, которые получают новые клиенты.
+	history *History
+	// presence — присутствие пользователей (GET /presence, type:"presence").
+	presence *Presence
+	// receipts — отметки о прочтении сообщений (type:"read").
+	receipts *Receipts
+	// messageLog — журнал сообщений; nil, если MESSAGE_LOG_FILE не задан (открывается в main).
+	messageLog *MessageLog
+
+	// events рассылает события жизненного цикла сервера (см. EventBus).
+	events *EventBus
+	// analytics считает события за последнюю минуту для GET /admin/analytics/stream.
+	analytics *Analytics
+	// senderLimits ограничивает частоту сообщений gRPC, MQTT и long-polling отправителей.
+	senderLimits *senderLimits
+	// bridge пропускает сообщения между клиентами WebSocket и TCP (Config.EnableBridge).
+	bridge *Bridge
+	// webhookClient отправляет запросы вебхукам с таймаутом Config.WebhookTimeout.
+	webhookClient *http.Client
+	// middleware — обработчики сообщений перед рассылкой в порядке регистрации.
+	middleware []MessageMiddleware
+	// middlewareMutex для безопасного доступа к middleware.
+	middlewareMutex sync.RWMutex
+
+	httpServer *http.Server
+	listener   net.Listener
+	grpcServer *grpc.Server
+	mqttBroker *mqtt.Server
+}
+
+// NewServer создает сервер с настройками cfg и встроенными обработчиками сообщений.
+func NewServer(cfg Config) *Server {
+	s := &Server{
+		config:        cfg,
+		clients:       make(map[*Client]bool),
+		clientsByName: make(map[tenantName]*Client),
+		rooms:         make(map[tenantName]*Room),
+		topics:        newTopicTrie(),
+		broadcast:     newMessageQueue(cfg.BroadcastBuffer),
+		messagesDone:  make(chan struct{}),
+		history:       newHistory(cfg.HistorySize),
+		presence:      newPresence(),
+		receipts:      newReceipts(cfg.MessageTTL()),
+		stopping:      make(chan struct{}),
+		cancel:        func() {},
+		events:        newEventBus(),
+		analytics:     &Analytics{},
+		senderLimits:  newSenderLimits(cfg.RateLimit),
+		bridge:        newBridge(cfg.EnableBridge),
+	}
+	s.AddMiddleware(trimWhitespace)
+	s.AddMiddleware(banWords)
+	s.AddMiddleware(stampTimestamp)
+	return s
+}
+
+// Start запускает рассылку, фоновые задачи и серверы всех протоколов. Возвращает ошибку,
+// если не удалось занять порт; сами серверы работают в фоне до вызова Stop.
+func (s *Server) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	// Запуск обработчика сообщений в отдельной горутине
+	go s.handleMessages()
+	s.setupWebhooks()
+	s.setupAnalytics()
+	go s.expirePollSessions()
+	go s.deliverScheduled()
+	go s.markIdleAway()
+	if messageStore != nil {
+		if ttl := s.config.MessageTTL(); ttl > 0 {
+			go s.purgeExpiredMessages(ttl)
+		}
+	}
+	if redisClient != nil {
+		go s.subscribeRedis(ctx)
+	}
+
+	return errors.Join(s.startHTTP(), s.startTCP(), s.startGRPC(), s.startMQTT())
+}
+
+// ConnectedClients возвращает число подключенных клиентов.
+func (s *Server) ConnectedClients() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.clients)
+}
+
+// routes возвращает маршруты HTTP сервера. Все маршруты с данными арендатора проходят requireAuth:
+// иначе арендатора выбирал бы заголовок X-Tenant-ID, даже когда аутентификация включена.
+func (s *Server) routes() *http.ServeMux {
+	secret, adminToken := s.config.JWTSecret, s.config.AdminToken
+	mux := http.NewServeMux()
+	mux.Handle("/ws", limitConnections(s.checkOrigin(requireAuth(secret, "subscribe", http.HandlerFunc(s.handleWebSocket)))))
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /health", s.handleHealth)
+	mux.HandleFunc("GET /clients", requireAdmin(adminToken, s.handleClients))
+	mux.HandleFunc("GET /clients/{id}/stats", requireAdmin(adminToken, s.handleClientStats))
+	mux.HandleFunc("DELETE /clients/{id}", requireAdmin(adminToken, s.handleKickClient))
+	mux.Handle("GET /messages", requireAuth(secret, "subscribe", http.HandlerFunc(s.handleMessagesList)))
+	mux.Handle("GET /presence", requireAuth(secret, "subscribe", http.HandlerFunc(s.handlePresence)))
+	mux.Handle("GET /messages/{id}/receipts", requireAuth(secret, "subscribe", http.HandlerFunc(s.handleReceipts)))
+	mux.Handle("DELETE /messages/{id}/scheduled", requireAuth(secret, "send", http.HandlerFunc(s.handleCancelScheduled)))
+	mux.Handle("GET /events", requireAuth(secret, "subscribe", http.HandlerFunc(s.handleEvents)))
+	mux.Handle("POST /poll", requireAuth(secret, "send", http.HandlerFunc(s.handlePollSend)))
+	mux.Handle("POST /devices", requireAuth(secret, "subscribe", http.HandlerFunc(s.handleRegisterDevice)))
+	mux.Handle("PUT /profile", requireAuth(secret, "subscribe", http.HandlerFunc(s.handleUpdateProfile)))
+	mux.Handle("GET /poll/{token}", requireAuth(secret, "subscribe", http.HandlerFunc(s.handlePollReceive)))
+	if secret != "" {
+		mux.HandleFunc("POST /auth/refresh", s.handleTokenRefresh)
+	}
+	if s.config.AuthProvider != "" {
+		mux.HandleFunc("GET /auth/login", s.handleOAuthLogin)
+		mux.HandleFunc("GET /auth/callback", s.handleOAuthCallback)
+	}
+	mux.HandleFunc("GET /admin/users/{username}/export", requireAdmin(adminToken, s.handleUserExport))
+	mux.HandleFunc("DELETE /admin/users/{username}", requireAdmin(adminToken, s.handleUserErase))
+	mux.HandleFunc("POST /admin/users/{username}/mute", requireAdmin(adminToken, s.handleUserMute))
+	mux.HandleFunc("DELETE /admin/users/{username}/mute", requireAdmin(adminToken, s.handleUserUnmute))
+	mux.HandleFunc("GET /admin/users/{username}/deletion-status", requireAdmin(adminToken, s.handleDeletionStatus))
+	mux.HandleFunc("GET /admin/analytics/stream", requireAdmin(adminToken, s.handleAnalyticsStream))
+	mux.HandleFunc("GET /admin/dead-letters", requireAdmin(adminToken, s.handleDeadLetters))
+	mux.HandleFunc("POST /admin/dead-letters/{id}/retry", requireAdmin(adminToken, s.handleDeadLetterRetry))
+	mux.Handle("GET /rooms", requireAuth(secret, "subscribe", http.HandlerFunc(s.handleRooms)))
+	mux.Handle("GET /rooms/{name}", requireAuth(secret, "subscribe", http.HandlerFunc(s.handleRoom)))
+	mux.HandleFunc("GET /rooms/{name}/invites", requireAdmin(adminToken, s.handleRoomInvites))
+	mux.HandleFunc("GET /admin/blocklist", requireAdmin(adminToken, s.handleBlocklistList))
+	mux.HandleFunc("POST /admin/blocklist", requireAdmin(adminToken, s.handleBlocklistAdd))
+	mux.HandleFunc("DELETE /admin/blocklist/{cidr...}", requireAdmin(adminToken, s.handleBlocklistRemove))
+	return mux
+}
+
+// startHTTP запускает HTTP сервер (WebSocket и REST). Если заданы сертификат и ключ — по TLS.
+func (s *Server) startHTTP() error {
+	addr := fmt.Sprintf(":%d", s.config.WSPort)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("запуск WebSocket сервера: %w", err)
+	}
+	if s.config.JWTSecret == "" {
+		slog.Warn("JWT_SECRET не задан, подключения не требуют аутентификации")
+	}
+
+	s.httpServer = &http.Server{Addr: addr, Handler: rejectBlocked(s.cors(s.routes()))}
+	go func() {
+		var err error
+		if s.config.TLSCertFile != "" {
+			s.httpServer.TLSConfig = newTLSConfig()
+			slog.Info("WebSocket сервер (TLS) запущен", "addr", addr)
+			err = s.httpServer.ServeTLS(listener, s.config.TLSCertFile, s.config.TLSKeyFile)
+		} else {
+			slog.Warn("Сертификат WebSocket (WS_TLS_CERT, WS_TLS_KEY) не задан, сервер работает без шифрования")
+			slog.Info("WebSocket сервер запущен", "addr", addr)
+			err = s.httpServer.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("Ошибка WebSocket сервера", err)
+		}
+	}()
+	return nil
+}
+
+// startTCP запускает TCP сервер. Если заданы сертификат и ключ — по TLS.
+func (s *Server) startTCP() error {
+	addr := fmt.Sprintf(":%d", s.config.TCPPort)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("запуск TCP сервера: %w", err)
+	}
+	if s.config.TCPTLSCertFile != "" {
+		tlsConfig, err := newTCPTLSConfig(s.config.TCPTLSCertFile, s.config.TCPTLSKeyFile, s.config.TCPClientCAFile)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("настройка TLS для TCP: %w", err)
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+		slog.Info("TCP сервер работает по TLS")
+	}
+	s.listener = listener
+
+	go func() {
+		slog.Info("TCP сервер запущен", "addr", addr)
+		for {
+			// Принимаем входящие соединения
+			conn, err := listener.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return // Сервер останавливается
+				}
+				slog.Error("Ошибка приема TCP соединения", "err", err)
+				continue
+			}
+			// Обрабатываем соединение в отдельной горутине
+			go s.handleTCPConnection(conn)
+		}
+	}()
+	return nil
+}
+
+// startGRPC запускает gRPC сервер на порту GRPCPort.
+func (s *Server) startGRPC() error {
+	addr := fmt.Sprintf(":%d", s.config.GRPCPort)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("запуск gRPC сервера: %w", err)
+	}
+
+	s.grpcServer = grpc.NewServer()
+	chatpb.RegisterChatServer(s.grpcServer, &chatService{server: s})
+	go func() {
+		slog.Info("gRPC сервер запущен", "addr", addr)
+		err := s.grpcServer.Serve(listener)
+		if err != nil {
+			fatal("Ошибка gRPC сервера", err)
+		}
+	}()
+	return nil
+}
+
+// AddMiddleware добавляет обработчик в конец цепочки обработки сообщений перед рассылкой.
+func (s *Server) AddMiddleware(m MessageMiddleware) {
+	s.middlewareMutex.Lock()
+	defer s.middlewareMutex.Unlock()
+	s.middleware = append(s.middleware, m)
+}
+
+// applyMiddleware пропускает сообщение через цепочку обработчиков.
+// Сообщения, пришедшие с других экземпляров (Redis), уже обработаны там и не меняются.
+func (s *Server) applyMiddleware(ctx context.Context, msg Message) (Message, error) {
+	if msg.remote {
+		return msg, nil
+	}
+
+	s.middlewareMutex.RLock()
+	chain := s.middleware
+	s.middlewareMutex.RUnlock()
+	return chainMiddleware(chain...)(ctx, msg)
+}
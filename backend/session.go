@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// sessionTTL — сколько хранится сессия отключившегося клиента. Redis удаляет ее сам.
+const sessionTTL = time.Hour
+
+// session — состояние клиента, которое восстанавливается при переподключении.
+type session struct {
+	// ClientID — ID клиента, который получает переподключившийся клиент.
+	ClientID string   `json:"clientId"`
+	Username string   `json:"username"`
+	Tenant   string   `json:"tenant,omitempty"`
+	Rooms    []string `json:"rooms"`
+	// JoinTokens — токены входа в комнаты с паролем, чтобы вернуться в них без пароля.
+	JoinTokens  map[string]string `json:"joinTokens,omitempty"`
+	ConnectedAt time.Time         `json:"connectedAt"`
+}
+
+// sessionKey возвращает ключ Redis для сессии с токеном восстановления token.
+func sessionKey(token string) string {
+	return "chat:session:" + token
+}
+
+// newResumeToken возвращает случайный токен восстановления сессии. Сессия хранится под ним,
+// а не под ID клиента: ID выдаются по порядку, и по ним легко занять чужую сессию.
+func newResumeToken() string {
+	token := make([]byte, 16)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// registeredMessage возвращает подтверждение регистрации type:"registered" с токеном восстановления
+// сессии: его клиент передает при переподключении в параметре resume_token.
+func registeredMessage(client *Client) Message {
+	return Message{Type: "registered", Username: client.Username, Sender: client.ID, ResumeToken: client.resumeToken}
+}
+
+// saveSession сохраняет имя и комнаты клиента в Redis (если он настроен).
+// Вызывается из горутины клиента: только она меняет client.rooms.
+func saveSession(client *Client) {
+	if redisClient == nil || client.Username == "" {
+		return
+	}
+
+	s := session{ClientID: client.ID, Username: client.Username, Tenant: client.Tenant, ConnectedAt: client.ConnectedAt, JoinTokens: make(map[string]string)}
+	for name := range client.rooms {
+		s.Rooms = append(s.Rooms, name)
+		if token, ok := client.joinTokens[name]; ok {
+			s.JoinTokens[name] = token
+		}
+	}
+	slices.Sort(s.Rooms)
+	data, err := json.Marshal(s)
+	if err != nil {
+		client.logger().Error("Ошибка сериализации сессии", "err", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	err = redisClient.Set(ctx, sessionKey(client.resumeToken), data, sessionTTL).Err()
+	if err != nil {
+		client.logger().Warn("Ошибка сохранения сессии в Redis", "err", err)
+	}
+}
+
+// loadSession читает сессию с токеном восстановления token.
+func loadSession(token string) (session, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	data, err := redisClient.Get(ctx, sessionKey(token)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.Warn("Ошибка чтения сессии из Redis", "err", err)
+		}
+		return session{}, false
+	}
+
+	var s session
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		slog.Warn("Некорректная сессия в Redis", "err", err)
+		return session{}, false
+	}
+	return s, true
+}
+
+// deleteSession удаляет сессию с токеном восстановления token.
+func deleteSession(token string) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	err := redisClient.Del(ctx, sessionKey(token)).Err()
+	if err != nil {
+		slog.Warn("Ошибка удаления сессии из Redis", "err", err)
+	}
+}
+
+// restoreSession возвращает переподключившемуся клиенту прежние ID, имя и комнаты по токену
+// восстановления из type:"registered". Токен одноразовый: клиент получает новый в подтверждении
+// восстановления. Клиент, прошедший JWT аутентификацию, может восстановить только сессию своего
+// пользователя, а любой клиент — только сессию своего арендатора. Возвращает true, если сессия восстановлена.
+func restoreSession(client *Client, token string) bool {
+	if redisClient == nil || token == "" {
+		return false
+	}
+	s, ok := loadSession(token)
+	if !ok {
+		client.logger().Warn("Сессия для восстановления не найдена")
+		return false
+	}
+	if s.Tenant != client.Tenant || (client.Username != "" && client.Username != s.Username) {
+		client.logger().Warn("Отказ в восстановлении чужой сессии", "prev_client_id", s.ClientID)
+		return false
+	}
+	if client.server.findClientByID(s.ClientID) != nil {
+		client.logger().Warn("Сессия уже используется другим подключением", "prev_client_id", s.ClientID)
+		return false
+	}
+
+	if client.Username == "" {
+		err := client.server.claimUsername(client, s.Username)
+		if err != nil {
+			sendError(client, "не удалось восстановить сессию: "+err.Error())
+			return false
+		}
+	}
+	client.ID = s.ClientID
+	deleteSession(token)
+	err := client.Send(registeredMessage(client))
+	if err != nil {
+		client.logger().Error("Ошибка отправки подтверждения регистрации", "err", err)
+	}
+	for _, name := range s.Rooms {
+		if token, ok := s.JoinTokens[name]; ok {
+			client.joinTokens[name] = token
+		}
+		joinRoom(client, name, "")
+	}
+	client.logger().Info("Сессия восстановлена", "username", client.Username, "rooms", s.Rooms)
+	saveSession(client)
+	return true
+}
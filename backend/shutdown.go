@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// closeBroadcast закрывает очередь broadcast; после этого Broadcast перестает принимать сообщения.
+func (s *Server) closeBroadcast() {
+	s.broadcast.Close()
+}
+
+// Stop корректно останавливает сервер: перестает принимать соединения,
+// досылает накопленные сообщения, уведомляет клиентов и ждет их отключения.
+// По истечении ShutdownTimeout оставшиеся соединения закрываются принудительно.
+func (s *Server) Stop() {
+	slog.Info("Остановка сервера")
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout.Duration)
+	defer cancel()
+
+	// Перестаем принимать новые соединения и завершаем фоновые задачи
+	close(s.stopping)
+	s.cancel()
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		slog.Error("Ошибка остановки HTTP сервера", "err", err)
+	}
+	s.listener.Close()
+
+	// Досылаем сообщения, которые уже попали в broadcast
+	s.closeBroadcast()
+	select {
+	case <-s.messagesDone:
+	case <-ctx.Done():
+	}
+
+	// Уведомляем клиентов об остановке
+	s.mutex.Lock()
+	for client := range s.clients {
+		s.sendLocked(client, Message{Type: "server_shutdown", Text: "сервер останавливается"})
+	}
+	s.mutex.Unlock()
+
+	// Ждем, пока клиенты отключатся сами
+	done := make(chan struct{})
+	go func() {
+		s.clientsWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		slog.Info("Все клиенты отключены")
+	case <-ctx.Done():
+		slog.Warn("Таймаут остановки истек, закрываем оставшиеся соединения")
+		s.mutex.Lock()
+		for client := range s.clients {
+			client.Close()
+		}
+		s.mutex.Unlock()
+		<-done
+	}
+
+	// Потоки gRPC и MQTT клиентов к этому моменту завершены
+	s.grpcServer.GracefulStop()
+	s.mqttBroker.Close()
+}
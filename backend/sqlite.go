@@ -0,0 +1,30 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations — схема базы SQLite, та же, что и для PostgreSQL.
+//
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+// openSQLite открывает (и при необходимости создает) файл базы SQLite и применяет миграции.
+func openSQLite(path string) (MessageStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite не допускает параллельной записи; одно соединение избавляет от SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	err = migrate(db, sqliteMigrations, "migrations/sqlite")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqlStore{db: db}, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sseBufferSize — емкость канала сообщений одного SSE клиента.
+const sseBufferSize = 64
+
+var (
+	// sseClients хранит каналы подключенных SSE клиентов (GET /events) и их арендаторов.
+	sseClients = make(map[chan Message]string)
+	// sseMutex для безопасного доступа к карте sseClients.
+	sseMutex sync.Mutex
+)
+
+// handleEvents отдает рассылку общего чата по протоколу Server-Sent Events
+// для клиентов, которым недоступен WebSocket. Каждое сообщение передается как
+// "data: <json>\n\n". Клиент получает сообщения арендатора из X-Tenant-ID.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan Message, sseBufferSize)
+	sseMutex.Lock()
+	sseClients[ch] = requestTenant(r)
+	sseMutex.Unlock()
+	slog.Info("Новый SSE клиент подключен", "remote_addr", r.RemoteAddr)
+
+	defer func() {
+		sseMutex.Lock()
+		delete(sseClients, ch)
+		close(ch)
+		sseMutex.Unlock()
+		slog.Info("SSE клиент отключен", "remote_addr", r.RemoteAddr)
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Комментарии раз в PingInterval не дают прокси закрыть молчащее соединение
+	keepAlive := time.NewTicker(s.config.PingInterval.Duration)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.stopping:
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case msg := <-ch:
+			data, err := json.Marshal(msg)
+			if err != nil {
+				slog.Error("Ошибка сериализации SSE сообщения", "msg_id", msg.MsgID, "err", err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// broadcastSSE передает сообщение всем SSE клиентам его арендатора, не блокируясь:
+// если канал клиента заполнен, сообщение для него отбрасывается.
+func broadcastSSE(msg Message) {
+	sseMutex.Lock()
+	defer sseMutex.Unlock()
+	for ch, tenant := range sseClients {
+		if tenant != msg.Tenant {
+			continue
+		}
+		select {
+		case ch <- msg:
+		default:
+			slowClientDropsTotal.Inc()
+		}
+	}
+}
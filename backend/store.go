@@ -0,0 +1,425 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// MessageStore — постоянное хранилище сообщений чата (PostgreSQL или SQLite).
+type MessageStore interface {
+	// Insert сохраняет сообщение.
+	Insert(msg Message) error
+	// Query возвращает сообщения комнаты room (пустая строка — общий чат) арендатора tenant
+	// в порядке отправки, пропустив offset первых и не больше limit.
+	Query(tenant, room string, offset, limit int) ([]Message, error)
+	// Count возвращает число сообщений в комнате room арендатора tenant.
+	Count(tenant, room string) (int, error)
+	// Replies возвращает прямые ответы на сообщение parentID арендатора tenant в порядке отправки.
+	Replies(tenant, parentID string) ([]Message, error)
+	// EachByUsername передает each все сообщения пользователя username арендатора tenant
+	// в порядке отправки, не загружая их в память целиком. Ошибка each прерывает обход.
+	EachByUsername(tenant, username string, each func(Message) error) error
+	// Exists сообщает, есть ли в базе сообщение арендатора tenant с указанным MsgID.
+	Exists(tenant, msgID string) (bool, error)
+	// AddReaction и RemoveReaction сохраняют изменение реакции пользователя арендатора tenant на сообщение.
+	AddReaction(tenant, msgID, emoji, username string) error
+	RemoveReaction(tenant, msgID, emoji, username string) error
+	// Reactions возвращает все сохраненные реакции: (арендатор, MsgID) → эмодзи → имена пользователей.
+	Reactions() (map[tenantName]map[string][]string, error)
+	// DeleteByUsername удаляет не больше limit сообщений пользователя username арендатора tenant
+	// и возвращает их число.
+	DeleteByUsername(tenant, username string, limit int) (int64, error)
+	// RemoveUserReactions удаляет реакции пользователя username на сообщения арендатора tenant.
+	RemoveUserReactions(tenant, username string) error
+	// CreateDeletionJob создает задачу удаления данных пользователя со статусом "pending".
+	CreateDeletionJob(tenant, username string) (DeletionJob, error)
+	// UpdateDeletionJob сохраняет статус, прогресс и ошибку задачи.
+	UpdateDeletionJob(job DeletionJob) error
+	// LastDeletionJob возвращает последнюю задачу удаления данных пользователя (ok == false, если их не было).
+	LastDeletionJob(tenant, username string) (job DeletionJob, ok bool, err error)
+	// AddDeviceToken привязывает токен устройства FCM к пользователю username арендатора tenant.
+	// Токен, уже привязанный к другому пользователю, переходит к новому.
+	AddDeviceToken(tenant, username, token string) error
+	// DeviceTokens возвращает токены устройств пользователя username арендатора tenant.
+	DeviceTokens(tenant, username string) ([]string, error)
+	// RemoveDeviceToken удаляет токен устройства, которое больше не получает уведомления.
+	RemoveDeviceToken(token string) error
+	// RemoveUserDeviceTokens удаляет все токены устройств пользователя username арендатора tenant.
+	RemoveUserDeviceTokens(tenant, username string) error
+	// SetUserEmail сохраняет адрес почты пользователя username арендатора tenant.
+	SetUserEmail(tenant, username, email string) error
+	// User возвращает профиль пользователя (ok == false, если профиль не сохранялся).
+	User(tenant, username string) (user User, ok bool, err error)
+	// DeleteUser удаляет профиль пользователя username арендатора tenant.
+	DeleteUser(tenant, username string) error
+	// AddRoomInvite сохраняет приглашение в комнату (повторное приглашение заменяет прежнее).
+	AddRoomInvite(invite RoomInvite) error
+	// RoomInvites возвращает приглашения в комнату room арендатора tenant в порядке их выдачи.
+	RoomInvites(tenant, room string) ([]RoomInvite, error)
+	// RemoveUserRoomInvites удаляет приглашения пользователя username арендатора tenant во все комнаты.
+	RemoveUserRoomInvites(tenant, username string) error
+	// Purge удаляет сообщения, отправленные раньше before, и возвращает их число.
+	Purge(before time.Time) (int64, error)
+	// Close закрывает соединения с базой.
+	Close() error
+}
+
+// User — профиль пользователя, который хранится в базе.
+type User struct {
+	Tenant   string `json:"tenant,omitempty"`
+	Username string `json:"username"`
+	// Email — адрес для писем о личных сообщениях (из поля email JWT или PUT /profile).
+	Email string `json:"email,omitempty"`
+}
+
+// DeletionJob — задача удаления данных пользователя (DELETE /admin/users/{username}).
+type DeletionJob struct {
+	ID       int64  `json:"id"`
+	Tenant   string `json:"tenant,omitempty"`
+	Username string `json:"username"`
+	// Status — "pending", "running", "done" или "failed".
+	Status string `json:"status"`
+	// DeletedMessages — сколько сообщений уже удалено.
+	DeletedMessages int64     `json:"deleted_messages"`
+	Error           string    `json:"error,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// messageStore — хранилище сообщений. nil, если база не настроена.
+var messageStore MessageStore
+
+// sqlStore реализует MessageStore поверх database/sql.
+// Схема и запросы одинаковы для PostgreSQL и SQLite (параметры вида $1 понимают оба драйвера).
+type sqlStore struct {
+	db *sql.DB
+}
+
+func (s *sqlStore) Insert(msg Message) error {
+	// Сообщение с уже сохраненным MsgID (см. messages_tenant_msg_id) повторно не записывается.
+	// Вложения хранятся одним столбцом в JSON; у сообщений без вложений он пустой
+	var attachments []byte
+	if len(msg.Attachments) > 0 {
+		var err error
+		attachments, err = json.Marshal(msg.Attachments)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := s.db.Exec(
+		`INSERT INTO messages (msg_id, parent_msg_id, client_id, username, tenant, room, text, attachments, sent_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT DO NOTHING`,
+		msg.MsgID, msg.ParentMsgID, msg.Sender, msg.Username, msg.Tenant, msg.Room, msg.Text, string(attachments), msg.SentAt,
+	)
+	return err
+}
+
+func (s *sqlStore) Query(tenant, room string, offset, limit int) ([]Message, error) {
+	return s.query(
+		`SELECT `+messageColumns+` FROM messages WHERE tenant = $1 AND room = $2 ORDER BY id LIMIT $3 OFFSET $4`,
+		tenant, room, limit, offset,
+	)
+}
+
+func (s *sqlStore) Replies(tenant, parentID string) ([]Message, error) {
+	return s.query(`SELECT `+messageColumns+` FROM messages WHERE tenant = $1 AND parent_msg_id = $2 ORDER BY id`, tenant, parentID)
+}
+
+func (s *sqlStore) EachByUsername(tenant, username string, each func(Message) error) error {
+	rows, err := s.db.Query(`SELECT `+messageColumns+` FROM messages WHERE tenant = $1 AND username = $2 ORDER BY id`, tenant, username)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return err
+		}
+		err = each(msg)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqlStore) Exists(tenant, msgID string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM messages WHERE tenant = $1 AND msg_id = $2`, tenant, msgID).Scan(&n)
+	return n > 0, err
+}
+
+func (s *sqlStore) AddReaction(tenant, msgID, emoji, username string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO reactions (tenant, msg_id, emoji, username) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`,
+		tenant, msgID, emoji, username,
+	)
+	return err
+}
+
+func (s *sqlStore) RemoveReaction(tenant, msgID, emoji, username string) error {
+	_, err := s.db.Exec(
+		`DELETE FROM reactions WHERE tenant = $1 AND msg_id = $2 AND emoji = $3 AND username = $4`,
+		tenant, msgID, emoji, username,
+	)
+	return err
+}
+
+func (s *sqlStore) Reactions() (map[tenantName]map[string][]string, error) {
+	rows, err := s.db.Query(
+		`SELECT tenant, msg_id, emoji, username FROM reactions ORDER BY tenant, msg_id, emoji`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[tenantName]map[string][]string)
+	for rows.Next() {
+		var tenant, msgID, emoji, username string
+		err = rows.Scan(&tenant, &msgID, &emoji, &username)
+		if err != nil {
+			return nil, err
+		}
+		key := tenantName{tenant, msgID}
+		if result[key] == nil {
+			result[key] = make(map[string][]string)
+		}
+		result[key][emoji] = append(result[key][emoji], username)
+	}
+	return result, rows.Err()
+}
+
+// messageColumns — столбцы, которые читает query, в порядке Scan.
+const messageColumns = `msg_id, parent_msg_id, client_id, username, tenant, room, text, attachments, sent_at`
+
+// query выполняет запрос, возвращающий столбцы messageColumns.
+func (s *sqlStore) query(query string, args ...any) ([]Message, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// scanMessage читает из текущей строки столбцы messageColumns.
+func scanMessage(rows *sql.Rows) (Message, error) {
+	msg := Message{Type: "message"}
+	var attachments string
+	err := rows.Scan(&msg.MsgID, &msg.ParentMsgID, &msg.Sender, &msg.Username, &msg.Tenant, &msg.Room, &msg.Text, &attachments, &msg.SentAt)
+	if err != nil || attachments == "" {
+		return msg, err
+	}
+	err = json.Unmarshal([]byte(attachments), &msg.Attachments)
+	return msg, err
+}
+
+func (s *sqlStore) Count(tenant, room string) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM messages WHERE tenant = $1 AND room = $2`, tenant, room).Scan(&n)
+	return n, err
+}
+
+func (s *sqlStore) DeleteByUsername(tenant, username string, limit int) (int64, error) {
+	result, err := s.db.Exec(
+		`DELETE FROM messages WHERE id IN (SELECT id FROM messages WHERE tenant = $1 AND username = $2 ORDER BY id LIMIT $3)`,
+		tenant, username, limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqlStore) RemoveUserReactions(tenant, username string) error {
+	_, err := s.db.Exec(
+		`DELETE FROM reactions WHERE tenant = $1 AND username = $2`,
+		tenant, username,
+	)
+	return err
+}
+
+func (s *sqlStore) AddDeviceToken(tenant, username, token string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO device_tokens (token, tenant, username, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (token) DO UPDATE SET tenant = excluded.tenant, username = excluded.username, created_at = excluded.created_at`,
+		token, tenant, username, time.Now().UTC(),
+	)
+	return err
+}
+
+func (s *sqlStore) DeviceTokens(tenant, username string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT token FROM device_tokens WHERE tenant = $1 AND username = $2 ORDER BY created_at`, tenant, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []string
+	for rows.Next() {
+		var token string
+		err = rows.Scan(&token)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *sqlStore) RemoveDeviceToken(token string) error {
+	_, err := s.db.Exec(`DELETE FROM device_tokens WHERE token = $1`, token)
+	return err
+}
+
+func (s *sqlStore) RemoveUserDeviceTokens(tenant, username string) error {
+	_, err := s.db.Exec(`DELETE FROM device_tokens WHERE tenant = $1 AND username = $2`, tenant, username)
+	return err
+}
+
+func (s *sqlStore) SetUserEmail(tenant, username, email string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO users (tenant, username, email, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant, username) DO UPDATE SET email = excluded.email, updated_at = excluded.updated_at`,
+		tenant, username, email, time.Now().UTC(),
+	)
+	return err
+}
+
+func (s *sqlStore) User(tenant, username string) (User, bool, error) {
+	user := User{Tenant: tenant, Username: username}
+	err := s.db.QueryRow(`SELECT email FROM users WHERE tenant = $1 AND username = $2`, tenant, username).Scan(&user.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, false, nil
+	}
+	return user, err == nil, err
+}
+
+func (s *sqlStore) DeleteUser(tenant, username string) error {
+	_, err := s.db.Exec(`DELETE FROM users WHERE tenant = $1 AND username = $2`, tenant, username)
+	return err
+}
+
+func (s *sqlStore) AddRoomInvite(invite RoomInvite) error {
+	_, err := s.db.Exec(
+		`INSERT INTO room_invites (tenant, room, username, invited_by, created_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (tenant, room, username) DO UPDATE SET invited_by = excluded.invited_by, created_at = excluded.created_at`,
+		invite.Tenant, invite.Room, invite.Username, invite.InvitedBy, invite.CreatedAt,
+	)
+	return err
+}
+
+func (s *sqlStore) RoomInvites(tenant, room string) ([]RoomInvite, error) {
+	rows, err := s.db.Query(
+		`SELECT username, invited_by, created_at FROM room_invites WHERE tenant = $1 AND room = $2 ORDER BY created_at`,
+		tenant, room,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []RoomInvite
+	for rows.Next() {
+		invite := RoomInvite{Tenant: tenant, Room: room}
+		err = rows.Scan(&invite.Username, &invite.InvitedBy, &invite.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+func (s *sqlStore) RemoveUserRoomInvites(tenant, username string) error {
+	_, err := s.db.Exec(`DELETE FROM room_invites WHERE tenant = $1 AND username = $2`, tenant, username)
+	return err
+}
+
+func (s *sqlStore) CreateDeletionJob(tenant, username string) (DeletionJob, error) {
+	now := time.Now().UTC()
+	job := DeletionJob{Tenant: tenant, Username: username, Status: "pending", CreatedAt: now, UpdatedAt: now}
+	err := s.db.QueryRow(
+		`INSERT INTO deletion_jobs (tenant, username, status, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		job.Tenant, job.Username, job.Status, job.CreatedAt, job.UpdatedAt,
+	).Scan(&job.ID)
+	return job, err
+}
+
+func (s *sqlStore) UpdateDeletionJob(job DeletionJob) error {
+	_, err := s.db.Exec(
+		`UPDATE deletion_jobs SET status = $1, deleted_messages = $2, error = $3, updated_at = $4 WHERE id = $5`,
+		job.Status, job.DeletedMessages, job.Error, job.UpdatedAt, job.ID,
+	)
+	return err
+}
+
+func (s *sqlStore) LastDeletionJob(tenant, username string) (DeletionJob, bool, error) {
+	var job DeletionJob
+	err := s.db.QueryRow(
+		`SELECT id, tenant, username, status, deleted_messages, error, created_at, updated_at FROM deletion_jobs WHERE tenant = $1 AND username = $2 ORDER BY id DESC LIMIT 1`,
+		tenant, username,
+	).Scan(&job.ID, &job.Tenant, &job.Username, &job.Status, &job.DeletedMessages, &job.Error, &job.CreatedAt, &job.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DeletionJob{}, false, nil
+	}
+	return job, err == nil, err
+}
+
+func (s *sqlStore) Purge(before time.Time) (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM messages WHERE sent_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// storeMessage сохраняет сообщение в базу, если она настроена. Личные и системные сообщения
+// не сохраняются, как и сообщения других экземпляров (Redis): их сохраняет экземпляр,
+// которому сообщение отправили, иначе в общей базе каждое сообщение появилось бы по разу на экземпляр.
+func storeMessage(msg Message) {
+	if messageStore == nil || msg.Recipient != "" || msg.Type == "system" || msg.remote {
+		return
+	}
+	err := messageStore.Insert(msg)
+	if err != nil {
+		slog.Error("Ошибка сохранения сообщения в базу", "msg_id", msg.MsgID, "err", err)
+	}
+}
+
+// messageExists сообщает, известно ли серверу сообщение msgID арендатора tenant:
+// есть ли оно в истории общего чата, в кэше комнат или в базе. MsgID выбирает клиент,
+// поэтому сообщения других арендаторов с тем же MsgID не учитываются.
+func (s *Server) messageExists(tenant, msgID string) bool {
+	isMsg := func(msg Message) bool { return msg.Tenant == tenant && msg.MsgID == msgID }
+	if slices.ContainsFunc(s.history.Messages(), isMsg) || roomCache.Contains(tenant, msgID) {
+		return true
+	}
+	if messageStore == nil {
+		return false
+	}
+	ok, err := messageStore.Exists(tenant, msgID)
+	if err != nil {
+		slog.Error("Ошибка поиска сообщения в базе", "msg_id", msgID, "err", err)
+	}
+	return ok
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxTCPFrameSize ограничивает длину одного TCP кадра, чтобы клиент не мог
+// заставить сервер выделить произвольно большой буфер.
+const maxTCPFrameSize = 1 << 20
+
+// tlsHandshakeTimeout ограничивает время TLS рукопожатия с TCP клиентом.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// tcpKeepAlivePeriod — период TCP keepalive проб для клиентских соединений.
+const tcpKeepAlivePeriod = 30 * time.Second
+
+// tcpTransport отправляет сообщения TCP клиенту в виде кадров: 4 байта длины
+// (big-endian uint32), затем JSON указанной длины.
+type tcpTransport struct {
+	conn net.Conn
+	// bytesSent — счетчик отправленных байт клиента (Client.BytesSent); nil — не считать.
+	bytesSent *atomic.Uint64
+	// mutex сериализует запись: отправлять могут несколько горутин одновременно.
+	mutex sync.Mutex
+}
+
+func (t *tcpTransport) Send(msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	err = writeFrame(t.conn, data)
+	if err == nil && t.bytesSent != nil {
+		t.bytesSent.Add(uint64(4 + len(data)))
+	}
+	return err
+}
+
+func (t *tcpTransport) Close() error {
+	return t.conn.Close()
+}
+
+// writeFrame записывает payload с префиксом длины.
+func writeFrame(w io.Writer, payload []byte) error {
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame читает один кадр и возвращает его payload.
+func readFrame(r io.Reader) ([]byte, error) {
+	var length uint32
+	err := binary.Read(r, binary.BigEndian, &length)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxTCPFrameSize {
+		return nil, fmt.Errorf("размер кадра %d превышает лимит %d", length, maxTCPFrameSize)
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+// handleTCPConnection обрабатывает новое TCP соединение.
+// Клиент обменивается с сервером JSON сообщениями того же формата, что и WebSocket,
+// упакованными в кадры с префиксом длины (см. readFrame).
+func (s *Server) handleTCPConnection(conn net.Conn) {
+	defer conn.Close() // Убедимся, что соединение закрыто при выходе из функции
+
+	if blocklist.Blocked(conn.RemoteAddr().String()) {
+		slog.Warn("Отказ в TCP подключении: адрес в списке блокировки", "remote_addr", conn.RemoteAddr().String())
+		return
+	}
+	if !acquireConnSlot() {
+		slog.Warn("Отказ в TCP подключении: достигнут лимит подключений", "remote_addr", conn.RemoteAddr().String(), "max_connections", cap(connSlots))
+		(&tcpTransport{conn: conn}).Send(Message{Type: "error", Text: "сервер перегружен, попробуйте позже"})
+		return
+	}
+	defer releaseConnSlot()
+	tcpConnectionsTotal.Inc()
+
+	// Keepalive позволяет ОС обнаружить оборванные соединения
+	raw := conn
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		raw = tlsConn.NetConn()
+	}
+	if tcpConn, ok := raw.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(tcpKeepAlivePeriod)
+	}
+
+	// Для TLS соединения завершаем рукопожатие сразу, чтобы узнать сертификат клиента
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		err := tlsConn.Handshake()
+		tlsConn.SetDeadline(time.Time{})
+		if err != nil {
+			slog.Warn("Ошибка TLS рукопожатия", "remote_addr", conn.RemoteAddr().String(), "err", err)
+			return
+		}
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			slog.Info("TCP клиент предъявил сертификат", "remote_addr", conn.RemoteAddr().String(), "cn", certs[0].Subject.CommonName)
+		}
+	}
+
+	transport := &tcpTransport{conn: conn}
+	client := s.newClient("tcp", conn.RemoteAddr().String(), transport)
+	transport.bytesSent = &client.BytesSent
+
+	s.serveClient(client, func() (Message, error) {
+		for {
+			// Клиент, молчащий дольше idleTimeout, отключается
+			conn.SetReadDeadline(time.Now().Add(s.config.TCPIdleTimeout.Duration))
+			payload, err := readFrame(conn)
+			if err != nil {
+				return Message{}, err
+			}
+			client.BytesReceived.Add(uint64(4 + len(payload)))
+
+			var msg Message
+			err = json.Unmarshal(payload, &msg)
+			if err != nil {
+				client.logger().Warn("Некорректное TCP сообщение", "err", err)
+				sendError(client, "некорректный JSON: "+err.Error())
+				continue
+			}
+			return msg, nil
+		}
+	})
+}
+
+// authenticateTCP проверяет JWT из кадра регистрации TCP клиента и возвращает имя из поля sub.
+// Арендатор и роль клиента берутся из токена, как у клиентов WebSocket (см. requireAuth).
+func (s *Server) authenticateTCP(client *Client, token string) (string, error) {
+	claims, err := parseJWTClaims(s.config.JWTSecret, token)
+	if err != nil {
+		client.logger().Warn("Отказ в TCP регистрации: ошибка аутентификации", "err", err)
+		auditLog.Record(auditLoginFailed, "", "", client.RemoteAddr, "method", "tcp", "err", err)
+		return "", errors.New("unauthorized: в сообщении register нужен действующий JWT в поле token")
+	}
+	auditLog.Record(auditLogin, claims.Subject, "", client.RemoteAddr, "method", "tcp", "tenant", claims.Tenant)
+	// Арендатора клиента читает рассылка, поэтому он меняется под блокировкой сервера
+	s.mutex.Lock()
+	client.Tenant = claims.Tenant
+	s.mutex.Unlock()
+	client.admin = claims.Role == adminRole
+	client.authenticated = true
+	return claims.Subject, nil
+}
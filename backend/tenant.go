@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// tenantHeader — заголовок, которым клиент без аутентификации выбирает арендатора.
+const tenantHeader = "X-Tenant-ID"
+
+// adminRole — роль из поля role JWT, которой доступны данные всех арендаторов.
+const adminRole = "admin"
+
+// authTenantKey — ключ контекста запроса, под которым requireAuth хранит арендатора из JWT или API ключа.
+type authTenantKey struct{}
+
+// authRoleKey — ключ контекста запроса, под которым requireAuth хранит роль из JWT.
+type authRoleKey struct{}
+
+// tenantName — имя пользователя или комнаты внутри арендатора. Имена уникальны только
+// в пределах арендатора, поэтому клиенты и комнаты индексируются этой парой.
+type tenantName struct {
+	tenant string
+	name   string
+}
+
+// requestTenant возвращает арендатора запроса; пустая строка — арендатор по умолчанию.
+// Клиент, прошедший аутентификацию, принадлежит арендатору из поля tenant JWT (или API ключа),
+// и X-Tenant-ID учитывается, только если у него роль admin. Без аутентификации
+// арендатора выбирает X-Tenant-ID.
+func requestTenant(r *http.Request) string {
+	header := strings.TrimSpace(r.Header.Get(tenantHeader))
+	tenant, ok := r.Context().Value(authTenantKey{}).(string)
+	if !ok || (header != "" && isAdminRole(r.Context())) {
+		return header
+	}
+	return tenant
+}
+
+// isAdminRole сообщает, что у клиента в JWT роль admin.
+func isAdminRole(ctx context.Context) bool {
+	role, _ := ctx.Value(authRoleKey{}).(string)
+	return role == adminRole
+}
+
+// inTenant оставляет в messages только сообщения арендатора tenant. Срез меняется на месте.
+func inTenant(messages []Message, tenant string) []Message {
+	return slices.DeleteFunc(messages, func(msg Message) bool { return msg.Tenant != tenant })
+}
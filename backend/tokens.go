@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// tokenDenylist — JWT, которые уже обменяны на новые через POST /auth/refresh и больше не принимаются.
+// Токены хранятся до истечения их срока: после него parseJWT отвергает их и так.
+// С Redis список общий для всех экземпляров сервера.
+type tokenDenylist struct {
+	mutex  sync.Mutex
+	tokens map[[sha256.Size]byte]time.Time
+}
+
+// deniedTokens — отозванные после обновления JWT.
+var deniedTokens = &tokenDenylist{tokens: make(map[[sha256.Size]byte]time.Time)}
+
+// Add отзывает токен до expiresAt. Возвращает false, если токен уже был отозван —
+// так один токен нельзя обменять дважды, даже параллельными запросами.
+func (d *tokenDenylist) Add(token string, expiresAt time.Time) bool {
+	hash := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if exp, ok := d.tokens[hash]; ok && exp.After(now) {
+		return false
+	}
+	for h, exp := range d.tokens {
+		if !exp.After(now) {
+			delete(d.tokens, h)
+		}
+	}
+
+	if redisClient != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+		added, err := redisClient.SetNX(ctx, deniedTokenKey(hash), 1, time.Until(expiresAt)).Result()
+		if err != nil {
+			slog.Warn("Ошибка записи отозванного токена в Redis, он отозван только на этом экземпляре", "err", err)
+		} else if !added {
+			return false
+		}
+	}
+	d.tokens[hash] = expiresAt
+	return true
+}
+
+// Contains сообщает, отозван ли токен.
+func (d *tokenDenylist) Contains(token string) bool {
+	hash := sha256.Sum256([]byte(token))
+	d.mutex.Lock()
+	exp, ok := d.tokens[hash]
+	d.mutex.Unlock()
+	if ok && exp.After(time.Now()) {
+		return true
+	}
+
+	if redisClient != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+		n, err := redisClient.Exists(ctx, deniedTokenKey(hash)).Result()
+		if err != nil {
+			slog.Warn("Ошибка проверки отозванного токена в Redis", "err", err)
+			return false
+		}
+		return n > 0
+	}
+	return false
+}
+
+// deniedTokenKey — ключ Redis отозванного токена.
+func deniedTokenKey(hash [sha256.Size]byte) string {
+	return "chat:jwt_denied:" + hex.EncodeToString(hash[:])
+}
+
+// handleTokenRefresh обменивает действующий JWT на новый с теми же sub, tenant, role и email и свежим сроком
+// действия (POST /auth/refresh). Старый токен после этого не принимается. Обновить можно
+// только токен, до истечения которого осталось больше RefreshGracePeriod: токен, который
+// вот-вот истечет, слишком долго был в обращении, и пользователю нужно войти заново.
+func (s *Server) handleTokenRefresh(w http.ResponseWriter, r *http.Request) {
+	token := tokenFromRequest(r)
+	claims, err := parseJWTClaims(s.config.JWTSecret, token)
+	if err != nil {
+		slog.Warn("Отказ в обновлении токена", "remote_addr", r.RemoteAddr, "err", err)
+		auditLog.Record(auditRefreshFailed, "", "", r.RemoteAddr, "err", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if claims.ExpiresAt == nil {
+		auditLog.Record(auditRefreshFailed, claims.Subject, "", r.RemoteAddr, "err", "токен без срока действия")
+		http.Error(w, "токен без срока действия не обновляется", http.StatusBadRequest)
+		return
+	}
+	expiresAt := claims.ExpiresAt.Time
+	if time.Until(expiresAt) < s.config.RefreshGracePeriod.Duration {
+		slog.Warn("Отказ в обновлении токена: токен скоро истекает", "remote_addr", r.RemoteAddr, "username", claims.Subject, "expires_at", expiresAt)
+		auditLog.Record(auditRefreshFailed, claims.Subject, "", r.RemoteAddr, "err", "токен скоро истекает")
+		http.Error(w, "токен скоро истекает, войдите заново", http.StatusUnauthorized)
+		return
+	}
+
+	// Новый токен живет столько же, сколько старый
+	ttl := s.config.OAuthTokenTTL.Duration
+	if claims.IssuedAt != nil {
+		ttl = expiresAt.Sub(claims.IssuedAt.Time)
+	}
+	next := chatClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: claims.Subject},
+		Tenant:           claims.Tenant,
+		Role:             claims.Role,
+		Email:            claims.Email,
+	}
+	signed, err := issueJWT(s.config.JWTSecret, next, ttl)
+	if err != nil {
+		slog.Error("Ошибка выпуска JWT", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !deniedTokens.Add(token, expiresAt) {
+		slog.Warn("Отказ в обновлении токена: токен уже обновлен", "remote_addr", r.RemoteAddr, "username", claims.Subject)
+		auditLog.Record(auditRefreshFailed, claims.Subject, "", r.RemoteAddr, "err", "токен уже обновлен")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	slog.Info("Токен обновлен", "username", claims.Subject, "remote_addr", r.RemoteAddr)
+	auditLog.Record(auditRefresh, claims.Subject, "", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"token":      signed,
+		"expires_at": time.Now().Add(ttl).UTC().Truncate(time.Second),
+	})
+}
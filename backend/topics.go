@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Ограничения подписок на темы.
+const (
+	// maxTopicBytes — наибольшая длина темы или фильтра подписки.
+	maxTopicBytes = 256
+	// maxTopicSubscriptions — сколько фильтров может быть у одного клиента.
+	maxTopicSubscriptions = 100
+)
+
+// topicNode — уровень дерева подписок. Дочерние уровни "+" и "#" хранят подписки с шаблонами.
+type topicNode struct {
+	children    map[string]*topicNode
+	subscribers map[*Client]bool
+}
+
+// topicTrie — подписки клиентов на темы вида "sensors/room1/temperature" по фильтрам
+// с шаблонами MQTT: "+" — ровно один уровень, "#" (только последним) — любое число уровней,
+// в том числе ни одного. Темы разных арендаторов не пересекаются.
+type topicTrie struct {
+	mutex sync.RWMutex
+	// roots — корни деревьев подписок по арендатору.
+	roots map[string]*topicNode
+}
+
+func newTopicTrie() *topicTrie {
+	return &topicTrie{roots: make(map[string]*topicNode)}
+}
+
+func newTopicNode() *topicNode {
+	return &topicNode{children: make(map[string]*topicNode), subscribers: make(map[*Client]bool)}
+}
+
+// validateTopicFilter проверяет фильтр подписки: "+" и "#" занимают уровень целиком, "#" — только последний.
+func validateTopicFilter(filter string) error {
+	if filter == "" || len(filter) > maxTopicBytes {
+		return fmt.Errorf("тема должна быть непустой и не длиннее %d байт", maxTopicBytes)
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.ContainsAny(level, "+#") && level != "+" && level != "#" {
+			return errors.New("шаблоны + и # должны занимать уровень темы целиком: " + filter)
+		}
+		if level == "#" && i != len(levels)-1 {
+			return errors.New("шаблон # может быть только последним уровнем темы: " + filter)
+		}
+	}
+	return nil
+}
+
+// validateTopic проверяет тему публикуемого сообщения: шаблоны в ней недопустимы.
+func validateTopic(topic string) error {
+	if topic == "" || len(topic) > maxTopicBytes {
+		return fmt.Errorf("тема должна быть непустой и не длиннее %d байт", maxTopicBytes)
+	}
+	if strings.ContainsAny(topic, "+#") {
+		return errors.New("в теме сообщения нельзя использовать шаблоны + и #: " + topic)
+	}
+	return nil
+}
+
+// Subscribe подписывает клиента на темы арендатора tenant, подходящие под filter.
+func (t *topicTrie) Subscribe(tenant, filter string, client *Client) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	node, ok := t.roots[tenant]
+	if !ok {
+		node = newTopicNode()
+		t.roots[tenant] = node
+	}
+	for _, level := range strings.Split(filter, "/") {
+		child, ok := node.children[level]
+		if !ok {
+			child = newTopicNode()
+			node.children[level] = child
+		}
+		node = child
+	}
+	node.subscribers[client] = true
+}
+
+// Unsubscribe отменяет подписку клиента на filter, удаляя опустевшие уровни дерева.
+func (t *topicTrie) Unsubscribe(tenant, filter string, client *Client) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	root, ok := t.roots[tenant]
+	if !ok {
+		return
+	}
+	levels := strings.Split(filter, "/")
+	path := []*topicNode{root}
+	for _, level := range levels {
+		child, ok := path[len(path)-1].children[level]
+		if !ok {
+			return
+		}
+		path = append(path, child)
+	}
+	delete(path[len(path)-1].subscribers, client)
+
+	for i := len(levels) - 1; i >= 0; i-- {
+		node := path[i+1]
+		if len(node.subscribers) > 0 || len(node.children) > 0 {
+			break
+		}
+		delete(path[i].children, levels[i])
+	}
+	if len(root.children) == 0 {
+		delete(t.roots, tenant)
+	}
+}
+
+// RemoveClient отменяет все подписки клиента арендатора tenant, удаляя опустевшие уровни дерева.
+// В отличие от Unsubscribe не требует списка фильтров клиента: им владеет горутина клиента.
+func (t *topicTrie) RemoveClient(tenant string, client *Client) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	root, ok := t.roots[tenant]
+	if !ok {
+		return
+	}
+	root.remove(client)
+	if len(root.children) == 0 {
+		delete(t.roots, tenant)
+	}
+}
+
+// remove удаляет подписки клиента с уровня и его потомков вместе с опустевшими дочерними уровнями.
+func (n *topicNode) remove(client *Client) {
+	delete(n.subscribers, client)
+	for level, child := range n.children {
+		child.remove(client)
+		if len(child.subscribers) == 0 && len(child.children) == 0 {
+			delete(n.children, level)
+		}
+	}
+}
+
+// Match возвращает клиентов арендатора tenant, подписанных на topic. Клиент, у которого
+// под тему подходят несколько фильтров, возвращается один раз.
+func (t *topicTrie) Match(tenant, topic string) []*Client {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	root, ok := t.roots[tenant]
+	if !ok {
+		return nil
+	}
+	found := make(map[*Client]bool)
+	root.match(strings.Split(topic, "/"), found)
+
+	clients := make([]*Client, 0, len(found))
+	for client := range found {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// match добавляет в found подписчиков уровня и его потомков, подходящих под оставшиеся уровни темы levels.
+func (n *topicNode) match(levels []string, found map[*Client]bool) {
+	if rest, ok := n.children["#"]; ok {
+		for client := range rest.subscribers {
+			found[client] = true
+		}
+	}
+	if len(levels) == 0 {
+		for client := range n.subscribers {
+			found[client] = true
+		}
+		return
+	}
+	if child, ok := n.children[levels[0]]; ok {
+		child.match(levels[1:], found)
+	}
+	if one, ok := n.children["+"]; ok {
+		one.match(levels[1:], found)
+	}
+}
+
+// subscribeTopic обрабатывает сообщение type:"subscribe".
+func subscribeTopic(client *Client, filter string) {
+	err := validateTopicFilter(filter)
+	if err != nil {
+		sendError(client, err.Error())
+		return
+	}
+	if !client.topics[filter] && len(client.topics) >= maxTopicSubscriptions {
+		sendError(client, fmt.Sprintf("слишком много подписок: не больше %d", maxTopicSubscriptions))
+		return
+	}
+
+	client.server.topics.Subscribe(client.Tenant, filter, client)
+	client.topics[filter] = true
+	client.logger().Info("Клиент подписался на тему", "topic", filter)
+	err = client.Send(Message{Type: "subscribed", Topic: filter})
+	if err != nil {
+		client.logger().Error("Ошибка отправки подтверждения подписки", "err", err)
+	}
+}
+
+// unsubscribeTopic обрабатывает сообщение type:"unsubscribe".
+func unsubscribeTopic(client *Client, filter string) {
+	if !client.topics[filter] {
+		sendError(client, "вы не подписаны на тему "+filter)
+		return
+	}
+
+	client.server.topics.Unsubscribe(client.Tenant, filter, client)
+	delete(client.topics, filter)
+	client.logger().Info("Клиент отписался от темы", "topic", filter)
+	err := client.Send(Message{Type: "unsubscribed", Topic: filter})
+	if err != nil {
+		client.logger().Error("Ошибка отправки подтверждения отписки", "err", err)
+	}
+}
+
+// unsubscribeAllTopics отменяет все подписки клиента (при отключении).
+func unsubscribeAllTopics(client *Client) {
+	for filter := range client.topics {
+		client.server.topics.Unsubscribe(client.Tenant, filter, client)
+		delete(client.topics, filter)
+	}
+}
+
+// publishTopic доставляет сообщение с темой только подписчикам, чьи фильтры подходят под нее.
+// Такие сообщения не попадают в историю и кэш комнат: это поток событий, а не переписка.
+func (s *Server) publishTopic(msg Message) {
+	ctx, span := tracer.Start(extractTrace(msg), "ws.send", trace.WithAttributes(
+		attribute.String("msg_id", msg.MsgID),
+		attribute.String("topic", msg.Topic),
+	))
+	defer span.End()
+	msg, err := s.applyMiddleware(ctx, msg)
+	if err != nil {
+		s.rejectMessage(msg, err)
+		return
+	}
+	slog.Info("Сообщение в тему", "topic", msg.Topic, "client_id", msg.Sender, "msg_id", msg.MsgID, "text", msg.Text)
+	s.messageLog.Write(msg)
+
+	for _, client := range s.topics.Match(msg.Tenant, msg.Topic) {
+		if !s.bridge.Forward(msg, client) {
+			continue
+		}
+		if !client.deliver(msg) {
+			// Соединение закрыто; остальное (выход из комнат и т.д.) сделает serveClient
+			s.topics.RemoveClient(msg.Tenant, client)
+		}
+	}
+	observeMessageSize(msg)
+	s.events.Emit(Event{Type: EventMessageBroadcast, Message: msg})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// serviceName — имя сервиса в трассировках.
+const serviceName = "server-7"
+
+// tracer создает спаны обработки сообщений. Пока трассировка не настроена, спаны ничего не делают.
+var tracer = otel.Tracer(serviceName)
+
+// traceContext переносит контекст трассировки между горутинами внутри Message.TraceID.
+var traceContext = propagation.TraceContext{}
+
+// setupTracing включает экспорт трассировок по OTLP/HTTP, если задан OTEL_EXPORTER_OTLP_ENDPOINT
+// (адрес и остальные OTEL_* настройки экспортер читает из окружения сам).
+// Возвращает функцию, которая отправляет накопленные спаны при остановке.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(traceContext)
+	slog.Info("Трассировки экспортируются по OTLP", "endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"))
+	return provider.Shutdown, nil
+}
+
+// injectTrace возвращает контекст трассировки ctx в формате W3C traceparent
+// (пустую строку, если трассировка не ведется).
+func injectTrace(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	traceContext.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// extractTrace восстанавливает контекст трассировки из Message.TraceID.
+func extractTrace(msg Message) context.Context {
+	ctx := context.Background()
+	if msg.TraceID == "" {
+		return ctx
+	}
+	return traceContext.Extract(ctx, propagation.MapCarrier{"traceparent": msg.TraceID})
+}
@@ -0,0 +1,30 @@
+package main
+
+import "time"
+
+// typingDebounce — не чаще одного уведомления о наборе текста от клиента за этот интервал.
+const typingDebounce = time.Second
+
+// handleTyping рассылает уведомление type:"typing" остальным участникам комнаты
+// (или всем клиентам арендатора, если комната не указана). Уведомления не попадают в историю
+// и метрики; слишком частые уведомления молча отбрасываются.
+func handleTyping(client *Client, roomName string) {
+	now := time.Now()
+	if now.Sub(client.lastTypingAt) < typingDebounce {
+		return
+	}
+	client.lastTypingAt = now
+
+	notice := Message{Type: "typing", Username: client.Username, Sender: client.ID, Room: roomName, Tenant: client.Tenant}
+	if roomName == "" {
+		client.server.notifyAll(notice, client)
+		return
+	}
+
+	room, ok := client.rooms[roomName]
+	if !ok {
+		sendError(client, "вы не в комнате "+roomName)
+		return
+	}
+	room.notify(notice, client)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// webhookRetries — сколько раз повторять доставку после 5xx или сетевой ошибки.
+const webhookRetries = 3
+
+// webhookBackoff — пауза перед первым повтором, каждая следующая вдвое длиннее.
+const webhookBackoff = time.Second
+
+var (
+	webhookDeliveriesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_webhook_deliveries_total",
+		Help: "Messages delivered to webhooks.",
+	})
+	webhookFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_webhook_failures_total",
+		Help: "Webhook deliveries that failed after all retries.",
+	})
+)
+
+// setupWebhooks подписывает отправку вебхуков на событие рассылки сообщения, если заданы WEBHOOK_URLS.
+func (s *Server) setupWebhooks() {
+	if len(s.config.WebhookURLs) == 0 {
+		return
+	}
+	s.webhookClient = &http.Client{Timeout: s.config.WebhookTimeout.Duration}
+	s.events.On(EventMessageBroadcast, func(e Event) {
+		// Сообщения с других экземпляров отправляет в вебхуки экземпляр-источник
+		if e.Message.remote {
+			return
+		}
+		s.sendWebhooks(e.Message)
+	})
+	slog.Info("Сообщения отправляются в вебхуки", "count", len(s.config.WebhookURLs))
+}
+
+// sendWebhooks отправляет сообщение во все вебхуки в фоновых горутинах.
+func (s *Server) sendWebhooks(msg Message) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("Ошибка кодирования сообщения для вебхука", "msg_id", msg.MsgID, "err", err)
+		return
+	}
+	for _, url := range s.config.WebhookURLs {
+		go s.deliverWebhook(url, msg.MsgID, body)
+	}
+}
+
+// deliverWebhook отправляет POST с сообщением, повторяя попытку при 5xx и сетевых ошибках.
+func (s *Server) deliverWebhook(url, msgID string, body []byte) {
+	backoff := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.postWebhook(url, body)
+		if err == nil {
+			webhookDeliveriesTotal.Inc()
+			return
+		}
+		if !retry || attempt == webhookRetries {
+			webhookFailuresTotal.Inc()
+			slog.Error("Ошибка доставки вебхука", "url", url, "msg_id", msgID, "attempts", attempt+1, "err", err)
+			return
+		}
+		slog.Warn("Ошибка доставки вебхука, повтор", "url", url, "msg_id", msgID, "attempt", attempt+1, "retry_in", backoff, "err", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// postWebhook выполняет одну попытку доставки. retry сообщает, имеет ли смысл повторять.
+func (s *Server) postWebhook(url string, body []byte) (retry bool, err error) {
+	resp, err := s.webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500, fmt.Errorf("ответ %s", resp.Status)
+	}
+	return false, nil
+}